@@ -220,3 +220,132 @@ func (lcu *LightClientUpdate) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) c
 		&lcu.SignatureSlot,
 	)
 }
+
+func LightClientFinalityUpdateType(spec *common.Spec) *ContainerTypeDef {
+	return ContainerType("LightClientFinalityUpdate", []FieldDef{
+		{"attested_header", common.BeaconBlockHeaderType},
+		{"finalized_header", common.BeaconBlockHeaderType},
+		{"finality_branch", FinalizedRootProofBranchType},
+		{"sync_aggregate", SyncAggregateType(spec)},
+		{"signature_slot", common.SlotType},
+	})
+}
+
+type LightClientFinalityUpdate struct {
+	// Update beacon block header
+	AttestedHeader common.BeaconBlockHeader `yaml:"attested_header" json:"attested_header"`
+	// Finality proof for the update header
+	FinalizedHeader common.BeaconBlockHeader `yaml:"finalized_header" json:"finalized_header"`
+	FinalityBranch  FinalizedRootProofBranch `yaml:"finality_branch" json:"finality_branch"`
+	// Sync committee aggregate signature
+	SyncAggregate SyncAggregate `yaml:"sync_aggregate" json:"sync_aggregate"`
+	// Slot at which the aggregate signature was created (untrusted)
+	SignatureSlot common.Slot `yaml:"signature_slot" json:"signature_slot"`
+}
+
+func (lcu *LightClientFinalityUpdate) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(
+		&lcu.AttestedHeader,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientFinalityUpdate) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(
+		&lcu.AttestedHeader,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientFinalityUpdate) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(
+		&lcu.AttestedHeader,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientFinalityUpdate) FixedLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(
+		&lcu.AttestedHeader,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientFinalityUpdate) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(
+		&lcu.AttestedHeader,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func LightClientOptimisticUpdateType(spec *common.Spec) *ContainerTypeDef {
+	return ContainerType("LightClientOptimisticUpdate", []FieldDef{
+		{"attested_header", common.BeaconBlockHeaderType},
+		{"sync_aggregate", SyncAggregateType(spec)},
+		{"signature_slot", common.SlotType},
+	})
+}
+
+type LightClientOptimisticUpdate struct {
+	// Update beacon block header
+	AttestedHeader common.BeaconBlockHeader `yaml:"attested_header" json:"attested_header"`
+	// Sync committee aggregate signature
+	SyncAggregate SyncAggregate `yaml:"sync_aggregate" json:"sync_aggregate"`
+	// Slot at which the aggregate signature was created (untrusted)
+	SignatureSlot common.Slot `yaml:"signature_slot" json:"signature_slot"`
+}
+
+func (lcu *LightClientOptimisticUpdate) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientOptimisticUpdate) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientOptimisticUpdate) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientOptimisticUpdate) FixedLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *LightClientOptimisticUpdate) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
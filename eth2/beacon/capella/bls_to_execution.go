@@ -24,7 +24,9 @@ func ProcessBLSToExecutionChanges(ctx context.Context, spec *common.Spec, epc *c
 	return nil
 }
 
-func ProcessBLSToExecutionChange(ctx context.Context, spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
+// ValidateBLSToExecutionChange checks that the change is valid against the state:
+// the validator still has BLS withdrawal credentials, of the given BLS pubkey, and the signature is valid.
+func ValidateBLSToExecutionChange(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
 	validators, err := state.Validators()
 	if err != nil {
 		return err
@@ -75,8 +77,23 @@ func ProcessBLSToExecutionChange(ctx context.Context, spec *common.Spec, epc *co
 	if !blsu.Verify(pubKey, sigRoot[:], signature) {
 		return fmt.Errorf("invalid bls to execution change signature")
 	}
+	return nil
+}
+
+func ProcessBLSToExecutionChange(ctx context.Context, spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
+	if err := ValidateBLSToExecutionChange(spec, epc, state, op); err != nil {
+		return err
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	validator, err := validators.Validator(op.BLSToExecutionChange.ValidatorIndex)
+	if err != nil {
+		return err
+	}
 	var newWithdrawalCredentials tree.Root
 	copy(newWithdrawalCredentials[0:1], []byte{common.ETH1_ADDRESS_WITHDRAWAL_PREFIX})
-	copy(newWithdrawalCredentials[12:], addressChange.ToExecutionAddress[:])
+	copy(newWithdrawalCredentials[12:], op.BLSToExecutionChange.ToExecutionAddress[:])
 	return validator.SetWithdrawalCredentials(newWithdrawalCredentials)
 }
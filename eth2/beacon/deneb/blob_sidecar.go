@@ -0,0 +1,179 @@
+package deneb
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/conv"
+	"github.com/protolambda/ztyp/tree"
+	. "github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+const BYTES_PER_FIELD_ELEMENT = 32
+
+func BlobType(spec *common.Spec) *BasicVectorTypeDef {
+	return BasicVectorType(ByteType, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*BYTES_PER_FIELD_ELEMENT)
+}
+
+// Blob is a Vector[byte, FIELD_ELEMENTS_PER_BLOB * BYTES_PER_FIELD_ELEMENT]
+type Blob []byte
+
+func (b *Blob) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.ByteVector((*[]byte)(b), uint64(spec.FIELD_ELEMENTS_PER_BLOB)*BYTES_PER_FIELD_ELEMENT)
+}
+
+func (b Blob) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Write(b)
+}
+
+func (b Blob) ByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT
+}
+
+func (b *Blob) FixedLength(spec *common.Spec) uint64 {
+	return uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT
+}
+
+func (b Blob) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.ByteVectorHTR(b)
+}
+
+func (b Blob) MarshalText() ([]byte, error) {
+	return conv.BytesMarshalText(b)
+}
+
+func (b *Blob) UnmarshalText(text []byte) error {
+	return conv.DynamicBytesUnmarshalText((*[]byte)(b), text)
+}
+
+func (b Blob) String() string {
+	return conv.BytesString(b)
+}
+
+func KZGCommitmentInclusionProofType(spec *common.Spec) *ComplexVectorTypeDef {
+	return ComplexVectorType(RootType, uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+// KZGCommitmentInclusionProof is a Vector[Root, KZG_COMMITMENT_INCLUSION_PROOF_DEPTH],
+// the merkle branch of a KZG commitment in the block body.
+type KZGCommitmentInclusionProof []common.Root
+
+func (p *KZGCommitmentInclusionProof) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return tree.ReadRoots(dr, (*[]common.Root)(p), uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+func (p KZGCommitmentInclusionProof) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return tree.WriteRoots(w, p)
+}
+
+func (p KZGCommitmentInclusionProof) ByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p *KZGCommitmentInclusionProof) FixedLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p KZGCommitmentInclusionProof) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	length := uint64(len(p))
+	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &p[i]
+		}
+		return nil
+	}, length)
+}
+
+func BlobSidecarType(spec *common.Spec) *ContainerTypeDef {
+	return ContainerType("BlobSidecar", []FieldDef{
+		{"index", Uint64Type},
+		{"blob", BlobType(spec)},
+		{"kzg_commitment", common.KZGCommitmentType},
+		{"kzg_proof", common.KZGProofType},
+		{"signed_block_header", common.SignedBeaconBlockHeaderType},
+		{"kzg_commitment_inclusion_proof", KZGCommitmentInclusionProofType(spec)},
+	})
+}
+
+type BlobSidecar struct {
+	// Index of the blob in the block
+	Index                       Uint64View                     `json:"index" yaml:"index"`
+	Blob                        Blob                           `json:"blob" yaml:"blob"`
+	KZGCommitment               common.KZGCommitment           `json:"kzg_commitment" yaml:"kzg_commitment"`
+	KZGProof                    common.KZGProof                `json:"kzg_proof" yaml:"kzg_proof"`
+	SignedBlockHeader           common.SignedBeaconBlockHeader `json:"signed_block_header" yaml:"signed_block_header"`
+	KZGCommitmentInclusionProof KZGCommitmentInclusionProof    `json:"kzg_commitment_inclusion_proof" yaml:"kzg_commitment_inclusion_proof"`
+}
+
+func (sc *BlobSidecar) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(
+		&sc.Index,
+		spec.Wrap(&sc.Blob),
+		&sc.KZGCommitment,
+		&sc.KZGProof,
+		&sc.SignedBlockHeader,
+		spec.Wrap(&sc.KZGCommitmentInclusionProof),
+	)
+}
+
+func (sc *BlobSidecar) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(
+		&sc.Index,
+		spec.Wrap(&sc.Blob),
+		&sc.KZGCommitment,
+		&sc.KZGProof,
+		&sc.SignedBlockHeader,
+		spec.Wrap(&sc.KZGCommitmentInclusionProof),
+	)
+}
+
+func (sc *BlobSidecar) ByteLength(spec *common.Spec) uint64 {
+	return BlobSidecarType(spec).TypeByteLength()
+}
+
+func (sc *BlobSidecar) FixedLength(spec *common.Spec) uint64 {
+	return BlobSidecarType(spec).TypeByteLength()
+}
+
+func (sc *BlobSidecar) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(
+		&sc.Index,
+		spec.Wrap(&sc.Blob),
+		&sc.KZGCommitment,
+		&sc.KZGProof,
+		&sc.SignedBlockHeader,
+		spec.Wrap(&sc.KZGCommitmentInclusionProof),
+	)
+}
+
+// The BeaconBlockBody has 12 fields, padded to 16, a depth of 4 bits.
+const blockBodyDepth = 4
+
+// Index of the blob_kzg_commitments field in the BeaconBlockBody.
+const _blobKZGCommitments = 11
+
+// VerifyInclusionProof checks that the KZG commitment is included at the sidecar index
+// in the blob_kzg_commitments of the block body of the signed block header.
+func (sc *BlobSidecar) VerifyInclusionProof(spec *common.Spec) error {
+	depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	if uint64(len(sc.KZGCommitmentInclusionProof)) != depth {
+		return fmt.Errorf("expected inclusion proof of depth %d, got %d", depth, len(sc.KZGCommitmentInclusionProof))
+	}
+	if uint64(sc.Index) >= uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK) {
+		return fmt.Errorf("blob index %d out of range", sc.Index)
+	}
+	// The proof goes through the commitments list (the +1 is the length mix-in) and then the body container.
+	listDepth := uint64(tree.CoverDepth(uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK)))
+	if blockBodyDepth+1+listDepth != depth {
+		return fmt.Errorf("inclusion proof depth %d does not match the block body", depth)
+	}
+	index := (_blobKZGCommitments << (1 + listDepth)) | uint64(sc.Index)
+	leaf := sc.KZGCommitment.HashTreeRoot(tree.GetHashFn())
+	if !merkle.VerifyMerkleBranch(leaf, sc.KZGCommitmentInclusionProof, depth, index, sc.SignedBlockHeader.Message.BodyRoot) {
+		return fmt.Errorf("invalid KZG commitment inclusion proof for blob %d", sc.Index)
+	}
+	return nil
+}
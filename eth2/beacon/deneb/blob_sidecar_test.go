@@ -0,0 +1,58 @@
+package deneb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestBlobSidecarInclusionProof(t *testing.T) {
+	spec := configs.Minimal
+	var body BeaconBlockBody
+	body.SyncAggregate.SyncCommitteeBits = make([]byte, spec.SYNC_COMMITTEE_SIZE/8)
+	for i := 0; i < 3; i++ {
+		body.BlobKZGCommitments = append(body.BlobKZGCommitments, common.KZGCommitment{0: 0xc0, 1: byte(i)})
+	}
+	var buf bytes.Buffer
+	if err := body.Serialize(spec, codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	bodyView, err := BeaconBlockBodyType(spec).Deserialize(codec.NewDecodingReader(bytes.NewReader(buf.Bytes()), uint64(buf.Len())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hFn := tree.GetHashFn()
+	bodyRoot := body.HashTreeRoot(spec, hFn)
+	if got := bodyView.HashTreeRoot(hFn); got != bodyRoot {
+		t.Fatalf("body view root %s does not match body root %s", got, bodyRoot)
+	}
+
+	depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	listDepth := depth - blockBodyDepth - 1
+	for i := uint64(0); i < 3; i++ {
+		sc := BlobSidecar{Index: view.Uint64View(i), KZGCommitment: body.BlobKZGCommitments[i]}
+		sc.SignedBlockHeader.Message.BodyRoot = bodyRoot
+		// collect the sibling nodes, from the commitment up to the body root
+		g := (uint64(1) << depth) | (_blobKZGCommitments << (1 + listDepth)) | i
+		for ; g > 1; g >>= 1 {
+			node, err := bodyView.Backing().Getter(tree.Gindex64(g ^ 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc.KZGCommitmentInclusionProof = append(sc.KZGCommitmentInclusionProof, node.MerkleRoot(hFn))
+		}
+		if err := sc.VerifyInclusionProof(spec); err != nil {
+			t.Fatalf("blob %d: %v", i, err)
+		}
+		sc.Index = view.Uint64View((i + 1) % 3)
+		if err := sc.VerifyInclusionProof(spec); err == nil {
+			t.Fatalf("blob %d: expected proof for other index to be invalid", i)
+		}
+	}
+}
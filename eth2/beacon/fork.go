@@ -116,6 +116,21 @@ func (s *StandardUpgradeableBeaconState) UpgradeMaybe(ctx context.Context, spec
 
 var _ common.UpgradeableBeaconState = (*StandardUpgradeableBeaconState)(nil)
 
+// ExecutionBlockHash returns the block hash of the execution payload of the block,
+// or zero if the block has no execution payload, e.g. before the merge.
+func ExecutionBlockHash(benv *common.BeaconBlockEnvelope) common.Hash32 {
+	switch x := benv.Body.(type) {
+	case *bellatrix.BeaconBlockBody:
+		return x.ExecutionPayload.BlockHash
+	case *capella.BeaconBlockBody:
+		return x.ExecutionPayload.BlockHash
+	case *deneb.BeaconBlockBody:
+		return x.ExecutionPayload.BlockHash
+	default:
+		return common.Hash32{}
+	}
+}
+
 func EnvelopeToSignedBeaconBlock(benv *common.BeaconBlockEnvelope) (common.SpecObj, error) {
 	switch x := benv.Body.(type) {
 	case *phase0.BeaconBlockBody:
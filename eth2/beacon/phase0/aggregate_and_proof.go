@@ -30,7 +30,9 @@ func AggregateSelectionProofSigningRoot(spec *common.Spec, domainFn common.BLSDo
 	return common.ComputeSigningRoot(slot.HashTreeRoot(tree.GetHashFn()), domain), nil
 }
 
-func ValidateAggregateSelectionProof(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState,
+// ValidateAggregateSelectionProofNoSignature checks if the aggregator is in the committee and selected as aggregator,
+// without verifying the selection proof signature.
+func ValidateAggregateSelectionProofNoSignature(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState,
 	slot common.Slot, commIndex common.CommitteeIndex, aggregator common.ValidatorIndex, selectionProof common.BLSSignature) (bool, error) {
 	// check if the aggregator even exists
 	vals, err := state.Validators()
@@ -60,8 +62,13 @@ func ValidateAggregateSelectionProof(spec *common.Spec, epc *common.EpochsContex
 		return false, nil
 	}
 	// check if the aggregator may actually aggregate
-	if !IsAggregator(spec, uint64(len(comm)), selectionProof) {
-		return false, nil
+	return IsAggregator(spec, uint64(len(comm)), selectionProof), nil
+}
+
+func ValidateAggregateSelectionProof(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState,
+	slot common.Slot, commIndex common.CommitteeIndex, aggregator common.ValidatorIndex, selectionProof common.BLSSignature) (bool, error) {
+	if valid, err := ValidateAggregateSelectionProofNoSignature(spec, epc, state, slot, commIndex, aggregator, selectionProof); err != nil || !valid {
+		return valid, err
 	}
 	// check the selection proof
 	sigRoot, err := AggregateSelectionProofSigningRoot(spec,
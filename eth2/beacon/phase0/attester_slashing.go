@@ -104,34 +104,44 @@ func (li AttesterSlashings) MarshalJSON() ([]byte, error) {
 	return json.Marshal([]AttesterSlashing(li))
 }
 
-func ProcessAttesterSlashing(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, attesterSlashing *AttesterSlashing) error {
+// ValidateAttesterSlashing checks the attester slashing against the state, without applying it,
+// and returns the validators that can be slashed. At least one of the equivocating validators must be slashable.
+func ValidateAttesterSlashing(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, attesterSlashing *AttesterSlashing) ([]common.ValidatorIndex, error) {
 	sa1 := &attesterSlashing.Attestation1
 	sa2 := &attesterSlashing.Attestation2
 
 	if !IsSlashableAttestationData(&sa1.Data, &sa2.Data) {
-		return errors.New("attester slashing has no valid reasoning")
+		return nil, errors.New("attester slashing has no valid reasoning")
 	}
 
 	if err := ValidateIndexedAttestation(spec, epc, state, sa1); err != nil {
-		return errors.New("attestation 1 of attester slashing cannot be verified")
+		return nil, errors.New("attestation 1 of attester slashing cannot be verified")
 	}
 	if err := ValidateIndexedAttestation(spec, epc, state, sa2); err != nil {
-		return errors.New("attestation 2 of attester slashing cannot be verified")
+		return nil, errors.New("attestation 2 of attester slashing cannot be verified")
 	}
 
-	currentEpoch := epc.CurrentEpoch.Epoch
-
-	// keep track of effectiveness
-	slashedAny := false
-	var errorAny error
+	slashable, err := slashableIndices(epc, state, attesterSlashing)
+	if err != nil {
+		return nil, fmt.Errorf("error during attester-slashing validators slashable check: %v", err)
+	}
+	if len(slashable) == 0 {
+		return nil, errors.New("attester slashing is not effective, hence invalid")
+	}
+	return slashable, nil
+}
 
+// slashableIndices returns the validators that attested to both attestations, and can still be slashed.
+func slashableIndices(epc *common.EpochsContext, state common.BeaconState, attesterSlashing *AttesterSlashing) ([]common.ValidatorIndex, error) {
+	currentEpoch := epc.CurrentEpoch.Epoch
 	validators, err := state.Validators()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// run slashings where applicable
-	// use ZigZagJoin for efficient intersection: the indicies are already sorted (as validated above)
-	common.ValidatorSet(sa1.AttestingIndices).ZigZagJoin(common.ValidatorSet(sa2.AttestingIndices), func(i common.ValidatorIndex) {
+	var out []common.ValidatorIndex
+	var errorAny error
+	// use ZigZagJoin for efficient intersection: the indicies are already sorted (as validated)
+	common.ValidatorSet(attesterSlashing.Attestation1.AttestingIndices).ZigZagJoin(common.ValidatorSet(attesterSlashing.Attestation2.AttestingIndices), func(i common.ValidatorIndex) {
 		if errorAny != nil {
 			return
 		}
@@ -143,22 +153,39 @@ func ProcessAttesterSlashing(spec *common.Spec, epc *common.EpochsContext, state
 		if slashable, err := IsSlashable(validator, currentEpoch); err != nil {
 			errorAny = err
 		} else if slashable {
-			if err := SlashValidator(spec, epc, state, i, nil); err != nil {
-				errorAny = err
-			} else {
-				slashedAny = true
-			}
+			out = append(out, i)
 		}
 	}, nil)
-	if errorAny != nil {
-		return fmt.Errorf("error during attester-slashing validators slashable check: %v", errorAny)
+	return out, errorAny
+}
+
+func ProcessAttesterSlashing(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, attesterSlashing *AttesterSlashing) error {
+	slashable, err := ValidateAttesterSlashing(spec, epc, state, attesterSlashing)
+	if err != nil {
+		return err
 	}
-	if !slashedAny {
-		return errors.New("attester slashing %d is not effective, hence invalid")
+	// run slashings where applicable
+	for _, i := range slashable {
+		if err := SlashValidator(spec, epc, state, i, nil); err != nil {
+			return fmt.Errorf("error during attester-slashing: %v", err)
+		}
 	}
 	return nil
 }
 
+// EquivocatingIndices returns the validators that attested to both attestations,
+// or nil if the attestations are not slashable. Signatures are not verified.
+func (a *AttesterSlashing) EquivocatingIndices() []common.ValidatorIndex {
+	if !IsSlashableAttestationData(&a.Attestation1.Data, &a.Attestation2.Data) {
+		return nil
+	}
+	var out []common.ValidatorIndex
+	common.ValidatorSet(a.Attestation1.AttestingIndices).ZigZagJoin(common.ValidatorSet(a.Attestation2.AttestingIndices), func(i common.ValidatorIndex) {
+		out = append(out, i)
+	}, nil)
+	return out
+}
+
 func IsSlashableAttestationData(a *AttestationData, b *AttestationData) bool {
 	return IsSurroundVote(a, b) || IsDoubleVote(a, b)
 }
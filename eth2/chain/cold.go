@@ -0,0 +1,603 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/db"
+	"github.com/protolambda/ztyp/codec"
+)
+
+type ColdChain interface {
+	// Start of the stored chain, inclusive.
+	Start() common.Step
+	// End of the stored chain, exclusive.
+	End() common.Step
+	ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool)
+	ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool)
+	ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool)
+	Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool)
+	ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool)
+	Iter() (beacon.ChainIter, error)
+	// Last returns the last stored entry, e.g. to restart a hot chain from after a restart.
+	Last() (entry beacon.ChainEntry, ok bool)
+	Genesis() beacon.GenesisInfo
+	// OnFinalizedEntry adds the next finalized entry to the chain.
+	// Entries must be added in order, entries before End() are ignored.
+	// The block may be nil if the entry is an empty slot, or if the block is not available (e.g. the anchor).
+	OnFinalizedEntry(ctx context.Context, entry beacon.ChainEntry, block *common.BeaconBlockEnvelope) error
+}
+
+// Storage keys are prefixed with a single byte, to separate the different kinds of data.
+const (
+	// meta: start step, end step, genesis time and genesis validators root
+	prefixMeta byte = 'm'
+	// step -> block root, parent root, state root
+	prefixEntry byte = 'e'
+	// block root -> fork digest and SSZ encoded signed block
+	prefixBlock byte = 'b'
+	// block root -> first step of the block root
+	prefixBlockStep byte = 'k'
+	// state root -> step
+	prefixStateStep byte = 'r'
+	// step -> fork version and SSZ encoded state
+	prefixSnapshot byte = 's'
+)
+
+func rootKey(prefix byte, root common.Root) []byte {
+	key := make([]byte, 1+32)
+	key[0] = prefix
+	copy(key[1:], root[:])
+	return key
+}
+
+func stepKey(prefix byte, step common.Step) []byte {
+	key := make([]byte, 1+8)
+	key[0] = prefix
+	// big-endian, to keep the keys sorted by step
+	binary.BigEndian.PutUint64(key[1:], uint64(step))
+	return key
+}
+
+type ColdEntry struct {
+	chain      *FinalizedChain
+	step       common.Step
+	blockRoot  common.Root
+	parentRoot common.Root
+	stateRoot  common.Root
+}
+
+var _ beacon.ChainEntry = (*ColdEntry)(nil)
+
+func (e *ColdEntry) Step() common.Step {
+	return e.step
+}
+
+func (e *ColdEntry) BlockRoot() (common.Root, error) {
+	return e.blockRoot, nil
+}
+
+func (e *ColdEntry) ParentRoot() (common.Root, error) {
+	return e.parentRoot, nil
+}
+
+func (e *ColdEntry) StateRoot() (common.Root, error) {
+	return e.stateRoot, nil
+}
+
+// EpochsContext is rebuilt from the state, this is expensive.
+func (e *ColdEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	state, err := e.State(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return common.NewEpochsContext(e.chain.spec, state)
+}
+
+// State loads the closest snapshot, and replays the blocks and slots after it, up to this entry.
+func (e *ColdEntry) State(ctx context.Context) (common.BeaconState, error) {
+	return e.chain.stateAt(ctx, e.step)
+}
+
+// Block loads the block of the entry, or nil if the entry is an empty slot, or has no block stored.
+func (e *ColdEntry) Block() (*common.BeaconBlockEnvelope, error) {
+	if !e.step.Block() {
+		return nil, nil
+	}
+	return e.chain.block(e.blockRoot)
+}
+
+// FinalizedChain stores the finalized chain in a key-value store:
+// every entry, every block, and a full state snapshot every snapshotInterval slots.
+// States in-between snapshots are recomputed on demand.
+type FinalizedChain struct {
+	sync.RWMutex
+	spec             *common.Spec
+	store            db.KeyValueStore
+	snapshotInterval common.Slot
+	// Start and end steps, start inclusive, end exclusive. Both zero if nothing is stored yet.
+	start   common.Step
+	end     common.Step
+	genesis beacon.GenesisInfo
+	decoder *beacon.ForkDecoder
+}
+
+var _ ColdChain = (*FinalizedChain)(nil)
+var _ HotEntrySink = (*FinalizedChain)(nil)
+
+// NewFinalizedChain opens the finalized chain in the given store, continuing where it previously left off, if any.
+// A state snapshot is stored every snapshotInterval slots, a lower interval trades storage for faster state access.
+func NewFinalizedChain(spec *common.Spec, store db.KeyValueStore, snapshotInterval common.Slot) (*FinalizedChain, error) {
+	if snapshotInterval == 0 {
+		return nil, errors.New("snapshot interval must be non-zero")
+	}
+	fc := &FinalizedChain{
+		spec:             spec,
+		store:            store,
+		snapshotInterval: snapshotInterval,
+	}
+	meta, ok, err := store.Get([]byte{prefixMeta})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chain metadata: %v", err)
+	}
+	if ok {
+		if len(meta) != 8+8+8+32 {
+			return nil, fmt.Errorf("invalid chain metadata length: %d", len(meta))
+		}
+		fc.start = common.Step(binary.BigEndian.Uint64(meta[0:8]))
+		fc.end = common.Step(binary.BigEndian.Uint64(meta[8:16]))
+		fc.genesis.Time = common.Timestamp(binary.BigEndian.Uint64(meta[16:24]))
+		copy(fc.genesis.ValidatorsRoot[:], meta[24:56])
+		fc.decoder = beacon.NewForkDecoder(spec, fc.genesis.ValidatorsRoot)
+	}
+	return fc, nil
+}
+
+func (fc *FinalizedChain) putMeta() error {
+	meta := make([]byte, 8+8+8+32)
+	binary.BigEndian.PutUint64(meta[0:8], uint64(fc.start))
+	binary.BigEndian.PutUint64(meta[8:16], uint64(fc.end))
+	binary.BigEndian.PutUint64(meta[16:24], uint64(fc.genesis.Time))
+	copy(meta[24:56], fc.genesis.ValidatorsRoot[:])
+	return fc.store.Put([]byte{prefixMeta}, meta)
+}
+
+func (fc *FinalizedChain) Start() common.Step {
+	fc.RLock()
+	defer fc.RUnlock()
+	return fc.start
+}
+
+func (fc *FinalizedChain) End() common.Step {
+	fc.RLock()
+	defer fc.RUnlock()
+	return fc.end
+}
+
+func (fc *FinalizedChain) Genesis() beacon.GenesisInfo {
+	fc.RLock()
+	defer fc.RUnlock()
+	return fc.genesis
+}
+
+// OnPrunedEntry stores the canonical entries pruned from a hot chain, non-canonical entries are ignored.
+func (fc *FinalizedChain) OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error {
+	if !canonical {
+		return nil
+	}
+	return fc.OnFinalizedEntry(ctx, entry, entry.Block())
+}
+
+func (fc *FinalizedChain) OnFinalizedEntry(ctx context.Context, entry beacon.ChainEntry, block *common.BeaconBlockEnvelope) error {
+	fc.Lock()
+	defer fc.Unlock()
+	step := entry.Step()
+	empty := fc.end == 0
+	if !empty && step < fc.end {
+		// already stored
+		return nil
+	}
+	blockRoot, err := entry.BlockRoot()
+	if err != nil {
+		return err
+	}
+	parentRoot, err := entry.ParentRoot()
+	if err != nil {
+		return err
+	}
+	stateRoot, err := entry.StateRoot()
+	if err != nil {
+		return err
+	}
+	if empty {
+		state, err := entry.State(ctx)
+		if err != nil {
+			return err
+		}
+		genesisTime, err := state.GenesisTime()
+		if err != nil {
+			return err
+		}
+		genesisValRoot, err := state.GenesisValidatorsRoot()
+		if err != nil {
+			return err
+		}
+		fc.genesis = beacon.GenesisInfo{Time: genesisTime, ValidatorsRoot: genesisValRoot}
+		fc.decoder = beacon.NewForkDecoder(fc.spec, genesisValRoot)
+	}
+	if step.Block() && block != nil {
+		if block.BlockRoot != blockRoot {
+			return fmt.Errorf("block %s does not match entry block root %s", block.BlockRoot, blockRoot)
+		}
+		if err := fc.putBlock(block); err != nil {
+			return fmt.Errorf("failed to store block %s: %v", blockRoot, err)
+		}
+	}
+	// The first entry of the chain always has a snapshot, later entries are replayed from there.
+	if empty || step.Slot()%fc.snapshotInterval == 0 {
+		state, err := entry.State(ctx)
+		if err != nil {
+			return err
+		}
+		if err := fc.putSnapshot(step, state); err != nil {
+			return fmt.Errorf("failed to store state snapshot at step %s: %v", step, err)
+		}
+	}
+	var entryData [3 * 32]byte
+	copy(entryData[0:32], blockRoot[:])
+	copy(entryData[32:64], parentRoot[:])
+	copy(entryData[64:96], stateRoot[:])
+	if err := fc.store.Put(stepKey(prefixEntry, step), entryData[:]); err != nil {
+		return err
+	}
+	stepData := stepKey(0, step)[1:]
+	if _, ok, err := fc.store.Get(rootKey(prefixBlockStep, blockRoot)); err != nil {
+		return err
+	} else if !ok {
+		if err := fc.store.Put(rootKey(prefixBlockStep, blockRoot), stepData); err != nil {
+			return err
+		}
+	}
+	if err := fc.store.Put(rootKey(prefixStateStep, stateRoot), stepData); err != nil {
+		return err
+	}
+	// Update the metadata last, so an interrupted write does not leave the chain in an inconsistent state.
+	prevStart, prevEnd := fc.start, fc.end
+	if empty {
+		fc.start = step
+	}
+	fc.end = step + 1
+	if err := fc.putMeta(); err != nil {
+		fc.start, fc.end = prevStart, prevEnd
+		return err
+	}
+	return nil
+}
+
+func (fc *FinalizedChain) putBlock(block *common.BeaconBlockEnvelope) error {
+	signed, err := beacon.EnvelopeToSignedBeaconBlock(block)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(block.ForkDigest[:])
+	if err := signed.Serialize(fc.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	return fc.store.Put(rootKey(prefixBlock, block.BlockRoot), buf.Bytes())
+}
+
+func (fc *FinalizedChain) block(root common.Root) (*common.BeaconBlockEnvelope, error) {
+	data, ok, err := fc.store.Get(rootKey(prefixBlock, root))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid stored block %s", root)
+	}
+	var digest common.ForkDigest
+	copy(digest[:], data[:4])
+	alloc, err := fc.decoder.BlockAllocator(digest)
+	if err != nil {
+		return nil, err
+	}
+	signed := alloc()
+	if err := signed.Deserialize(fc.spec, codec.NewDecodingReader(bytes.NewReader(data[4:]), uint64(len(data)-4))); err != nil {
+		return nil, fmt.Errorf("failed to decode stored block %s: %v", root, err)
+	}
+	return signed.Envelope(fc.spec, digest), nil
+}
+
+func (fc *FinalizedChain) putSnapshot(step common.Step, state common.BeaconState) error {
+	fork, err := state.Fork()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(fork.CurrentVersion[:])
+	if err := state.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	return fc.store.Put(stepKey(prefixSnapshot, step), buf.Bytes())
+}
+
+func (fc *FinalizedChain) snapshot(step common.Step) (common.BeaconState, bool, error) {
+	data, ok, err := fc.store.Get(stepKey(prefixSnapshot, step))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if len(data) < 4 {
+		return nil, false, fmt.Errorf("invalid stored state at step %s", step)
+	}
+	var version common.Version
+	copy(version[:], data[:4])
+	state, err := decodeState(fc.spec, version, data[4:])
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode stored state at step %s: %v", step, err)
+	}
+	return state, true, nil
+}
+
+func decodeState(spec *common.Spec, version common.Version, data []byte) (common.BeaconState, error) {
+	dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+	switch version {
+	case spec.GENESIS_FORK_VERSION:
+		return phase0.AsBeaconStateView(phase0.BeaconStateType(spec).Deserialize(dr))
+	case spec.ALTAIR_FORK_VERSION:
+		return altair.AsBeaconStateView(altair.BeaconStateType(spec).Deserialize(dr))
+	case spec.BELLATRIX_FORK_VERSION:
+		return bellatrix.AsBeaconStateView(bellatrix.BeaconStateType(spec).Deserialize(dr))
+	case spec.CAPELLA_FORK_VERSION:
+		return capella.AsBeaconStateView(capella.BeaconStateType(spec).Deserialize(dr))
+	case spec.DENEB_FORK_VERSION:
+		return deneb.AsBeaconStateView(deneb.BeaconStateType(spec).Deserialize(dr))
+	default:
+		return nil, fmt.Errorf("unrecognized fork version: %s", version)
+	}
+}
+
+// stateAt finds the closest snapshot at or before the step, and replays the chain from there.
+func (fc *FinalizedChain) stateAt(ctx context.Context, step common.Step) (common.BeaconState, error) {
+	fc.RLock()
+	start, end := fc.start, fc.end
+	fc.RUnlock()
+	if step < start || step >= end {
+		return nil, fmt.Errorf("step %s is out of range [%s, %s)", step, start, end)
+	}
+	// Snapshots are stored at the start of the chain, which may not be aligned to the interval,
+	// and at every multiple of the interval after that. Try the latest candidates first.
+	var candidates []common.Step
+	for slot := step.Slot() - step.Slot()%fc.snapshotInterval; slot >= start.Slot(); slot -= fc.snapshotInterval {
+		for _, candidate := range []common.Step{common.AsStep(slot, true), common.AsStep(slot, false)} {
+			if candidate <= step && candidate > start {
+				candidates = append(candidates, candidate)
+			}
+		}
+		if slot < fc.snapshotInterval {
+			break
+		}
+	}
+	candidates = append(candidates, start)
+	var state common.BeaconState
+	var from common.Step
+	for _, candidate := range candidates {
+		s, ok, err := fc.snapshot(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			state, from = s, candidate
+			break
+		}
+	}
+	if state == nil {
+		// the start of the chain always has a snapshot, if we get here the store is corrupted.
+		return nil, fmt.Errorf("no state snapshot found for step %s", step)
+	}
+	if from == step {
+		return state, nil
+	}
+	epc, err := common.NewEpochsContext(fc.spec, state)
+	if err != nil {
+		return nil, err
+	}
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	for slot := from.Slot(); slot <= step.Slot(); slot++ {
+		if slot > from.Slot() {
+			if err := common.ProcessSlots(ctx, fc.spec, epc, upgradeable, slot); err != nil {
+				return nil, fmt.Errorf("failed to replay slot %d: %v", slot, err)
+			}
+		}
+		blockStep := common.AsStep(slot, true)
+		if blockStep <= from || blockStep > step {
+			continue
+		}
+		e, ok, err := fc.entry(blockStep)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		block, err := fc.block(e.blockRoot)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			return nil, fmt.Errorf("missing block %s to replay step %s", e.blockRoot, blockStep)
+		}
+		if err := common.PostSlotTransition(ctx, fc.spec, epc, upgradeable.BeaconState, block, false); err != nil {
+			return nil, fmt.Errorf("failed to replay block %s: %v", e.blockRoot, err)
+		}
+	}
+	return upgradeable.BeaconState, nil
+}
+
+func (fc *FinalizedChain) entry(step common.Step) (*ColdEntry, bool, error) {
+	data, ok, err := fc.store.Get(stepKey(prefixEntry, step))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if len(data) != 3*32 {
+		return nil, false, fmt.Errorf("invalid stored entry at step %s", step)
+	}
+	e := &ColdEntry{chain: fc, step: step}
+	copy(e.blockRoot[:], data[0:32])
+	copy(e.parentRoot[:], data[32:64])
+	copy(e.stateRoot[:], data[64:96])
+	return e, true, nil
+}
+
+func (fc *FinalizedChain) stepByRoot(prefix byte, root common.Root) (common.Step, bool) {
+	data, ok, err := fc.store.Get(rootKey(prefix, root))
+	if err != nil || !ok || len(data) != 8 {
+		return 0, false
+	}
+	return common.Step(binary.BigEndian.Uint64(data)), true
+}
+
+func (fc *FinalizedChain) ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	step, ok := fc.stepByRoot(prefixStateStep, root)
+	if !ok {
+		return nil, false
+	}
+	e, ok, err := fc.entry(step)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (fc *FinalizedChain) ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	step, ok := fc.stepByRoot(prefixBlockStep, root)
+	if !ok {
+		return nil, false
+	}
+	e, ok, err := fc.entry(step)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (fc *FinalizedChain) ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	// The block itself, if it is at this slot, otherwise an empty slot after the block.
+	for _, step := range []common.Step{common.AsStep(slot, true), common.AsStep(slot, false)} {
+		e, ok, err := fc.entry(step)
+		if err != nil {
+			return nil, false
+		}
+		if ok && e.blockRoot == root {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (fc *FinalizedChain) Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	step, ok := fc.stepByRoot(prefixBlockStep, fromBlockRoot)
+	if !ok || step.Slot() > toSlot {
+		return nil, false
+	}
+	closest, ok, err := fc.entry(step)
+	if err != nil || !ok {
+		return nil, false
+	}
+	// Walk the empty slots after the block, until the next block or the requested slot.
+	for slot := step.Slot() + 1; slot <= toSlot; slot++ {
+		e, ok, err := fc.entry(common.AsStep(slot, false))
+		if err != nil || !ok || e.blockRoot != fromBlockRoot {
+			break
+		}
+		closest = e
+	}
+	return closest, true
+}
+
+func (fc *FinalizedChain) ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool) {
+	fc.RLock()
+	start, end := fc.start, fc.end
+	fc.RUnlock()
+	if step < start || step >= end {
+		return nil, false
+	}
+	e, ok, err := fc.entry(step)
+	if err != nil {
+		return nil, false
+	}
+	if !ok {
+		if step.Block() {
+			// the slot is stored, but there is no block in it.
+			return nil, true
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+func (fc *FinalizedChain) Last() (entry beacon.ChainEntry, ok bool) {
+	fc.RLock()
+	end := fc.end
+	fc.RUnlock()
+	if end == 0 {
+		return nil, false
+	}
+	e, ok, err := fc.entry(end - 1)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+type coldChainIter struct {
+	chain *FinalizedChain
+	start common.Step
+	end   common.Step
+}
+
+func (iter *coldChainIter) Start() common.Step {
+	return iter.start
+}
+
+func (iter *coldChainIter) End() common.Step {
+	return iter.end
+}
+
+func (iter *coldChainIter) Entry(step common.Step) (entry beacon.ChainEntry, err error) {
+	if step < iter.start || step >= iter.end {
+		return nil, fmt.Errorf("step %s is out of range [%s, %s)", step, iter.start, iter.end)
+	}
+	e, ok, err := iter.chain.entry(step)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if step.Block() {
+			// empty slot, no block
+			return nil, nil
+		}
+		return nil, fmt.Errorf("missing entry for step %s", step)
+	}
+	return e, nil
+}
+
+// Iter iterates over the stored range at the time of the call. The chain may grow meanwhile.
+func (fc *FinalizedChain) Iter() (beacon.ChainIter, error) {
+	fc.RLock()
+	defer fc.RUnlock()
+	return &coldChainIter{chain: fc, start: fc.start, end: fc.end}, nil
+}
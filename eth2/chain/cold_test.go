@@ -0,0 +1,195 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/db"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func TestFinalizedChainRestart(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisRoot, _ := head.BlockRoot()
+	if _, err := uc.Towards(ctx, genesisRoot, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	store, err := db.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := NewFinalizedChain(spec, store, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotIter, err := uc.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := hotIter.Start(); step < hotIter.End(); step++ {
+		e, err := hotIter.Entry(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			continue
+		}
+		if err := fc.OnFinalizedEntry(ctx, e, nil); err != nil {
+			t.Fatalf("failed to add entry %s: %v", step, err)
+		}
+	}
+	// re-adding is a no-op
+	if err := fc.OnFinalizedEntry(ctx, head, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the store, as if the node restarted
+	store, err = db.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err = NewFinalizedChain(spec, store, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fc.Start() != hotIter.Start() || fc.End() != hotIter.End() {
+		t.Fatalf("unexpected range: %s - %s", fc.Start(), fc.End())
+	}
+	if fc.Genesis() != uc.Genesis() {
+		t.Fatal("genesis info mismatch")
+	}
+	if last, ok := fc.Last(); !ok || last.Step() != common.AsStep(6, false) {
+		t.Fatal("expected last entry at slot 6")
+	}
+	if canon, ok := fc.ByCanonStep(common.AsStep(3, true)); !ok || canon != nil {
+		t.Fatal("expected no block at slot 3")
+	}
+	if closest, ok := fc.Closest(genesisRoot, 5); !ok || closest.Step() != common.AsStep(5, false) {
+		t.Fatal("expected closest entry at slot 5")
+	}
+	if e, ok := fc.ByBlock(genesisRoot); !ok || e.Step() != common.AsStep(0, true) {
+		t.Fatal("expected to find genesis by block root")
+	}
+
+	// states are replayed from the closest snapshot, and must match the hot chain
+	coldIter, err := fc.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := coldIter.Start(); step < coldIter.End(); step++ {
+		e, err := coldIter.Entry(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			continue
+		}
+		stateRoot, _ := e.StateRoot()
+		if found, ok := fc.ByStateRoot(stateRoot); !ok || found.Step() != step {
+			t.Fatalf("expected to find %s by state root", step)
+		}
+		state, err := e.State(ctx)
+		if err != nil {
+			t.Fatalf("failed to load state %s: %v", step, err)
+		}
+		if got := state.HashTreeRoot(tree.GetHashFn()); got != stateRoot {
+			t.Fatalf("state root mismatch at %s: %s <> %s", step, got, stateRoot)
+		}
+	}
+}
+
+func TestFinalizedChainUnalignedStart(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocks with attestations, and empty slots 4 and 8 in between
+	for _, slot := range []common.Slot{1, 2, 3, 5, 6, 7, 9, 10} {
+		block := testBlock(t, ctx, spec, parent, slot, true, 0)
+		if err := uc.AddBlock(ctx, block); err != nil {
+			t.Fatalf("failed to add block %d: %v", slot, err)
+		}
+		parent, _ = uc.ByBlock(block.BlockRoot)
+	}
+
+	store, err := db.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := NewFinalizedChain(spec, store, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hotIter, err := uc.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cold chain starts at slot 1, not aligned to the snapshot interval
+	start := common.AsStep(1, true)
+	blocks := 0
+	for step := start; step < hotIter.End(); step++ {
+		e, err := hotIter.Entry(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			continue
+		}
+		block := e.(*HotEntry).Block()
+		if block != nil {
+			blocks++
+		}
+		if err := fc.OnFinalizedEntry(ctx, e, block); err != nil {
+			t.Fatalf("failed to add entry %s: %v", step, err)
+		}
+	}
+	if blocks != 8 {
+		t.Fatalf("expected 8 blocks, got %d", blocks)
+	}
+	if fc.Start() != start {
+		t.Fatalf("unexpected start: %s", fc.Start())
+	}
+	for step := start; step < fc.End(); step++ {
+		hotEntry, err := hotIter.Entry(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, ok := fc.ByCanonStep(step)
+		if !ok {
+			t.Fatalf("missing entry %s", step)
+		}
+		if (e == nil) != (hotEntry == nil) {
+			t.Fatalf("unexpected entry at %s", step)
+		}
+		if e == nil {
+			continue
+		}
+		stateRoot, _ := e.StateRoot()
+		state, err := e.State(ctx)
+		if err != nil {
+			t.Fatalf("failed to load state %s: %v", step, err)
+		}
+		if got := state.HashTreeRoot(tree.GetHashFn()); got != stateRoot {
+			t.Fatalf("state root mismatch at %s: %s <> %s", step, got, stateRoot)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package chain
+
+import (
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+// ChainEvent is any of the event types below, use a type switch to handle them.
+type ChainEvent interface {
+	chainEvent()
+}
+
+// HeadEvent is emitted when the head of the chain changes.
+// The head may be an empty slot, or a block.
+type HeadEvent struct {
+	OldHead beacon.ChainEntry
+	NewHead beacon.ChainEntry
+	// ReorgDepth is the number of slots from the old head back to the last block shared with the new head.
+	// Zero if the new head builds on the old head block.
+	ReorgDepth uint64
+}
+
+// BlockImportedEvent is emitted when a block is processed and added to the chain.
+// The block does not have to be canonical.
+type BlockImportedEvent struct {
+	Entry beacon.ChainEntry
+	Block *common.BeaconBlockEnvelope
+}
+
+type JustifiedEvent struct {
+	Old common.Checkpoint
+	New common.Checkpoint
+}
+
+type FinalizedEvent struct {
+	Old common.Checkpoint
+	New common.Checkpoint
+}
+
+// PrunedEvent is emitted for every forkchoice node that is pruned after finalization.
+type PrunedEvent struct {
+	Ref forkchoice.NodeRef
+	// Canonical if the node is part of the finalized chain, false if it was on a dead branch.
+	Canonical bool
+}
+
+func (*HeadEvent) chainEvent()          {}
+func (*BlockImportedEvent) chainEvent() {}
+func (*JustifiedEvent) chainEvent()     {}
+func (*FinalizedEvent) chainEvent()     {}
+func (*PrunedEvent) chainEvent()        {}
+
+// EventSource is implemented by chains that push events to subscribers.
+type EventSource interface {
+	// Subscribe to the events of the chain. The buffer size determines how many events
+	// can be queued before events are dropped for this subscriber.
+	Subscribe(buffer int) *Subscription
+}
+
+type Subscription struct {
+	feed    *EventFeed
+	ch      chan ChainEvent
+	dropped uint64
+}
+
+// Events returns the channel to receive events from. It is closed when unsubscribing.
+func (s *Subscription) Events() <-chan ChainEvent {
+	return s.ch
+}
+
+// Dropped returns the number of events that were dropped because the subscriber was too slow.
+func (s *Subscription) Dropped() uint64 {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	return s.dropped
+}
+
+// Unsubscribe stops the subscription and closes the events channel. Safe to call multiple times.
+func (s *Subscription) Unsubscribe() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	if _, ok := s.feed.subs[s]; ok {
+		delete(s.feed.subs, s)
+		close(s.ch)
+	}
+}
+
+// EventFeed distributes events to subscribers.
+// Sending never blocks: the chain emits events while processing,
+// a subscriber that does not keep up misses events instead of stalling the chain.
+type EventFeed struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+var _ EventSource = (*EventFeed)(nil)
+
+func (f *EventFeed) Subscribe(buffer int) *Subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[*Subscription]struct{})
+	}
+	s := &Subscription{feed: f, ch: make(chan ChainEvent, buffer)}
+	f.subs[s] = struct{}{}
+	return s
+}
+
+func (f *EventFeed) Send(ev ChainEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped++
+		}
+	}
+}
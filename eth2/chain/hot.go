@@ -0,0 +1,771 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+	"github.com/protolambda/ztyp/tree"
+)
+
+type HotChain interface {
+	beacon.Chain
+	EventSource
+	// Process a block. The parent block must be known.
+	// If there is an error, the block is not added, but the chain can continue to be used.
+	AddBlock(ctx context.Context, block *common.BeaconBlockEnvelope) error
+	// Process an attestation. The attestation is expected to be validated already (e.g. by gossip validation).
+	// If there is an error, no votes are added, but the chain can continue to be used.
+	AddAttestation(ctx context.Context, att *phase0.Attestation) error
+	// Process an attester slashing, to discount the votes of the equivocating validators.
+	// The slashing is expected to be validated already (e.g. by gossip validation).
+	AddAttesterSlashing(ctx context.Context, sl *phase0.AttesterSlashing) error
+}
+
+type HotEntry struct {
+	step       common.Step
+	epc        *common.EpochsContext
+	state      common.BeaconState
+	stateRoot  common.Root
+	blockRoot  common.Root
+	parentRoot common.Root
+	// nil if the entry is an empty slot, or the anchor of the chain
+	block *common.BeaconBlockEnvelope
+}
+
+var _ beacon.ChainEntry = (*HotEntry)(nil)
+
+func (e *HotEntry) Step() common.Step {
+	return e.step
+}
+
+func (e *HotEntry) BlockRoot() (common.Root, error) {
+	return e.blockRoot, nil
+}
+
+func (e *HotEntry) ParentRoot() (common.Root, error) {
+	return e.parentRoot, nil
+}
+
+func (e *HotEntry) StateRoot() (common.Root, error) {
+	return e.stateRoot, nil
+}
+
+func (e *HotEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	return e.epc.Clone(), nil
+}
+
+func (e *HotEntry) State(ctx context.Context) (common.BeaconState, error) {
+	// Return a copy of the view, the state itself may not be modified
+	return e.state.CopyState()
+}
+
+// Block returns the block that was processed to get to this entry, or nil if this entry is an empty slot.
+// The anchor of the chain has no block either.
+func (e *HotEntry) Block() *common.BeaconBlockEnvelope {
+	return e.block
+}
+
+func (e *HotEntry) ref() forkchoice.NodeRef {
+	return forkchoice.NodeRef{Root: e.blockRoot, Slot: e.step.Slot()}
+}
+
+// HotEntrySink receives the entries that are pruned from the hot chain.
+// Entries are pruned when they conflict with, or are older than, a new finalized checkpoint.
+// Canonical entries are ancestors of the new finalized checkpoint.
+type HotEntrySink interface {
+	OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error
+}
+
+type HotEntrySinkFn func(ctx context.Context, entry *HotEntry, canonical bool) error
+
+func (fn HotEntrySinkFn) OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error {
+	return fn(ctx, entry, canonical)
+}
+
+// UnfinalizedChain keeps all hot states in memory, one per forkchoice node.
+// The states share data with each other, so the memory is mostly spent on the differences between them.
+type UnfinalizedChain struct {
+	sync.RWMutex
+	// (block root, slot) -> entry. Same keys as the forkchoice nodes.
+	entries map[forkchoice.NodeRef]*HotEntry
+	// state root -> (block root, slot)
+	stateToKey map[common.Root]forkchoice.NodeRef
+	forkChoice forkchoice.Forkchoice
+	sink       HotEntrySink
+	spec       *common.Spec
+	genesis    beacon.GenesisInfo
+	// last known head, to detect head changes
+	head *HotEntry
+	feed EventFeed
+	// The latest slot seen, the chain has no clock of its own.
+	currentSlot common.Slot
+	// The best unrealized checkpoints of all blocks, realized at the start of the next epoch.
+	unrealizedJustified common.Checkpoint
+	unrealizedFinalized common.Checkpoint
+}
+
+var _ HotChain = (*UnfinalizedChain)(nil)
+
+// NewUnfinalizedChain starts a new hot chain from the given anchor state, e.g. the genesis state,
+// or a finalized state to checkpoint-sync from. The anchor is trusted as justified and finalized.
+// Pruned entries are passed to the sink, the sink may be nil.
+func NewUnfinalizedChain(ctx context.Context, spec *common.Spec, anchorState common.BeaconState, sink HotEntrySink) (*UnfinalizedChain, error) {
+	slot, err := anchorState.Slot()
+	if err != nil {
+		return nil, err
+	}
+	stateRoot := anchorState.HashTreeRoot(tree.GetHashFn())
+	header, err := anchorState.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The latest header state root is only filled in by the next slot processing.
+	if header.StateRoot == (common.Root{}) {
+		header.StateRoot = stateRoot
+	}
+	blockRoot := header.HashTreeRoot(tree.GetHashFn())
+	hasBlock := header.Slot == slot
+	// empty slots repeat the block root as parent root
+	parentRoot := blockRoot
+	if hasBlock {
+		parentRoot = header.ParentRoot
+	}
+	epc, err := common.NewEpochsContext(spec, anchorState)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create epochs context of anchor state: %v", err)
+	}
+	genesisTime, err := anchorState.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	genesisValRoot, err := anchorState.GenesisValidatorsRoot()
+	if err != nil {
+		return nil, err
+	}
+	epoch := spec.SlotToEpoch(slot)
+	balances, totalActive, err := activeBalances(anchorState, epoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get anchor state balances: %v", err)
+	}
+	anchor := &HotEntry{
+		step:       common.AsStep(slot, hasBlock),
+		epc:        epc,
+		state:      anchorState,
+		stateRoot:  stateRoot,
+		blockRoot:  blockRoot,
+		parentRoot: parentRoot,
+	}
+	uc := &UnfinalizedChain{
+		entries:    make(map[forkchoice.NodeRef]*HotEntry, 100),
+		stateToKey: make(map[common.Root]forkchoice.NodeRef, 100),
+		sink:       sink,
+		spec:       spec,
+		genesis:    beacon.GenesisInfo{Time: genesisTime, ValidatorsRoot: genesisValRoot},
+	}
+	uc.putEntry(anchor)
+	uc.head = anchor
+	uc.currentSlot = slot
+	anchorCheckpoint := common.Checkpoint{Epoch: epoch, Root: blockRoot}
+	uc.unrealizedJustified = anchorCheckpoint
+	uc.unrealizedFinalized = anchorCheckpoint
+	uc.forkChoice, err = proto.NewProtoForkChoice(spec, anchorCheckpoint, anchorCheckpoint,
+		blockRoot, slot, parentRoot, balances, totalActive, proto.NodeSinkFn(uc.onPrunedNode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize forkchoice: %v", err)
+	}
+	return uc, nil
+}
+
+// activeBalances returns the effective balance of every unslashed validator active in the given epoch,
+// or 0 if not active or slashed. Slashed validators have no weight in the forkchoice, as in get_weight of the spec.
+// The total active balance does include slashed validators, as in get_total_active_balance of the spec.
+func activeBalances(state common.BeaconState, epoch common.Epoch) (out []common.Gwei, totalActive common.Gwei, err error) {
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, 0, err
+	}
+	flat, err := common.FlattenValidators(vals)
+	if err != nil {
+		return nil, 0, err
+	}
+	out = make([]common.Gwei, len(flat), len(flat))
+	for i := range flat {
+		if !flat[i].IsActive(epoch) {
+			continue
+		}
+		totalActive += flat[i].EffectiveBalance
+		if !flat[i].Slashed {
+			out[i] = flat[i].EffectiveBalance
+		}
+	}
+	return out, totalActive, nil
+}
+
+// UnrealizedCheckpoints runs justification and finalization processing on a copy of the state,
+// to get the checkpoints the state would have if the epoch ended now (the "pulled-up tip" in the spec).
+func UnrealizedCheckpoints(ctx context.Context, spec *common.Spec, epc *common.EpochsContext,
+	state common.BeaconState) (justified common.Checkpoint, finalized common.Checkpoint, err error) {
+	state, err = state.CopyState()
+	if err != nil {
+		return
+	}
+	vals, err := state.Validators()
+	if err != nil {
+		return
+	}
+	flats, err := common.FlattenValidators(vals)
+	if err != nil {
+		return
+	}
+	just := phase0.JustificationStakeData{
+		CurrentEpoch:     epc.CurrentEpoch.Epoch,
+		TotalActiveStake: epc.TotalActiveStake,
+	}
+	switch s := state.(type) {
+	case altair.AltairLikeBeaconState:
+		attesterData, err := altair.ComputeEpochAttesterData(ctx, spec, epc, flats, s)
+		if err != nil {
+			return justified, finalized, err
+		}
+		just.PrevEpochUnslashedTargetStake = attesterData.PrevEpochUnslashedStake.TargetStake
+		just.CurrEpochUnslashedTargetStake = attesterData.CurrEpochUnslashedTargetStake
+	case phase0.Phase0PendingAttestationsBeaconState:
+		attesterData, err := phase0.ComputeEpochAttesterData(ctx, spec, epc, flats, s)
+		if err != nil {
+			return justified, finalized, err
+		}
+		just.PrevEpochUnslashedTargetStake = attesterData.PrevEpochUnslashedStake.TargetStake
+		just.CurrEpochUnslashedTargetStake = attesterData.CurrEpochUnslashedTargetStake
+	default:
+		return justified, finalized, fmt.Errorf("unrecognized state type: %T", state)
+	}
+	if err = phase0.ProcessEpochJustification(ctx, spec, &just, state); err != nil {
+		return
+	}
+	if justified, err = state.CurrentJustifiedCheckpoint(); err != nil {
+		return
+	}
+	finalized, err = state.FinalizedCheckpoint()
+	return
+}
+
+func (uc *UnfinalizedChain) putEntry(entry *HotEntry) {
+	key := entry.ref()
+	uc.entries[key] = entry
+	uc.stateToKey[entry.stateRoot] = key
+}
+
+// Called by the forkchoice, while the chain is already locked for writing.
+func (uc *UnfinalizedChain) onPrunedNode(ctx context.Context, ref forkchoice.NodeRef, canonical bool) error {
+	entry, ok := uc.entries[ref]
+	if !ok {
+		return nil
+	}
+	if uc.sink != nil {
+		if err := uc.sink.OnPrunedEntry(ctx, entry, canonical); err != nil {
+			return err
+		}
+	}
+	delete(uc.entries, ref)
+	delete(uc.stateToKey, entry.stateRoot)
+	uc.feed.Send(&PrunedEvent{Ref: ref, Canonical: canonical})
+	return nil
+}
+
+// anchorRef is the root of the forkchoice tree: the pin (the initial anchor, until anything is finalized),
+// or the finalized checkpoint.
+func (uc *UnfinalizedChain) anchorRef() forkchoice.NodeRef {
+	if pin := uc.forkChoice.Pin(); pin != nil {
+		return *pin
+	}
+	fin := uc.forkChoice.Finalized()
+	finSlot, _ := uc.spec.EpochStartSlot(fin.Epoch)
+	return forkchoice.NodeRef{Root: fin.Root, Slot: finSlot}
+}
+
+func (uc *UnfinalizedChain) ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	uc.RLock()
+	defer uc.RUnlock()
+	key, ok := uc.stateToKey[root]
+	if !ok {
+		return nil, false
+	}
+	return uc.entries[key], true
+}
+
+func (uc *UnfinalizedChain) ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	uc.RLock()
+	defer uc.RUnlock()
+	slot, ok := uc.forkChoice.GetSlot(root)
+	if !ok {
+		return nil, false
+	}
+	return uc.byBlockSlot(root, slot)
+}
+
+func (uc *UnfinalizedChain) ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	uc.RLock()
+	defer uc.RUnlock()
+	return uc.byBlockSlot(root, slot)
+}
+
+func (uc *UnfinalizedChain) byBlockSlot(root common.Root, slot common.Slot) (entry *HotEntry, ok bool) {
+	entry, ok = uc.entries[forkchoice.NodeRef{Root: root, Slot: slot}]
+	return
+}
+
+func (uc *UnfinalizedChain) Search(parentRoot *common.Root, slot *common.Slot) ([]beacon.SearchEntry, error) {
+	uc.RLock()
+	defer uc.RUnlock()
+	nonCanon, canon, err := uc.forkChoice.Search(uc.anchorRef(), parentRoot, slot)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]beacon.SearchEntry, 0, len(nonCanon)+len(canon))
+	for _, ref := range canon {
+		if entry, ok := uc.entries[ref]; ok {
+			out = append(out, beacon.SearchEntry{ChainEntry: entry, Canonical: true})
+		}
+	}
+	for _, ref := range nonCanon {
+		if entry, ok := uc.entries[ref]; ok {
+			out = append(out, beacon.SearchEntry{ChainEntry: entry, Canonical: false})
+		}
+	}
+	return out, nil
+}
+
+func (uc *UnfinalizedChain) Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	uc.RLock()
+	defer uc.RUnlock()
+	return uc.closest(fromBlockRoot, toSlot)
+}
+
+func (uc *UnfinalizedChain) closest(fromBlockRoot common.Root, toSlot common.Slot) (entry *HotEntry, ok bool) {
+	ref, err := uc.forkChoice.ClosestToSlot(fromBlockRoot, toSlot)
+	if err != nil {
+		return nil, false
+	}
+	entry, ok = uc.entries[ref]
+	return
+}
+
+func (uc *UnfinalizedChain) InSubtree(anchor common.Root, root common.Root) (unknown bool, inSubtree bool) {
+	return uc.forkChoice.InSubtree(anchor, root)
+}
+
+func (uc *UnfinalizedChain) ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool) {
+	uc.RLock()
+	defer uc.RUnlock()
+	anchor := uc.anchorRef()
+	ref, err := uc.forkChoice.CanonAtSlot(anchor.Root, step.Slot(), step.Block())
+	if err != nil {
+		return nil, false
+	}
+	if ref == (forkchoice.NodeRef{}) {
+		// the slot node exists, but there is no block in it.
+		return nil, true
+	}
+	if ref.Slot != step.Slot() {
+		// the canonical chain does not reach the requested slot (yet).
+		return nil, false
+	}
+	entry, ok = uc.entries[ref]
+	return
+}
+
+type hotChainIter struct {
+	start common.Step
+	end   common.Step
+	// canonical entries, by step
+	entries map[common.Step]*HotEntry
+}
+
+func (iter *hotChainIter) Start() common.Step {
+	return iter.start
+}
+
+func (iter *hotChainIter) End() common.Step {
+	return iter.end
+}
+
+func (iter *hotChainIter) Entry(step common.Step) (entry beacon.ChainEntry, err error) {
+	if step < iter.start || step >= iter.end {
+		return nil, fmt.Errorf("step %s is out of range [%s, %s)", step, iter.start, iter.end)
+	}
+	if e, ok := iter.entries[step]; ok {
+		return e, nil
+	}
+	if step.Block() {
+		// empty slot, no block
+		return nil, nil
+	}
+	return nil, fmt.Errorf("missing canonical entry for step %s", step)
+}
+
+// Iter takes a snapshot of the current canonical chain, from the forkchoice anchor up to and including the head.
+func (uc *UnfinalizedChain) Iter() (beacon.ChainIter, error) {
+	uc.RLock()
+	defer uc.RUnlock()
+	anchor := uc.anchorRef()
+	canon, err := uc.forkChoice.CanonicalChain(anchor.Root, anchor.Slot)
+	if err != nil {
+		return nil, err
+	}
+	if len(canon) == 0 {
+		return nil, errors.New("empty canonical chain")
+	}
+	iter := &hotChainIter{entries: make(map[common.Step]*HotEntry, len(canon))}
+	for _, ref := range canon {
+		entry, ok := uc.entries[ref.NodeRef]
+		if !ok {
+			return nil, fmt.Errorf("missing canonical entry %s", ref.NodeRef)
+		}
+		iter.entries[entry.step] = entry
+	}
+	// canonical chain is ordered from head to anchor
+	iter.end = uc.entries[canon[0].NodeRef].step + 1
+	iter.start = uc.entries[canon[len(canon)-1].NodeRef].step
+	return iter, nil
+}
+
+func (uc *UnfinalizedChain) JustifiedCheckpoint() common.Checkpoint {
+	return uc.forkChoice.Justified()
+}
+
+func (uc *UnfinalizedChain) FinalizedCheckpoint() common.Checkpoint {
+	return uc.forkChoice.Finalized()
+}
+
+func (uc *UnfinalizedChain) checkpointEntry(cp common.Checkpoint) (beacon.ChainEntry, error) {
+	uc.RLock()
+	defer uc.RUnlock()
+	slot, _ := uc.spec.EpochStartSlot(cp.Epoch)
+	entry, ok := uc.closest(cp.Root, slot)
+	if !ok {
+		return nil, fmt.Errorf("cannot find entry for checkpoint %s", cp)
+	}
+	return entry, nil
+}
+
+func (uc *UnfinalizedChain) Justified() (beacon.ChainEntry, error) {
+	return uc.checkpointEntry(uc.forkChoice.Justified())
+}
+
+func (uc *UnfinalizedChain) Finalized() (beacon.ChainEntry, error) {
+	return uc.checkpointEntry(uc.forkChoice.Finalized())
+}
+
+func (uc *UnfinalizedChain) Head() (beacon.ChainEntry, error) {
+	uc.RLock()
+	defer uc.RUnlock()
+	ref, err := uc.forkChoice.Head()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := uc.entries[ref]
+	if !ok {
+		return nil, fmt.Errorf("missing head entry %s", ref)
+	}
+	return entry, nil
+}
+
+func (uc *UnfinalizedChain) Towards(ctx context.Context, fromBlockRoot common.Root, toSlot common.Slot) (beacon.ChainEntry, error) {
+	uc.Lock()
+	defer uc.Unlock()
+	entry, err := uc.towards(ctx, fromBlockRoot, toSlot)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.onSlot(ctx, toSlot); err != nil {
+		return nil, err
+	}
+	// new slot nodes may become the head
+	if err := uc.updateHead(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// towards transitions empty slots, starting from the closest entry, up to the given slot.
+// Every intermediate slot is added to the chain, the same as the forkchoice tracks every slot.
+func (uc *UnfinalizedChain) towards(ctx context.Context, fromBlockRoot common.Root, toSlot common.Slot) (*HotEntry, error) {
+	entry, ok := uc.closest(fromBlockRoot, toSlot)
+	if !ok {
+		return nil, fmt.Errorf("could not find closest hot entry starting from root %s, up to slot %d", fromBlockRoot, toSlot)
+	}
+	for slot := entry.step.Slot() + 1; slot <= toSlot; slot++ {
+		state, err := entry.state.CopyState()
+		if err != nil {
+			return nil, err
+		}
+		epc := entry.epc.Clone()
+		upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+		if err := common.ProcessSlots(ctx, uc.spec, epc, upgradeable, slot); err != nil {
+			return nil, fmt.Errorf("failed to process slot %d on top of %s: %v", slot, entry.blockRoot, err)
+		}
+		state = upgradeable.BeaconState
+		justified, err := state.CurrentJustifiedCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		finalized, err := state.FinalizedCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		entry = &HotEntry{
+			step:       common.AsStep(slot, false),
+			epc:        epc,
+			state:      state,
+			stateRoot:  state.HashTreeRoot(tree.GetHashFn()),
+			blockRoot:  entry.blockRoot,
+			parentRoot: entry.blockRoot,
+		}
+		uc.putEntry(entry)
+		uc.forkChoice.ProcessSlot(entry.blockRoot, slot, justified.Epoch, finalized.Epoch)
+	}
+	return entry, nil
+}
+
+func (uc *UnfinalizedChain) Genesis() beacon.GenesisInfo {
+	return uc.genesis
+}
+
+func (uc *UnfinalizedChain) AddBlock(ctx context.Context, block *common.BeaconBlockEnvelope) error {
+	uc.Lock()
+	defer uc.Unlock()
+	if _, ok := uc.byBlockSlot(block.BlockRoot, block.Slot); ok {
+		// already processed
+		return nil
+	}
+	parent, ok := uc.closest(block.ParentRoot, block.Slot)
+	if !ok {
+		return fmt.Errorf("unknown parent block %s", block.ParentRoot)
+	}
+	if parent.step.Slot() >= block.Slot && parent.step.Block() {
+		return fmt.Errorf("parent block %s at slot %d is not before block slot %d",
+			block.ParentRoot, parent.step.Slot(), block.Slot)
+	}
+	pre, err := uc.towards(ctx, block.ParentRoot, block.Slot)
+	if err != nil {
+		return err
+	}
+	state, err := pre.state.CopyState()
+	if err != nil {
+		return err
+	}
+	epc := pre.epc.Clone()
+	if err := common.PostSlotTransition(ctx, uc.spec, epc, state, block, true); err != nil {
+		return fmt.Errorf("failed to process block %s: %v", block.BlockRoot, err)
+	}
+	justified, err := state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	finalized, err := state.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	unrealizedJustified, unrealizedFinalized, err := UnrealizedCheckpoints(ctx, uc.spec, epc, state)
+	if err != nil {
+		return fmt.Errorf("failed to compute unrealized checkpoints of block %s: %v", block.BlockRoot, err)
+	}
+	entry := &HotEntry{
+		step:       common.AsStep(block.Slot, true),
+		epc:        epc,
+		state:      state,
+		stateRoot:  block.StateRoot,
+		blockRoot:  block.BlockRoot,
+		parentRoot: block.ParentRoot,
+		block:      block,
+	}
+	uc.putEntry(entry)
+	if !uc.forkChoice.ProcessBlock(block.ParentRoot, block.BlockRoot, block.Slot, justified.Epoch, finalized.Epoch,
+		unrealizedJustified.Epoch, unrealizedFinalized.Epoch, beacon.ExecutionBlockHash(block)) {
+		delete(uc.entries, entry.ref())
+		delete(uc.stateToKey, entry.stateRoot)
+		return fmt.Errorf("forkchoice did not accept block %s", block.BlockRoot)
+	}
+	uc.feed.Send(&BlockImportedEvent{Entry: entry, Block: block})
+	if unrealizedJustified.Epoch > uc.unrealizedJustified.Epoch {
+		uc.unrealizedJustified = unrealizedJustified
+	}
+	if unrealizedFinalized.Epoch > uc.unrealizedFinalized.Epoch {
+		uc.unrealizedFinalized = unrealizedFinalized
+	}
+	// Blocks from a previous epoch are pulled up: their unrealized checkpoints would have been realized already.
+	if uc.spec.SlotToEpoch(block.Slot) < uc.spec.SlotToEpoch(uc.currentSlot) {
+		justified, finalized = unrealizedJustified, unrealizedFinalized
+	}
+	if err := uc.updateCheckpoints(ctx, block.BlockRoot, justified, finalized); err != nil {
+		return err
+	}
+	if err := uc.onSlot(ctx, block.Slot); err != nil {
+		return err
+	}
+	return uc.updateHead()
+}
+
+// onSlot moves the current slot of the chain forward.
+// At the start of a new epoch, the best unrealized checkpoints become realized.
+func (uc *UnfinalizedChain) onSlot(ctx context.Context, slot common.Slot) error {
+	if slot <= uc.currentSlot {
+		return nil
+	}
+	newEpoch := uc.spec.SlotToEpoch(slot) > uc.spec.SlotToEpoch(uc.currentSlot)
+	uc.currentSlot = slot
+	uc.forkChoice.OnSlot(slot)
+	if newEpoch {
+		return uc.updateCheckpoints(ctx, uc.unrealizedJustified.Root, uc.unrealizedJustified, uc.unrealizedFinalized)
+	}
+	return nil
+}
+
+// updateCheckpoints updates the justified and finalized checkpoint of the forkchoice,
+// if the given checkpoints (from a new post-block state) are newer.
+func (uc *UnfinalizedChain) updateCheckpoints(ctx context.Context, trigger common.Root, justified common.Checkpoint, finalized common.Checkpoint) error {
+	prevJustified, prevFinalized := uc.forkChoice.Justified(), uc.forkChoice.Finalized()
+	if justified.Epoch <= prevJustified.Epoch && finalized.Epoch <= prevFinalized.Epoch {
+		return nil
+	}
+	if justified.Epoch <= prevJustified.Epoch {
+		justified = prevJustified
+	}
+	if finalized.Epoch <= prevFinalized.Epoch {
+		finalized = prevFinalized
+	}
+	// The entry is looked up before updating the forkchoice:
+	// the balances are retrieved while the forkchoice is locked, and the lookup uses the forkchoice.
+	justifiedSlot, err := uc.spec.EpochStartSlot(justified.Epoch)
+	if err != nil {
+		return err
+	}
+	justifiedEntry, ok := uc.closest(justified.Root, justifiedSlot)
+	if !ok {
+		return fmt.Errorf("missing justified entry %s", justified)
+	}
+	err = uc.forkChoice.UpdateJustified(ctx, trigger, justified, finalized, func() ([]common.Gwei, common.Gwei, error) {
+		return activeBalances(justifiedEntry.state, justified.Epoch)
+	})
+	if err != nil {
+		return err
+	}
+	if newJustified := uc.forkChoice.Justified(); newJustified != prevJustified {
+		uc.feed.Send(&JustifiedEvent{Old: prevJustified, New: newJustified})
+	}
+	if newFinalized := uc.forkChoice.Finalized(); newFinalized != prevFinalized {
+		uc.feed.Send(&FinalizedEvent{Old: prevFinalized, New: newFinalized})
+	}
+	return nil
+}
+
+// updateHead recomputes the head, and emits a head event if it changed.
+func (uc *UnfinalizedChain) updateHead() error {
+	ref, err := uc.forkChoice.Head()
+	if err != nil {
+		return err
+	}
+	newHead, ok := uc.entries[ref]
+	if !ok {
+		return fmt.Errorf("missing head entry %s", ref)
+	}
+	oldHead := uc.head
+	if oldHead == newHead {
+		return nil
+	}
+	uc.head = newHead
+	uc.feed.Send(&HeadEvent{OldHead: oldHead, NewHead: newHead, ReorgDepth: uc.reorgDepth(oldHead, newHead)})
+	return nil
+}
+
+// reorgDepth walks back both heads to the last shared block,
+// and returns the number of slots between the old head and that block.
+// If the old head was pruned or the common ancestor is unknown, the depth is counted up to the anchor.
+func (uc *UnfinalizedChain) reorgDepth(oldHead *HotEntry, newHead *HotEntry) uint64 {
+	a, b := oldHead, newHead
+	for a.blockRoot != b.blockRoot {
+		aSlot, bSlot := a.step.Slot(), b.step.Slot()
+		if aSlot >= bSlot {
+			a = uc.parentEntry(a)
+		}
+		if bSlot >= aSlot {
+			b = uc.parentEntry(b)
+		}
+		if a == nil || b == nil {
+			anchorSlot := uc.anchorRef().Slot
+			if oldHead.step.Slot() <= anchorSlot {
+				return 0
+			}
+			return uint64(oldHead.step.Slot() - anchorSlot)
+		}
+	}
+	if a.blockRoot == oldHead.blockRoot {
+		return 0
+	}
+	blockSlot, ok := uc.forkChoice.GetSlot(a.blockRoot)
+	if !ok {
+		blockSlot = a.step.Slot()
+	}
+	return uint64(oldHead.step.Slot() - blockSlot)
+}
+
+// parentEntry returns the entry of the previous slot in the same branch, or nil if it is not known.
+func (uc *UnfinalizedChain) parentEntry(entry *HotEntry) *HotEntry {
+	slot := entry.step.Slot()
+	if slot == 0 {
+		return nil
+	}
+	return uc.entries[forkchoice.NodeRef{Root: entry.parentRoot, Slot: slot - 1}]
+}
+
+func (uc *UnfinalizedChain) Subscribe(buffer int) *Subscription {
+	return uc.feed.Subscribe(buffer)
+}
+
+func (uc *UnfinalizedChain) AddAttestation(ctx context.Context, att *phase0.Attestation) error {
+	uc.Lock()
+	defer uc.Unlock()
+	targetSlot, err := uc.spec.EpochStartSlot(att.Data.Target.Epoch)
+	if err != nil {
+		return err
+	}
+	target, err := uc.towards(ctx, att.Data.Target.Root, targetSlot)
+	if err != nil {
+		return fmt.Errorf("cannot get target %s of attestation: %v", att.Data.Target, err)
+	}
+	committee, err := target.epc.GetBeaconCommittee(att.Data.Slot, att.Data.Index)
+	if err != nil {
+		return err
+	}
+	indexed, err := att.ConvertToIndexed(uc.spec, committee)
+	if err != nil {
+		return err
+	}
+	// Votes are slot-accurate: make sure the forkchoice has a node for the block root at the attestation slot.
+	if _, err := uc.towards(ctx, att.Data.BeaconBlockRoot, att.Data.Slot); err != nil {
+		return fmt.Errorf("cannot get voted block %s at slot %d: %v", att.Data.BeaconBlockRoot, att.Data.Slot, err)
+	}
+	for _, index := range indexed.AttestingIndices {
+		uc.forkChoice.ProcessAttestation(index, att.Data.BeaconBlockRoot, att.Data.Slot)
+	}
+	return uc.updateHead()
+}
+
+func (uc *UnfinalizedChain) AddAttesterSlashing(ctx context.Context, sl *phase0.AttesterSlashing) error {
+	indices := sl.EquivocatingIndices()
+	if len(indices) == 0 {
+		return errors.New("attester slashing does not prove any equivocation")
+	}
+	uc.Lock()
+	defer uc.Unlock()
+	uc.forkChoice.ProcessEquivocation(indices)
+	return uc.updateHead()
+}
@@ -0,0 +1,678 @@
+package chain
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func testGenesis(t *testing.T, spec *common.Spec, count uint64) *phase0.BeaconStateView {
+	vals := make([]phase0.KickstartValidatorData, 0, count)
+	keys := make([][32]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var key [32]byte
+		binary.BigEndian.PutUint64(key[24:], i+1)
+		var sk blsu.SecretKey
+		if err := sk.Deserialize(&key); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, phase0.KickstartValidatorData{
+			Pubkey:                pub.Serialize(),
+			WithdrawalCredentials: common.Root{0xbb},
+			Balance:               spec.MAX_EFFECTIVE_BALANCE,
+		})
+		keys = append(keys, key)
+	}
+	state, _, err := phase0.KickStartStateWithSignatures(spec, common.Root{123}, 1564000000, vals, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// testSign signs the root with the key of the validator, as created by testGenesis.
+func testSign(t *testing.T, index common.ValidatorIndex, state common.BeaconState, dom common.BLSDomainType,
+	epoch common.Epoch, root common.Root) *blsu.Signature {
+	var key [32]byte
+	binary.BigEndian.PutUint64(key[24:], uint64(index)+1)
+	var sk blsu.SecretKey
+	if err := sk.Deserialize(&key); err != nil {
+		t.Fatal(err)
+	}
+	domain, err := common.GetDomain(state, dom, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigRoot := common.ComputeSigningRoot(root, domain)
+	return blsu.Sign(&sk, sigRoot[:])
+}
+
+// testAttestations creates the attestations of all committees of the slot, signed by every committee member,
+// voting for the head block of the state. The state must be at a later slot than the attestations.
+func testAttestations(t *testing.T, spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, slot common.Slot) phase0.Attestations {
+	stateSlot, err := state.Slot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := common.GetBlockRootAtSlot(spec, state, slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := spec.SlotToEpoch(slot)
+	target, err := common.GetBlockRoot(spec, state, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source common.Checkpoint
+	if epoch == spec.SlotToEpoch(stateSlot) {
+		source, err = state.CurrentJustifiedCheckpoint()
+	} else {
+		source, err = state.PreviousJustifiedCheckpoint()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := epc.GetCommitteeCountPerSlot(epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out phase0.Attestations
+	for i := uint64(0); i < count; i++ {
+		committee, err := epc.GetBeaconCommittee(slot, common.CommitteeIndex(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := phase0.AttestationData{
+			Slot:            slot,
+			Index:           common.CommitteeIndex(i),
+			BeaconBlockRoot: head,
+			Source:          source,
+			Target:          common.Checkpoint{Epoch: epoch, Root: target},
+		}
+		root := data.HashTreeRoot(tree.GetHashFn())
+		size := uint64(len(committee))
+		bits := make(phase0.AttestationBits, size/8+1)
+		bits[size/8] |= 1 << (size % 8)
+		sigs := make([]*blsu.Signature, 0, len(committee))
+		for j, index := range committee {
+			bits.SetBit(uint64(j), true)
+			sigs = append(sigs, testSign(t, index, state, common.DOMAIN_BEACON_ATTESTER, epoch, root))
+		}
+		sig, err := blsu.Aggregate(sigs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, phase0.Attestation{AggregationBits: bits, Data: data, Signature: sig.Serialize()})
+	}
+	return out
+}
+
+// testBlock builds a signed block at the slot on top of the parent entry,
+// with the attestations of the previous slot if withAttestations is true.
+// The graffiti can be changed to build different blocks at the same slot.
+func testBlock(t *testing.T, ctx context.Context, spec *common.Spec, parent beacon.ChainEntry, slot common.Slot,
+	withAttestations bool, graffiti byte) *common.BeaconBlockEnvelope {
+	pre, err := parent.State(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := pre.CopyState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	preEpc, err := parent.EpochsContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epc := preEpc.Clone()
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	if err := common.ProcessSlots(ctx, spec, epc, upgradeable, slot); err != nil {
+		t.Fatal(err)
+	}
+	state = upgradeable.BeaconState
+	parentRoot, err := parent.BlockRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposer, err := epc.GetBeaconProposer(slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth1Data, err := state.Eth1Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := state.Fork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := common.ComputeForkDigest(fork.CurrentVersion, genValRoot)
+	epoch := spec.SlotToEpoch(slot)
+	block := &phase0.SignedBeaconBlock{Message: phase0.BeaconBlock{
+		Slot:          slot,
+		ProposerIndex: proposer,
+		ParentRoot:    parentRoot,
+		Body: phase0.BeaconBlockBody{
+			RandaoReveal: testSign(t, proposer, state, common.DOMAIN_RANDAO, epoch, epoch.HashTreeRoot(tree.GetHashFn())).Serialize(),
+			Eth1Data:     eth1Data,
+			Graffiti:     common.Root{graffiti},
+		},
+	}}
+	if withAttestations && slot > 0 {
+		block.Message.Body.Attestations = testAttestations(t, spec, epc, state, slot-1)
+	}
+	if err := state.ProcessBlock(ctx, spec, epc, block.Envelope(spec, digest)); err != nil {
+		t.Fatal(err)
+	}
+	block.Message.StateRoot = state.HashTreeRoot(tree.GetHashFn())
+	block.Signature = testSign(t, proposer, state, common.DOMAIN_BEACON_PROPOSER, epoch,
+		block.Message.HashTreeRoot(spec, tree.GetHashFn())).Serialize()
+	return block.Envelope(spec, digest)
+}
+
+// testFillChain adds a block with the attestations of the previous slot to every slot after the parent,
+// up to and including the given slot, and returns the block roots.
+func testFillChain(t *testing.T, ctx context.Context, spec *common.Spec, uc *UnfinalizedChain,
+	parent beacon.ChainEntry, to common.Slot) (roots []common.Root) {
+	for slot := parent.Step().Slot() + 1; slot <= to; slot++ {
+		block := testBlock(t, ctx, spec, parent, slot, true, 0)
+		if err := uc.AddBlock(ctx, block); err != nil {
+			t.Fatalf("failed to add block %d: %v", slot, err)
+		}
+		parent, _ = uc.ByBlock(block.BlockRoot)
+		roots = append(roots, block.BlockRoot)
+	}
+	return roots
+}
+
+func TestActiveBalancesSlashed(t *testing.T) {
+	spec := configs.Minimal
+	state := testGenesis(t, spec, 8)
+	vals, err := state.Validators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := vals.Validator(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := val.MakeSlashed(); err != nil {
+		t.Fatal(err)
+	}
+	balances, totalActive, err := activeBalances(state, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range balances {
+		if i == 3 && b != 0 {
+			t.Fatal("expected no balance for slashed validator")
+		} else if i != 3 && b != spec.MAX_EFFECTIVE_BALANCE {
+			t.Fatalf("unexpected balance of validator %d: %d", i, b)
+		}
+	}
+	// the slashed validator is still active, and counts towards the proposer boost committee weight.
+	if totalActive != 8*spec.MAX_EFFECTIVE_BALANCE {
+		t.Fatalf("unexpected total active balance: %d", totalActive)
+	}
+}
+
+func TestUnfinalizedChainEmptySlots(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Step() != common.AsStep(0, true) {
+		t.Fatalf("unexpected genesis head step: %s", head.Step())
+	}
+	genesisRoot, _ := head.BlockRoot()
+	if entry, ok := uc.ByBlock(genesisRoot); !ok || entry != head {
+		t.Fatal("expected to find genesis by block root")
+	}
+
+	entry, err := uc.Towards(ctx, genesisRoot, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Step() != common.AsStep(5, false) {
+		t.Fatalf("unexpected step: %s", entry.Step())
+	}
+	stateRoot, _ := entry.StateRoot()
+	if found, ok := uc.ByStateRoot(stateRoot); !ok || found != entry {
+		t.Fatal("expected to find entry by state root")
+	}
+	state, err := entry.State(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot, _ := state.Slot(); slot != 5 {
+		t.Fatalf("unexpected state slot: %d", slot)
+	}
+	if closest, ok := uc.Closest(genesisRoot, 3); !ok || closest.Step() != common.AsStep(3, false) {
+		t.Fatal("expected closest entry at slot 3")
+	}
+
+	// empty slots are part of the canonical chain
+	head, err = uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Step() != common.AsStep(5, false) {
+		t.Fatalf("unexpected head step: %s", head.Step())
+	}
+	if canon, ok := uc.ByCanonStep(common.AsStep(4, true)); !ok || canon != nil {
+		t.Fatal("expected no block at slot 4")
+	}
+	iter, err := uc.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iter.Start() != common.AsStep(0, true) || iter.End() != common.AsStep(5, true) {
+		t.Fatalf("unexpected iter range: %s - %s", iter.Start(), iter.End())
+	}
+	for step := iter.Start(); step < iter.End(); step++ {
+		e, err := iter.Entry(step)
+		if err != nil {
+			t.Fatalf("failed to get entry %s: %v", step, err)
+		}
+		// only genesis has a block
+		if step.Block() && step.Slot() > 0 {
+			if e != nil {
+				t.Fatalf("expected no block at %s", step)
+			}
+		} else if e == nil || e.Step() != step {
+			t.Fatalf("unexpected entry at %s", step)
+		}
+	}
+}
+
+func TestUnfinalizedChainHeadEvents(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := uc.Subscribe(10)
+	defer sub.Unsubscribe()
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisRoot, _ := genesis.BlockRoot()
+	entry, err := uc.Towards(ctx, genesisRoot, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-sub.Events():
+		head, ok := ev.(*HeadEvent)
+		if !ok {
+			t.Fatalf("unexpected event: %T", ev)
+		}
+		if head.OldHead != genesis || head.NewHead != entry || head.ReorgDepth != 0 {
+			t.Fatalf("unexpected head event: %s -> %s, depth %d", head.OldHead.Step(), head.NewHead.Step(), head.ReorgDepth)
+		}
+	default:
+		t.Fatal("expected head event")
+	}
+	// the head did not change
+	if _, err := uc.Towards(ctx, genesisRoot, 2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-sub.Events():
+		t.Fatalf("unexpected event: %T", ev)
+	default:
+	}
+	sub.Unsubscribe()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("expected closed events channel")
+	}
+}
+
+func TestUnfinalizedChainAddBlock(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block1 := testBlock(t, ctx, spec, genesis, 1, false, 0)
+	if err := uc.AddBlock(ctx, block1); err != nil {
+		t.Fatal(err)
+	}
+	entry1, ok := uc.ByBlock(block1.BlockRoot)
+	if !ok || entry1.Step() != common.AsStep(1, true) {
+		t.Fatal("expected block 1 entry")
+	}
+	if entry, ok := uc.ByStateRoot(block1.StateRoot); !ok || entry != entry1 {
+		t.Fatal("expected to find block 1 by state root")
+	}
+	// skip slot 2, the block includes the attestations of slot 1
+	block3 := testBlock(t, ctx, spec, entry1, 3, true, 0)
+	if err := uc.AddBlock(ctx, block3); err != nil {
+		t.Fatal(err)
+	}
+	// re-adding is a no-op
+	if err := uc.AddBlock(ctx, block3); err != nil {
+		t.Fatal(err)
+	}
+	head, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := head.BlockRoot(); root != block3.BlockRoot || head.Step() != common.AsStep(3, true) {
+		t.Fatalf("unexpected head %s", head.Step())
+	}
+	if canon, ok := uc.ByCanonStep(common.AsStep(2, false)); !ok || canon == nil {
+		t.Fatal("expected empty slot 2 to be canonical")
+	} else if root, _ := canon.BlockRoot(); root != block1.BlockRoot {
+		t.Fatal("expected empty slot 2 to repeat block 1")
+	}
+
+	// unknown parent
+	orphan := *block3
+	orphan.BlockRoot = common.Root{41}
+	orphan.ParentRoot = common.Root{42}
+	if err := uc.AddBlock(ctx, &orphan); err == nil {
+		t.Fatal("expected block with unknown parent to be rejected")
+	}
+	// invalid state root
+	invalid := testBlock(t, ctx, spec, head, 4, false, 0)
+	invalid.StateRoot = common.Root{42}
+	if err := uc.AddBlock(ctx, invalid); err == nil {
+		t.Fatal("expected block with invalid state root to be rejected")
+	}
+	if _, ok := uc.ByBlock(invalid.BlockRoot); ok {
+		t.Fatal("expected rejected block to not be added")
+	}
+}
+
+func TestUnfinalizedChainAddAttestation(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two competing blocks at slot 1
+	blockA := testBlock(t, ctx, spec, genesis, 1, false, 1)
+	blockB := testBlock(t, ctx, spec, genesis, 1, false, 2)
+	for _, b := range []*common.BeaconBlockEnvelope{blockA, blockB} {
+		if err := uc.AddBlock(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the votes of slot 1 for the losing block make it the head
+	loser, winner := blockA, blockB
+	if head, _ := uc.Head(); head != nil {
+		if root, _ := head.BlockRoot(); root == blockA.BlockRoot {
+			loser, winner = blockB, blockA
+		}
+	}
+	loserEntry, _ := uc.ByBlock(loser.BlockRoot)
+	// attestations are built from a state that processed the slot of the attestations
+	next := testBlock(t, ctx, spec, loserEntry, 2, true, 0)
+	atts := next.Body.(*phase0.BeaconBlockBody).Attestations
+	if len(atts) == 0 {
+		t.Fatal("expected attestations")
+	}
+	for i := range atts {
+		if err := uc.AddAttestation(ctx, &atts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := head.BlockRoot(); root != loser.BlockRoot {
+		t.Fatalf("expected attested block %s to be head, not %s", loser.BlockRoot, root)
+	}
+	if _, ok := uc.ByBlock(winner.BlockRoot); !ok {
+		t.Fatal("expected other block to still be known")
+	}
+	// attestations for unknown blocks are rejected
+	unknown := atts[0]
+	unknown.Data.BeaconBlockRoot = common.Root{42}
+	if err := uc.AddAttestation(ctx, &unknown); err == nil {
+		t.Fatal("expected attestation of unknown block to be rejected")
+	}
+}
+
+func TestUnfinalizedChainFinalization(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	pruned := make(map[common.Root]bool)
+	sink := HotEntrySinkFn(func(ctx context.Context, entry *HotEntry, canonical bool) error {
+		if entry.Block() != nil {
+			pruned[entry.blockRoot] = canonical
+		}
+		return nil
+	})
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a fork that is never attested to
+	fork := testBlock(t, ctx, spec, genesis, 1, false, 1)
+	if err := uc.AddBlock(ctx, fork); err != nil {
+		t.Fatal(err)
+	}
+	canonical := testFillChain(t, ctx, spec, uc, genesis, 6*spec.SLOTS_PER_EPOCH)
+	finalized := uc.FinalizedCheckpoint()
+	if finalized.Epoch < 3 {
+		t.Fatalf("expected finalization, got %s", finalized)
+	}
+	if uc.JustifiedCheckpoint().Epoch <= finalized.Epoch {
+		t.Fatalf("expected justified checkpoint after finalized, got %s", uc.JustifiedCheckpoint())
+	}
+	finEntry, err := uc.Finalized()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := finEntry.BlockRoot(); root != finalized.Root {
+		t.Fatal("unexpected finalized entry")
+	}
+	if isCanon, ok := pruned[fork.BlockRoot]; !ok || isCanon {
+		t.Fatal("expected fork to be pruned as non-canonical")
+	}
+	if _, ok := uc.ByBlock(fork.BlockRoot); ok {
+		t.Fatal("expected fork to be removed")
+	}
+	finSlot, _ := spec.EpochStartSlot(finalized.Epoch)
+	for i, root := range canonical {
+		slot := common.Slot(i + 1)
+		_, known := uc.ByBlock(root)
+		if isCanon, ok := pruned[root]; slot < finSlot && (!ok || !isCanon || known) {
+			t.Fatalf("expected block %d before finalized checkpoint to be pruned as canonical", slot)
+		} else if slot >= finSlot && (ok || !known) {
+			t.Fatalf("expected block %d to be kept", slot)
+		}
+	}
+	iter, err := uc.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iter.Start() != common.AsStep(finSlot, true) {
+		t.Fatalf("expected canonical chain to start at finalized checkpoint, got %s", iter.Start())
+	}
+}
+
+func TestUnfinalizedChainReorgDepth(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := uc.Subscribe(100)
+	defer sub.Unsubscribe()
+	nextHeadEvent := func() *HeadEvent {
+		for {
+			select {
+			case ev := <-sub.Events():
+				if head, ok := ev.(*HeadEvent); ok {
+					return head
+				}
+			default:
+				return nil
+			}
+		}
+	}
+	add := func(parent beacon.ChainEntry, slot common.Slot, graffiti byte) beacon.ChainEntry {
+		block := testBlock(t, ctx, spec, parent, slot, false, graffiti)
+		if err := uc.AddBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+		entry, _ := uc.ByBlock(block.BlockRoot)
+		return entry
+	}
+	a1 := add(genesis, 1, 0)
+	if ev := nextHeadEvent(); ev == nil || ev.OldHead != genesis || ev.NewHead != a1 || ev.ReorgDepth != 0 {
+		t.Fatal("expected head event to block 1 without reorg")
+	}
+	a2 := add(a1, 2, 0)
+	a3 := add(a2, 3, 0)
+	// a competing branch, forking off after block 1
+	b2 := add(a1, 2, 1)
+	b3 := add(b2, 3, 1)
+	for nextHeadEvent() != nil {
+	}
+	head, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != a3 && head != b3 {
+		t.Fatalf("unexpected head %s", head.Step())
+	}
+	// vote for the other branch
+	other := a3
+	if head == a3 {
+		other = b3
+	}
+	next := testBlock(t, ctx, spec, other, 4, true, 0)
+	atts := next.Body.(*phase0.BeaconBlockBody).Attestations
+	for i := range atts {
+		if err := uc.AddAttestation(ctx, &atts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ev := nextHeadEvent()
+	if ev == nil {
+		t.Fatal("expected head event after reorg")
+	}
+	// both heads are at slot 3, and share block 1
+	if ev.OldHead != head || ev.NewHead != other || ev.ReorgDepth != 2 {
+		t.Fatalf("unexpected reorg: %s -> %s, depth %d", ev.OldHead.Step(), ev.NewHead.Step(), ev.ReorgDepth)
+	}
+}
+
+func TestUnfinalizedChainEvents(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	uc, err := NewUnfinalizedChain(ctx, spec, testGenesis(t, spec, 64), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := uc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := uc.Subscribe(1000)
+	defer sub.Unsubscribe()
+	fork := testBlock(t, ctx, spec, genesis, 1, false, 1)
+	if err := uc.AddBlock(ctx, fork); err != nil {
+		t.Fatal(err)
+	}
+	roots := testFillChain(t, ctx, spec, uc, genesis, 6*spec.SLOTS_PER_EPOCH)
+	if sub.Dropped() != 0 {
+		t.Fatalf("dropped %d events", sub.Dropped())
+	}
+	sub.Unsubscribe()
+
+	imported := make(map[common.Root]bool)
+	var justified, finalized []common.Checkpoint
+	pruned := make(map[forkchoice.NodeRef]bool)
+	anchor := common.Checkpoint{Root: genesis.(*HotEntry).blockRoot}
+	prevJustified, prevFinalized := anchor, anchor
+	for ev := range sub.Events() {
+		switch x := ev.(type) {
+		case *BlockImportedEvent:
+			if root, _ := x.Entry.BlockRoot(); root != x.Block.BlockRoot {
+				t.Fatal("block does not match entry")
+			}
+			imported[x.Block.BlockRoot] = true
+		case *JustifiedEvent:
+			if x.Old != prevJustified || x.New.Epoch <= x.Old.Epoch {
+				t.Fatalf("unexpected justified event: %s -> %s", x.Old, x.New)
+			}
+			prevJustified = x.New
+			justified = append(justified, x.New)
+		case *FinalizedEvent:
+			if x.Old != prevFinalized || x.New.Epoch <= x.Old.Epoch {
+				t.Fatalf("unexpected finalized event: %s -> %s", x.Old, x.New)
+			}
+			prevFinalized = x.New
+			finalized = append(finalized, x.New)
+		case *PrunedEvent:
+			if _, ok := uc.entries[x.Ref]; ok {
+				t.Fatalf("pruned node %s is still known", x.Ref)
+			}
+			pruned[x.Ref] = x.Canonical
+		}
+	}
+	if len(imported) != len(roots)+1 || !imported[fork.BlockRoot] {
+		t.Fatalf("expected all %d blocks to be imported, got %d", len(roots)+1, len(imported))
+	}
+	if len(justified) == 0 || justified[len(justified)-1] != uc.JustifiedCheckpoint() {
+		t.Fatal("expected justified events up to the current justified checkpoint")
+	}
+	if len(finalized) == 0 || finalized[len(finalized)-1] != uc.FinalizedCheckpoint() {
+		t.Fatal("expected finalized events up to the current finalized checkpoint")
+	}
+	if canonical, ok := pruned[forkchoice.NodeRef{Root: fork.BlockRoot, Slot: 1}]; !ok || canonical {
+		t.Fatal("expected fork to be pruned as non-canonical")
+	}
+	if canonical, ok := pruned[forkchoice.NodeRef{Root: roots[0], Slot: 1}]; !ok || !canonical {
+		t.Fatal("expected first block to be pruned as canonical")
+	}
+}
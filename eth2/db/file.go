@@ -0,0 +1,123 @@
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a KeyValueStore that stores every value as a separate file.
+// Values are grouped in sub-directories by the first byte of the key, which chain storage uses as namespace.
+// Writes are atomic: a value is written to a temporary file first, and then moved into place.
+type FileStore struct {
+	dir string
+}
+
+var _ KeyValueStore = (*FileStore)(nil)
+
+// NewFileStore opens the store at the given directory, the directory is created if it does not exist yet.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory %q: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("empty key")
+	}
+	return filepath.Join(fs.dir, hex.EncodeToString(key[:1]), hex.EncodeToString(key)), nil
+}
+
+func (fs *FileStore) Get(key []byte) (value []byte, ok bool, err error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err = os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (fs *FileStore) Put(key []byte, value []byte) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(value); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, p); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// DeleteRange removes the files of the keys in the range.
+// Only the sub-directories of the first key bytes in the range are listed.
+func (fs *FileStore) DeleteRange(start []byte, end []byte) error {
+	if len(start) == 0 || len(end) == 0 {
+		return errors.New("empty key")
+	}
+	for b := int(start[0]); b <= int(end[0]); b++ {
+		dir := filepath.Join(fs.dir, hex.EncodeToString([]byte{byte(b)}))
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key, err := hex.DecodeString(entry.Name())
+			// skip temporary files
+			if err != nil || !inRange(key, start, end) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) Delete(key []byte) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	"sync"
+)
+
+// KeyValueStore is the minimal storage backend that chain data is persisted to.
+// Implementations must be safe for concurrent use.
+type KeyValueStore interface {
+	// Get returns the value stored at key, or ok == false if there is no such value.
+	Get(key []byte) (value []byte, ok bool, err error)
+	// Put stores the value at key, overwriting any previous value.
+	// The store may not retain the given key and value slices, callers may modify them after the call.
+	Put(key []byte, value []byte) error
+	// Delete removes the value at key. Deleting an unknown key is not an error.
+	Delete(key []byte) error
+	// DeleteRange removes the values of all keys in the range [start, end), in byte order.
+	DeleteRange(start []byte, end []byte) error
+}
+
+// MemStore is a KeyValueStore that keeps everything in memory, mostly useful for testing.
+type MemStore struct {
+	sync.RWMutex
+	data map[string][]byte
+}
+
+var _ KeyValueStore = (*MemStore)(nil)
+
+func NewMemStore() *MemStore {
+	return &MemStore{data: make(map[string][]byte)}
+}
+
+func (m *MemStore) Get(key []byte) (value []byte, ok bool, err error) {
+	m.RLock()
+	defer m.RUnlock()
+	v, ok := m.data[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), v...), true, nil
+}
+
+func (m *MemStore) Put(key []byte, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *MemStore) Delete(key []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *MemStore) DeleteRange(start []byte, end []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	for k := range m.data {
+		if inRange([]byte(k), start, end) {
+			delete(m.data, k)
+		}
+	}
+	return nil
+}
+
+func inRange(key []byte, start []byte, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0
+}
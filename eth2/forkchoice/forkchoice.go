@@ -14,19 +14,33 @@ type ProtoForkChoice struct {
 	voteStore  VoteStore
 
 	balances []Gwei
+	// The total active balance of the justified state, including slashed validators, for the proposer boost.
+	totalActiveBalance Gwei
 	// If present, this overrules the forkchoice to start in this subtree,
 	// instead of the justified checkpoint.
 	pin       *NodeRef
 	justified Checkpoint
 	finalized Checkpoint
 	spec      *common.Spec
+
+	// The block that is boosted, zero if none.
+	proposerBoost NodeRef
+	// The boost that is currently part of the node weights, to undo when the boost changes.
+	appliedBoost      NodeRef
+	appliedBoostScore SignedGwei
+	boostChanged      bool
+
+	// The current slot: the latest of OnSlot and the slots of the processed nodes.
+	currentSlot Slot
+	// The current epoch, as of the last score update.
+	scoredEpoch Epoch
 }
 
 var _ Forkchoice = (*ProtoForkChoice)(nil)
 
 func NewForkChoice(spec *common.Spec, finalized Checkpoint, justified Checkpoint,
 	anchorRoot Root, anchorSlot Slot, graph ForkchoiceGraph, votes VoteStore,
-	initialBalances []Gwei) (Forkchoice, error) {
+	initialBalances []Gwei, initialTotalActive Gwei) (Forkchoice, error) {
 	fc := &ProtoForkChoice{
 		protoArray: graph,
 		voteStore:  votes,
@@ -38,8 +52,8 @@ func NewForkChoice(spec *common.Spec, finalized Checkpoint, justified Checkpoint
 	if err := fc.SetPin(anchorRoot, anchorSlot); err != nil {
 		return nil, err
 	}
-	if err := fc.updateJustified(finalized, justified, func() ([]Gwei, error) {
-		return initialBalances, nil
+	if err := fc.updateJustified(finalized, justified, func() ([]Gwei, Gwei, error) {
+		return initialBalances, initialTotalActive, nil
 	}); err != nil {
 		return nil, err
 	}
@@ -69,12 +83,14 @@ func (fc *ProtoForkChoice) SetPin(root Root, slot Slot) error {
 
 // UpdateJustified updates what is recognized as justified and finalized checkpoint,
 // and adjusts justified balances for vote weights.
+// The justified state balances are the vote weights, and the total active balance of the justified state,
+// including slashed validators (which have no vote weight), is used for the proposer boost.
 // If the finalized checkpoint changes, it triggers pruning.
 // Note that pruning can prune the pre-block node of the start slot of the finalized epoch, if it is not a gap slot.
 // And the finalizing node with the block will remain.
 // The justification/finalization trigger must be within the pinned subtree (if any).
 func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint,
-	justifiedStateBalances func() ([]Gwei, error)) error {
+	justifiedStateBalances func() (balances []Gwei, totalActive Gwei, err error)) error {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
 	// Old/same data? Ignore the change.
@@ -83,7 +99,7 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 	}
 	if fc.pin != nil && trigger != fc.pin.Root {
 		// check trigger against pin, to ensure no justification/finalization of data that conflicts with the pin.
-		if unknown, inSubtree := fc.InSubtree(fc.pin.Root, trigger); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.pin.Root, trigger); unknown {
 			return fmt.Errorf("cannot justify/finalize with unknown trigger when forkchoice is pinned")
 		} else if !inSubtree {
 			return fmt.Errorf("cannot justify/finalize outside of pinned forkchoice tree")
@@ -92,7 +108,7 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 
 	prevFinalized := fc.finalized
 
-	if err := fc.updateJustified(justified, finalized, justifiedStateBalances); err != nil {
+	if err := fc.updateJustified(finalized, justified, justifiedStateBalances); err != nil {
 		return err
 	}
 
@@ -108,14 +124,14 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 }
 
 func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Checkpoint,
-	justifiedStateBalances func() ([]Gwei, error)) error {
+	justifiedStateBalances func() (balances []Gwei, totalActive Gwei, err error)) error {
 	if justified.Epoch < finalized.Epoch {
 		return fmt.Errorf("justified epoch %d lower than finalized epoch %d", justified.Epoch, finalized.Epoch)
 	}
 
 	// check if new finalized checkpoint is valid
 	if fc.finalized != finalized {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, finalized.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, finalized.Root); unknown {
 			return fmt.Errorf("unknown finalized checkpoint: %s", finalized)
 		} else if !inSubtree || fc.finalized.Epoch > finalized.Epoch {
 			return fmt.Errorf("new finalized checkpoint %s is outside of finalized subtree: %s",
@@ -123,7 +139,7 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 		}
 	}
 	if fc.justified != justified {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, justified.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, justified.Root); unknown {
 			return fmt.Errorf("unknown justified checkpoint: %s", justified)
 		} else if !inSubtree || fc.finalized.Epoch > justified.Epoch {
 			return fmt.Errorf("new justified checkpoint %s is outside of finalized subtree: %s",
@@ -132,18 +148,23 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 	}
 
 	oldBals := fc.balances
-	newBals, err := justifiedStateBalances()
+	newBals, totalActive, err := justifiedStateBalances()
 	if err != nil {
 		return err
 	}
 
-	deltas := fc.voteStore.ComputeDeltas(fc.protoArray.Indices(), oldBals, newBals)
+	indices := fc.protoArray.Indices()
+	deltas := fc.voteStore.ComputeDeltas(indices, oldBals, newBals)
+	fc.applyProposerBoost(deltas, indices, totalActive)
 
-	if err := fc.protoArray.ApplyScoreChanges(deltas, justified.Epoch, finalized.Epoch); err != nil {
+	currentEpoch := fc.spec.SlotToEpoch(fc.currentSlot)
+	if err := fc.protoArray.ApplyScoreChanges(deltas, justified, finalized, currentEpoch); err != nil {
 		return err
 	}
 
 	fc.balances = newBals
+	fc.totalActiveBalance = totalActive
+	fc.scoredEpoch = currentEpoch
 	fc.justified = justified
 	fc.finalized = finalized
 
@@ -154,13 +175,90 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 //
 //	(if not bigger than previous difference between head-node contenders)
 func (fc *ProtoForkChoice) updateVotesMaybe() error {
-	if !fc.voteStore.HasChanges() {
+	// A new epoch changes which nodes are viable, even without any vote changes.
+	currentEpoch := fc.spec.SlotToEpoch(fc.currentSlot)
+	if !fc.voteStore.HasChanges() && !fc.boostChanged && currentEpoch == fc.scoredEpoch {
 		return nil
 	}
 
-	deltas := fc.voteStore.ComputeDeltas(fc.protoArray.Indices(), fc.balances, fc.balances)
+	indices := fc.protoArray.Indices()
+	deltas := fc.voteStore.ComputeDeltas(indices, fc.balances, fc.balances)
+	fc.applyProposerBoost(deltas, indices, fc.totalActiveBalance)
+
+	if err := fc.protoArray.ApplyScoreChanges(deltas, fc.justified, fc.finalized, currentEpoch); err != nil {
+		return err
+	}
+	fc.scoredEpoch = currentEpoch
+	return nil
+}
+
+// applyProposerBoost undoes the previously applied boost, and applies the current boost, if any.
+// The boost is recomputed with the given total active balance, since the committee weight changes with the justified state.
+func (fc *ProtoForkChoice) applyProposerBoost(deltas []SignedGwei, indices map[NodeRef]NodeIndex, totalActive Gwei) {
+	if fc.appliedBoostScore != 0 {
+		// if the boosted node was pruned, there is nothing to undo.
+		if i, ok := indices[fc.appliedBoost]; ok {
+			deltas[i] -= fc.appliedBoostScore
+		}
+	}
+	fc.appliedBoost = NodeRef{}
+	fc.appliedBoostScore = 0
+	if fc.proposerBoost != (NodeRef{}) {
+		if i, ok := indices[fc.proposerBoost]; ok {
+			score := fc.proposerScore(totalActive)
+			deltas[i] += score
+			fc.appliedBoost = fc.proposerBoost
+			fc.appliedBoostScore = score
+		}
+	}
+	fc.boostChanged = false
+}
+
+// proposerScore is the committee weight (total active balance per slot), scaled by PROPOSER_SCORE_BOOST percent.
+// Like get_total_active_balance, the total is at least EFFECTIVE_BALANCE_INCREMENT.
+func (fc *ProtoForkChoice) proposerScore(totalActive Gwei) SignedGwei {
+	total := totalActive
+	if total < fc.spec.EFFECTIVE_BALANCE_INCREMENT {
+		total = fc.spec.EFFECTIVE_BALANCE_INCREMENT
+	}
+	committeeWeight := total / Gwei(fc.spec.SLOTS_PER_EPOCH)
+	return SignedGwei(committeeWeight * Gwei(fc.spec.PROPOSER_SCORE_BOOST) / 100)
+}
 
-	return fc.protoArray.ApplyScoreChanges(deltas, fc.justified.Epoch, fc.finalized.Epoch)
+func (fc *ProtoForkChoice) SetProposerBoost(blockRoot Root, blockSlot Slot) (ok bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ref := NodeRef{Root: blockRoot, Slot: blockSlot}
+	if _, ok := fc.protoArray.Indices()[ref]; !ok {
+		return false
+	}
+	if fc.proposerBoost != ref {
+		fc.proposerBoost = ref
+		fc.boostChanged = true
+	}
+	return true
+}
+
+func (fc *ProtoForkChoice) ProposerBoost() NodeRef {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.proposerBoost
+}
+
+func (fc *ProtoForkChoice) OnSlot(slot Slot) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.updateSlot(slot)
+	if fc.proposerBoost != (NodeRef{}) && fc.proposerBoost.Slot < slot {
+		fc.proposerBoost = NodeRef{}
+		fc.boostChanged = true
+	}
+}
+
+func (fc *ProtoForkChoice) updateSlot(slot Slot) {
+	if slot > fc.currentSlot {
+		fc.currentSlot = slot
+	}
 }
 
 func (fc *ProtoForkChoice) Justified() Checkpoint {
@@ -180,12 +278,18 @@ func (fc *ProtoForkChoice) ProcessAttestation(index ValidatorIndex, blockRoot Ro
 	defer fc.mu.Unlock()
 	// only add the vote if we can. Don't add if it's not within view.
 	blockSlot, ok := fc.protoArray.GetSlot(blockRoot)
-	if !ok || blockSlot < headSlot {
+	if !ok || blockSlot > headSlot {
 		return false
 	}
 	return fc.voteStore.ProcessAttestation(index, blockRoot, headSlot)
 }
 
+func (fc *ProtoForkChoice) ProcessEquivocation(indices []ValidatorIndex) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.voteStore.ProcessEquivocation(indices)
+}
+
 func (fc *ProtoForkChoice) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
@@ -195,13 +299,17 @@ func (fc *ProtoForkChoice) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]E
 func (fc *ProtoForkChoice) ProcessSlot(parentRoot Root, slot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
+	fc.updateSlot(slot)
 	fc.protoArray.ProcessSlot(parentRoot, slot, justifiedEpoch, finalizedEpoch)
 }
 
-func (fc *ProtoForkChoice) ProcessBlock(parentRoot Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool) {
+func (fc *ProtoForkChoice) ProcessBlock(parentRoot Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	unrealizedJustifiedEpoch Epoch, unrealizedFinalizedEpoch Epoch, executionBlockHash Hash32) (ok bool) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
-	return fc.protoArray.ProcessBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch)
+	fc.updateSlot(blockSlot)
+	return fc.protoArray.ProcessBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch,
+		unrealizedJustifiedEpoch, unrealizedFinalizedEpoch, executionBlockHash)
 }
 
 func (fc *ProtoForkChoice) InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool) {
@@ -246,6 +354,11 @@ func (fc *ProtoForkChoice) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef,
 func (fc *ProtoForkChoice) Head() (NodeRef, error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
+	return fc.head()
+}
+
+// head finds the head, the forkchoice must be locked for writing.
+func (fc *ProtoForkChoice) head() (NodeRef, error) {
 	if err := fc.updateVotesMaybe(); err != nil {
 		return NodeRef{}, err
 	}
@@ -257,3 +370,35 @@ func (fc *ProtoForkChoice) Head() (NodeRef, error) {
 	}
 	return fc.protoArray.FindHead(root, slot)
 }
+
+func (fc *ProtoForkChoice) ExecutionStatus(blockRoot Root) (status ExecutionStatus, ok bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.protoArray.ExecutionStatus(blockRoot)
+}
+
+func (fc *ProtoForkChoice) SetPayloadStatus(blockRoot Root, blockHash Hash32, status ExecutionStatus) (ok bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.SetPayloadStatus(blockRoot, blockHash, status)
+}
+
+func (fc *ProtoForkChoice) InvalidatePayload(blockRoot Root, latestValidHash *Hash32) (ok bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.InvalidatePayload(blockRoot, latestValidHash)
+}
+
+func (fc *ProtoForkChoice) IsHeadOptimistic() (bool, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	head, err := fc.head()
+	if err != nil {
+		return false, err
+	}
+	status, ok := fc.protoArray.ExecutionStatus(head.Root)
+	if !ok {
+		return false, fmt.Errorf("unknown head %s", head)
+	}
+	return status == ExecutionOptimistic, nil
+}
@@ -2,6 +2,7 @@ package forkchoice
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
@@ -14,9 +15,39 @@ type Gwei = common.Gwei
 type Checkpoint = common.Checkpoint
 type NodeRef = common.NodeRef
 type ExtendedNodeRef = common.ExtendedNodeRef
+type Hash32 = common.Hash32
 type SignedGwei int64
 type NodeIndex uint64
 
+// ExecutionStatus is the status of the execution payload of a node, as reported by the execution engine.
+// Empty slot nodes share the status of the block they build on.
+type ExecutionStatus uint8
+
+const (
+	// Pre-merge blocks have no execution payload to verify, they are treated like valid blocks.
+	ExecutionIrrelevant ExecutionStatus = iota
+	// The payload is not verified yet, e.g. while the execution engine is syncing.
+	ExecutionOptimistic
+	ExecutionValid
+	// Invalid nodes, and all their descendants, are never chosen as head.
+	ExecutionInvalid
+)
+
+func (s ExecutionStatus) String() string {
+	switch s {
+	case ExecutionIrrelevant:
+		return "IRRELEVANT"
+	case ExecutionOptimistic:
+		return "OPTIMISTIC"
+	case ExecutionValid:
+		return "VALID"
+	case ExecutionInvalid:
+		return "INVALID"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+	}
+}
+
 type ForkchoiceView interface {
 	CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error)
 	ClosestToSlot(anchor Root, slot Slot) (closest NodeRef, err error)
@@ -25,18 +56,39 @@ type ForkchoiceView interface {
 	FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error)
 	InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool)
 	Search(anchor NodeRef, parentRoot *Root, slot *Slot) (nonCanon []NodeRef, canon []NodeRef, err error)
+	ExecutionStatus(blockRoot Root) (status ExecutionStatus, ok bool)
 }
 
 type ForkchoiceNodeInput interface {
 	ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch)
-	ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool)
+	// ProcessBlock adds the block. The execution block hash is the block hash of the payload of the block,
+	// zero if the block has no payload. Blocks with a payload start optimistic.
+	ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+		unrealizedJustifiedEpoch Epoch, unrealizedFinalizedEpoch Epoch, executionBlockHash Hash32) (ok bool)
+}
+
+type ExecutionStatusInput interface {
+	// SetPayloadStatus records the execution block hash of the block, and the status of its payload.
+	// A valid payload makes all ancestors valid too. An invalid payload invalidates all descendants too.
+	// The block hash is already known if it was given to ProcessBlock.
+	// If the block is not known, no changes are made, and ok=false is returned.
+	SetPayloadStatus(blockRoot Root, blockHash Hash32, status ExecutionStatus) (ok bool)
+	// InvalidatePayload invalidates the block and all its descendants, as well as the ancestors of the block
+	// after the latest valid execution block hash, as reported by the execution engine.
+	// The weight of invalid nodes is removed from their valid ancestors.
+	// The ancestor with the latest valid hash becomes valid. A zero latest valid hash invalidates
+	// all ancestors with an execution payload. If the latest valid hash is nil or unknown,
+	// only the block and its descendants are invalidated.
+	// If the block is not known, no changes are made, and ok=false is returned.
+	InvalidatePayload(blockRoot Root, latestValidHash *Hash32) (ok bool)
 }
 
 type ForkchoiceGraph interface {
 	ForkchoiceView
 	ForkchoiceNodeInput
+	ExecutionStatusInput
 	Indices() map[NodeRef]NodeIndex
-	ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch) error
+	ApplyScoreChanges(deltas []SignedGwei, justified Checkpoint, finalized Checkpoint, currentEpoch Epoch) error
 	OnPrune(ctx context.Context, anchorRoot Root, anchorSlot Slot) error
 }
 
@@ -45,6 +97,9 @@ type VoteInput interface {
 	// If the root/slot combination does not exist, no changes are made, and ok=false is returned.
 	// It is up to the caller if nodes should be added, to then process the attestation.
 	ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot) (ok bool)
+	// ProcessEquivocation marks the validators as equivocating, e.g. when proven by an attester slashing.
+	// Their vote weight is removed from the tree for good, and any later votes are ignored.
+	ProcessEquivocation(indices []ValidatorIndex)
 }
 
 type VoteStore interface {
@@ -53,15 +108,31 @@ type VoteStore interface {
 	ComputeDeltas(indices map[NodeRef]NodeIndex, oldBalances []Gwei, newBalances []Gwei) []SignedGwei
 }
 
+type ProposerBoostInput interface {
+	// SetProposerBoost marks the block as timely: received during its own slot, before the attestation deadline.
+	// The block is boosted with PROPOSER_SCORE_BOOST percent of the committee weight, until the boost is cleared.
+	// If the block is not known, no changes are made, and ok=false is returned.
+	SetProposerBoost(blockRoot Root, blockSlot Slot) (ok bool)
+	// ProposerBoost returns the boosted block, or a zero ref if there is none.
+	ProposerBoost() NodeRef
+	// OnSlot updates the current time, and clears the proposer boost if it was set for a block of an earlier slot.
+	// The current epoch determines which nodes are pulled up to their unrealized justification.
+	OnSlot(slot Slot)
+}
+
 type Forkchoice interface {
 	ForkchoiceView
 	ForkchoiceNodeInput
 	VoteInput
+	ProposerBoostInput
+	ExecutionStatusInput
 	UpdateJustified(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint,
-		justifiedStateBalances func() ([]Gwei, error)) error
+		justifiedStateBalances func() (balances []Gwei, totalActive Gwei, err error)) error
 	Pin() *NodeRef
 	SetPin(root Root, slot Slot) error
 	Justified() Checkpoint
 	Finalized() Checkpoint
 	Head() (NodeRef, error)
+	// IsHeadOptimistic returns true if the execution payload of the head is not verified yet.
+	IsHeadOptimistic() (bool, error)
 }
@@ -0,0 +1,122 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func ProposerBoostTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 2 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//     0
+	//    / \
+	//   1   2 (both slot 1)
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(2), BlockSlot: 1})
+	// no votes, the tie is broken by root
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// unknown blocks cannot be boosted
+	add(&OpSetProposerBoost{BlockRoot: hash(3), BlockSlot: 1, Ok: false})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// the timely block gets the boost
+	add(&OpSetProposerBoost{BlockRoot: hash(1), BlockSlot: 1, Ok: true})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+	add(&OpOnSlot{Slot: 1})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+
+	// the boost is cleared on the next slot
+	add(&OpOnSlot{Slot: 2})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// the boost is less than a full validator vote
+	add(&OpSetProposerBoost{BlockRoot: hash(1), BlockSlot: 1, Ok: true})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(2), HeadSlot: 1, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(1), HeadSlot: 1, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+	add(&OpOnSlot{Slot: 2})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
+
+// ProposerBoostSlashedTestDef checks that the proposer boost is based on the total active balance,
+// which includes the active slashed validators that have no vote weight.
+func ProposerBoostSlashedTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	// 82 active validators, of which the first 4 are slashed.
+	// The boost is 40% of 82/32 validators, more than a single vote.
+	// Without the slashed validators it would be 40% of 78/32 validators, less than a single vote.
+	balances := make([]forkchoice.Gwei, 82)
+	for i := 4; i < len(balances); i++ {
+		balances[i] = spec.MAX_EFFECTIVE_BALANCE
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           balances,
+		TotalActiveBalance: 82 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//     0
+	//    / \
+	//   1   2 (both slot 1)
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(2), BlockSlot: 1})
+	add(&OpProcessAttestation{ValidatorIndex: 4, BlockRoot: hash(2), HeadSlot: 1, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// the boost outweighs the vote
+	add(&OpSetProposerBoost{BlockRoot: hash(1), BlockSlot: 1, Ok: true})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+	add(&OpOnSlot{Slot: 2})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
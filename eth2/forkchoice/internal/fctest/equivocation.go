@@ -0,0 +1,63 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func EquivocationTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	init := ForkChoiceTestInit{
+		Spec:         spec,
+		Finalized:    forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:    forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:   hash(0),
+		AnchorSlot:   0,
+		AnchorParent: hash(0),
+		Balances: []forkchoice.Gwei{
+			spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE,
+		},
+		TotalActiveBalance: 3 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//     0
+	//    / \
+	//   2   1 (both slot 1)
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(2), BlockSlot: 1})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(1), HeadSlot: 1, CanAdd: true})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(1), HeadSlot: 1, CanAdd: true})
+	add(&OpProcessAttestation{ValidatorIndex: 2, BlockRoot: hash(2), HeadSlot: 1, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+
+	// Validators 0 and 1 get slashed, their weight is removed from block 1.
+	add(&OpProcessEquivocation{Indices: []forkchoice.ValidatorIndex{0, 1}})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// Later votes of equivocating validators are ignored.
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(1), HeadSlot: 40, CanAdd: false})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(1), HeadSlot: 40, CanAdd: false})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	// Repeated equivocations do not remove weight twice.
+	add(&OpProcessEquivocation{Indices: []forkchoice.ValidatorIndex{1}})
+	add(&OpHead{ExpectedHead: ref(2, 1), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
@@ -18,13 +18,14 @@ func LighthouseTestDef() *ForkChoiceTestDef {
 	//	return s
 	//}
 	init := ForkChoiceTestInit{
-		Spec:         spec,
-		Finalized:    forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
-		Justified:    forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
-		AnchorRoot:   hash(0),
-		AnchorSlot:   0,
-		AnchorParent: hash(0),
-		Balances:     []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 2 * spec.MAX_EFFECTIVE_BALANCE,
 	}
 	var ops []Operation
 	add := func(op Operation) {
@@ -0,0 +1,89 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func OptimisticTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	// execution block hashes, distinct from the block roots
+	payload := func(i uint64) (out forkchoice.Hash32) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		out[31] = 0xee
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 3 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//       0
+	//      / \
+	//     1   6 (slot 1)
+	//    / \
+	//   2   4 (slot 2)
+	//   |
+	//   3 (slot 3)
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1, ExecutionBlockHash: payload(1)})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(6), BlockSlot: 1, ExecutionBlockHash: payload(6)})
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(2), BlockSlot: 2, ExecutionBlockHash: payload(2)})
+	add(&OpProcessBlock{Parent: hash(2), BlockRoot: hash(3), BlockSlot: 3, ExecutionBlockHash: payload(3)})
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(4), BlockSlot: 2, ExecutionBlockHash: payload(4)})
+	// blocks with a payload start optimistic
+	add(&OpExecutionStatus{BlockRoot: hash(0), Status: forkchoice.ExecutionIrrelevant, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(1), Status: forkchoice.ExecutionOptimistic, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(3), Status: forkchoice.ExecutionOptimistic, Ok: true})
+	add(&OpSetPayloadStatus{BlockRoot: hash(5), BlockHash: payload(5), Status: forkchoice.ExecutionValid, Ok: false})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(3), HeadSlot: 3, CanAdd: true})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(3), HeadSlot: 3, CanAdd: true})
+	add(&OpProcessAttestation{ValidatorIndex: 2, BlockRoot: hash(6), HeadSlot: 3, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(3, 3), Ok: true})
+	add(&OpIsHeadOptimistic{Optimistic: true})
+
+	// The engine reports block 3 as invalid, with the payload of block 1 as latest valid payload:
+	// block 2 is invalid too, and block 1 is valid, even though its payload status was never set.
+	latestValid := payload(1)
+	add(&OpInvalidatePayload{BlockRoot: hash(3), LatestValidHash: &latestValid, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(3), Status: forkchoice.ExecutionInvalid, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(2), Status: forkchoice.ExecutionInvalid, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(1), Status: forkchoice.ExecutionValid, Ok: true})
+	add(&OpExecutionStatus{BlockRoot: hash(4), Status: forkchoice.ExecutionOptimistic, Ok: true})
+	// The votes for the invalid branch no longer count for block 1, block 6 has more weight.
+	add(&OpHead{ExpectedHead: ref(6, 1), Ok: true})
+	add(&OpIsHeadOptimistic{Optimistic: true})
+
+	// Descendants of invalid blocks are invalid.
+	add(&OpProcessBlock{Parent: hash(2), BlockRoot: hash(5), BlockSlot: 4, ExecutionBlockHash: payload(5)})
+	add(&OpExecutionStatus{BlockRoot: hash(5), Status: forkchoice.ExecutionInvalid, Ok: true})
+	add(&OpHead{ExpectedHead: ref(6, 1), Ok: true})
+
+	// Once the engine is synced, the head is no longer optimistic.
+	add(&OpSetPayloadStatus{BlockRoot: hash(6), BlockHash: payload(6), Status: forkchoice.ExecutionValid, Ok: true})
+	add(&OpIsHeadOptimistic{Optimistic: false})
+	add(&OpInvalidatePayload{BlockRoot: hash(7), Ok: false})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
@@ -0,0 +1,93 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func PruneTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 2 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//          0
+	//          |
+	//          *
+	//         / \
+	//        1   *
+	//            |
+	//            2
+	//            |
+	//           ... (empty slots)
+	//            |
+	//            3 (slot 33, justifies and finalizes block 2 in epoch 1)
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(2), BlockSlot: 2})
+	add(&OpProcessBlock{Parent: hash(2), BlockRoot: hash(3), BlockSlot: 33, JustifiedEpoch: 1, FinalizedEpoch: 1})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(3), HeadSlot: 33, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(3, 33), Ok: true})
+
+	// Finalizing block 2 in epoch 1 prunes everything before slot 32 of block 2, and the conflicting block 1.
+	add(&OpPruneable{Pruneable: ref(0, 0), Canonical: true})
+	add(&OpPruneable{Pruneable: ref(0, 1), Canonical: true})
+	add(&OpPruneable{Pruneable: ref(0, 2), Canonical: true})
+	add(&OpPruneable{Pruneable: ref(1, 1), Canonical: false})
+	for i := forkchoice.Slot(2); i < 32; i++ {
+		add(&OpPruneable{Pruneable: ref(2, i), Canonical: true})
+	}
+	add(&OpUpdateJustified{
+		Trigger:   hash(3),
+		Justified: forkchoice.Checkpoint{Root: hash(2), Epoch: 1},
+		Finalized: forkchoice.Checkpoint{Root: hash(2), Epoch: 1},
+		JustifiedStateBalances: func() ([]forkchoice.Gwei, forkchoice.Gwei, error) {
+			return []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE}, 2 * spec.MAX_EFFECTIVE_BALANCE, nil
+		},
+		Ok: true,
+	})
+	add(&OpHead{ExpectedHead: ref(3, 33), Ok: true})
+	add(&OpGetSlot{BlockRoot: hash(2), Slot: 32, Ok: true})
+	add(&OpGetSlot{BlockRoot: hash(1), Ok: false})
+	add(&OpIsAncestor{Anchor: hash(2), Root: hash(3), Unknown: false, InSubtree: true})
+	add(&OpCanonicalChain{
+		AnchorRoot: hash(2),
+		AnchorSlot: 32,
+		Expected: []forkchoice.ExtendedNodeRef{
+			{NodeRef: ref(3, 33), ParentRoot: hash(2)},
+			{NodeRef: ref(2, 33), ParentRoot: hash(2)},
+			{NodeRef: ref(2, 32), ParentRoot: hash(2)},
+		},
+		Ok: true,
+	})
+
+	// Votes keep counting after pruning. Equal weight, the tie is broken by root.
+	add(&OpProcessBlock{Parent: hash(2), BlockRoot: hash(4), BlockSlot: 34, JustifiedEpoch: 1, FinalizedEpoch: 1})
+	add(&OpHead{ExpectedHead: ref(3, 33), Ok: true})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(4), HeadSlot: 34, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(4, 34), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
@@ -0,0 +1,56 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func TieBreakTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 2 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//      0
+	//     / \
+	//    1   *
+	//        |
+	//        *
+	//        |
+	//        3
+	// Without votes, the tie is broken by the root of the block each side leads to, not by the empty slot nodes.
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpHead{ExpectedHead: ref(1, 1), Ok: true})
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(3), BlockSlot: 3})
+	add(&OpHead{ExpectedHead: ref(3, 3), Ok: true})
+
+	// A new block without votes wins over the empty slot it competes with, even with a lower root,
+	// like the spec, where an empty slot is never a candidate for the head.
+	add(&OpProcessBlock{Parent: hash(3), BlockRoot: hash(256), BlockSlot: 4})
+	add(&OpHead{ExpectedHead: ref(256, 4), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
@@ -0,0 +1,88 @@
+package fctest
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func UnrealizedTestDef() *ForkChoiceTestDef {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	ref := func(i uint64, slot forkchoice.Slot) forkchoice.NodeRef {
+		return forkchoice.NodeRef{Root: hash(i), Slot: slot}
+	}
+	balances := func() ([]forkchoice.Gwei, forkchoice.Gwei, error) {
+		return []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+			3 * spec.MAX_EFFECTIVE_BALANCE, nil
+	}
+	init := ForkChoiceTestInit{
+		Spec:               spec,
+		Finalized:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		Justified:          forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		AnchorRoot:         hash(0),
+		AnchorSlot:         0,
+		AnchorParent:       hash(0),
+		Balances:           []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE},
+		TotalActiveBalance: 3 * spec.MAX_EFFECTIVE_BALANCE,
+	}
+	var ops []Operation
+	add := func(op Operation) {
+		ops = append(ops, op)
+	}
+
+	//       0
+	//       |
+	//       1
+	//       |
+	//      ... (empty slots)
+	//    /  |  \  \
+	//   2   3   4  5 (slots 65, 66, 67, 97)
+	//
+	// Block 2 justifies epoch 2 (checkpoint root 1).
+	// Block 3 has no justification, realized or unrealized.
+	// Block 4 has no realized justification, but would justify epoch 2 at the end of the epoch.
+	// Block 5 justifies epoch 1, and would justify epoch 2 at the end of the epoch.
+	add(&OpProcessBlock{Parent: hash(0), BlockRoot: hash(1), BlockSlot: 1})
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(2), BlockSlot: 65, JustifiedEpoch: 2})
+	add(&OpUpdateJustified{
+		Trigger:                hash(2),
+		Justified:              forkchoice.Checkpoint{Root: hash(1), Epoch: 2},
+		Finalized:              forkchoice.Checkpoint{Root: hash(0), Epoch: 0},
+		JustifiedStateBalances: balances,
+		Ok:                     true,
+	})
+	add(&OpHead{ExpectedHead: ref(2, 65), Ok: true})
+
+	// The voting source of block 3 does not match the justified checkpoint, it is not viable, even with votes.
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(3), BlockSlot: 66})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(3), HeadSlot: 66, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(2, 65), Ok: true})
+
+	// In epoch 3 the previous epoch is justified. Then a block with a voting source of at most two epochs old
+	// is viable, if its unrealized justification is not older than the justified checkpoint.
+	add(&OpOnSlot{Slot: 96})
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(5), BlockSlot: 97, JustifiedEpoch: 1, UnrealizedJustifiedEpoch: 2})
+	add(&OpProcessAttestation{ValidatorIndex: 0, BlockRoot: hash(5), HeadSlot: 97, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(5, 97), Ok: true})
+
+	// Later, blocks 3 and 5 are from a previous epoch, and pulled up to their unrealized justification.
+	// Block 3 has no justification at all, block 5 matches the justified checkpoint.
+	add(&OpOnSlot{Slot: 160})
+	add(&OpHead{ExpectedHead: ref(5, 97), Ok: true})
+
+	// Block 4 is pulled up to its unrealized justification, which matches the justified checkpoint.
+	add(&OpProcessBlock{Parent: hash(1), BlockRoot: hash(4), BlockSlot: 67, UnrealizedJustifiedEpoch: 2})
+	add(&OpProcessAttestation{ValidatorIndex: 1, BlockRoot: hash(4), HeadSlot: 67, CanAdd: true})
+	add(&OpProcessAttestation{ValidatorIndex: 2, BlockRoot: hash(4), HeadSlot: 67, CanAdd: true})
+	add(&OpHead{ExpectedHead: ref(4, 67), Ok: true})
+
+	return &ForkChoiceTestDef{
+		Init:       init,
+		Operations: ops,
+	}
+}
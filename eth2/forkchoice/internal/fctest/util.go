@@ -185,10 +185,16 @@ type OpProcessBlock struct {
 	BlockSlot      forkchoice.Slot
 	JustifiedEpoch forkchoice.Epoch
 	FinalizedEpoch forkchoice.Epoch
+	// Optional, defaults to the realized epochs
+	UnrealizedJustifiedEpoch forkchoice.Epoch
+	UnrealizedFinalizedEpoch forkchoice.Epoch
+	// Optional, zero if the block has no execution payload
+	ExecutionBlockHash forkchoice.Hash32
 }
 
 func (op *OpProcessBlock) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	fc.ProcessBlock(op.Parent, op.BlockRoot, op.BlockSlot, op.JustifiedEpoch, op.FinalizedEpoch)
+	fc.ProcessBlock(op.Parent, op.BlockRoot, op.BlockSlot, op.JustifiedEpoch, op.FinalizedEpoch,
+		op.UnrealizedJustifiedEpoch, op.UnrealizedFinalizedEpoch, op.ExecutionBlockHash)
 	return nil
 }
 
@@ -207,6 +213,96 @@ func (op *OpProcessAttestation) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Fo
 	return nil
 }
 
+type OpProcessEquivocation struct {
+	Indices []forkchoice.ValidatorIndex
+}
+
+func (op *OpProcessEquivocation) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	fc.ProcessEquivocation(op.Indices)
+	return nil
+}
+
+type OpSetProposerBoost struct {
+	BlockRoot forkchoice.Root
+	BlockSlot forkchoice.Slot
+	Ok        bool
+}
+
+func (op *OpSetProposerBoost) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	if ok := fc.SetProposerBoost(op.BlockRoot, op.BlockSlot); ok != op.Ok {
+		return fmt.Errorf("setting proposer boost different result: ok %v <> %v", ok, op.Ok)
+	}
+	return nil
+}
+
+type OpOnSlot struct {
+	Slot forkchoice.Slot
+}
+
+func (op *OpOnSlot) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	fc.OnSlot(op.Slot)
+	return nil
+}
+
+type OpSetPayloadStatus struct {
+	BlockRoot forkchoice.Root
+	BlockHash forkchoice.Hash32
+	Status    forkchoice.ExecutionStatus
+	Ok        bool
+}
+
+func (op *OpSetPayloadStatus) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	if ok := fc.SetPayloadStatus(op.BlockRoot, op.BlockHash, op.Status); ok != op.Ok {
+		return fmt.Errorf("setting payload status different result: ok %v <> %v", ok, op.Ok)
+	}
+	return nil
+}
+
+type OpInvalidatePayload struct {
+	BlockRoot       forkchoice.Root
+	LatestValidHash *forkchoice.Hash32
+	Ok              bool
+}
+
+func (op *OpInvalidatePayload) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	if ok := fc.InvalidatePayload(op.BlockRoot, op.LatestValidHash); ok != op.Ok {
+		return fmt.Errorf("invalidating payload different result: ok %v <> %v", ok, op.Ok)
+	}
+	return nil
+}
+
+type OpExecutionStatus struct {
+	BlockRoot forkchoice.Root
+	Status    forkchoice.ExecutionStatus
+	Ok        bool
+}
+
+func (op *OpExecutionStatus) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	status, ok := fc.ExecutionStatus(op.BlockRoot)
+	if ok != op.Ok {
+		return fmt.Errorf("execution status lookup different result: ok %v <> %v", ok, op.Ok)
+	}
+	if status != op.Status {
+		return fmt.Errorf("different execution status for root %s: %s <> %s", op.BlockRoot, status, op.Status)
+	}
+	return nil
+}
+
+type OpIsHeadOptimistic struct {
+	Optimistic bool
+}
+
+func (op *OpIsHeadOptimistic) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
+	optimistic, err := fc.IsHeadOptimistic()
+	if err != nil {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+	if optimistic != op.Optimistic {
+		return fmt.Errorf("different head optimistic status: %v <> %v", optimistic, op.Optimistic)
+	}
+	return nil
+}
+
 type OpPruneable struct {
 	Pruneable forkchoice.NodeRef
 	Canonical bool
@@ -221,12 +317,12 @@ type OpUpdateJustified struct {
 	Trigger                forkchoice.Root
 	Justified              forkchoice.Checkpoint
 	Finalized              forkchoice.Checkpoint
-	JustifiedStateBalances func() ([]forkchoice.Gwei, error)
+	JustifiedStateBalances func() ([]forkchoice.Gwei, forkchoice.Gwei, error)
 	Ok                     bool
 }
 
 func (op *OpUpdateJustified) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	err := fc.UpdateJustified(context.Background(), op.Trigger, op.Finalized, op.Justified, op.JustifiedStateBalances)
+	err := fc.UpdateJustified(context.Background(), op.Trigger, op.Justified, op.Finalized, op.JustifiedStateBalances)
 	if op.Ok && err != nil {
 		return fmt.Errorf("unexpected error: %v", err)
 	}
@@ -244,6 +340,8 @@ type ForkChoiceTestInit struct {
 	AnchorSlot   forkchoice.Slot
 	AnchorParent forkchoice.Root
 	Balances     []forkchoice.Gwei
+	// TotalActiveBalance includes slashed validators, which have a zero balance in Balances.
+	TotalActiveBalance forkchoice.Gwei
 }
 
 type ForkChoiceTestDef struct {
@@ -252,6 +350,13 @@ type ForkChoiceTestDef struct {
 }
 
 func (fd *ForkChoiceTestDef) Run(prepare func(init *ForkChoiceTestInit, ft *ForkChoiceTestTarget) (forkchoice.Forkchoice, error)) error {
+	return fd.RunWithRestarts(prepare, nil)
+}
+
+// RunWithRestarts runs the test, and replaces the forkchoice with the result of restart after every operation.
+// The restart function may be nil.
+func (fd *ForkChoiceTestDef) RunWithRestarts(prepare func(init *ForkChoiceTestInit, ft *ForkChoiceTestTarget) (forkchoice.Forkchoice, error),
+	restart func(fc forkchoice.Forkchoice) (forkchoice.Forkchoice, error)) error {
 	ft := &ForkChoiceTestTarget{
 		Pruneable: make(map[forkchoice.NodeRef]bool),
 	}
@@ -263,6 +368,11 @@ func (fd *ForkChoiceTestDef) Run(prepare func(init *ForkChoiceTestInit, ft *Fork
 		if err := op.Apply(ft, fc); err != nil {
 			return fmt.Errorf("test failed at step %d: %v", i, err)
 		}
+		if restart != nil {
+			if fc, err = restart(fc); err != nil {
+				return fmt.Errorf("failed restart after step %d: %v", i, err)
+			}
+		}
 	}
 	return nil
 }
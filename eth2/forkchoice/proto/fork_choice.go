@@ -7,8 +7,8 @@ import (
 
 func NewProtoForkChoice(spec *common.Spec, finalized Checkpoint, justified Checkpoint,
 	anchorRoot Root, anchorSlot Slot, anchorParent Root,
-	initialBalances []Gwei, sink NodeSink) (Forkchoice, error) {
+	initialBalances []Gwei, initialTotalActive Gwei, sink NodeSink) (Forkchoice, error) {
 	return NewForkChoice(spec, finalized, justified, anchorRoot, anchorSlot,
-		NewProtoArray(anchorParent, anchorRoot, anchorSlot, justified.Epoch, finalized.Epoch, sink),
-		NewProtoVoteStore(spec), initialBalances)
+		NewProtoArray(spec, anchorParent, anchorRoot, anchorSlot, justified.Epoch, finalized.Epoch, sink),
+		NewProtoVoteStore(spec), initialBalances, initialTotalActive)
 }
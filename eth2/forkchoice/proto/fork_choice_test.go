@@ -1,32 +1,141 @@
 package proto
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"testing"
 
+	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/zrnt/eth2/forkchoice"
 	"github.com/protolambda/zrnt/eth2/forkchoice/internal/fctest"
 )
 
 func TestProtoArray(t *testing.T) {
-	lhtest := fctest.LighthouseTestDef()
-	err := lhtest.Run(func(init *fctest.ForkChoiceTestInit, ft *fctest.ForkChoiceTestTarget) (forkchoice.Forkchoice, error) {
-		return NewProtoForkChoice(init.Spec, init.Finalized, init.Justified, init.AnchorRoot, init.AnchorSlot, init.AnchorParent, init.Balances,
-			NodeSinkFn(func(ctx context.Context, ref forkchoice.NodeRef, canonical bool) error {
-				// whenever something is pruned, check if it was allowed to be pruned,
-				// and if it's marked as canonical correctly.
-				expectedCanonical, ok := ft.Pruneable[ref]
-				if !ok {
-					return fmt.Errorf("unexpected pruning of node %s", ref)
-				}
-				if canonical != expectedCanonical {
-					return fmt.Errorf("bad pruning, pruned as canonical=%v, but expected %v", canonical, expectedCanonical)
-				}
-				return nil
-			}))
+	runTestDef(t, fctest.LighthouseTestDef())
+}
+
+func TestProtoArrayPrune(t *testing.T) {
+	runTestDef(t, fctest.PruneTestDef())
+}
+
+func runTestDef(t *testing.T, def *fctest.ForkChoiceTestDef) {
+	if err := def.Run(prepareTestDef); err != nil {
+		t.Error(err)
+	}
+}
+
+func prepareTestDef(init *fctest.ForkChoiceTestInit, ft *fctest.ForkChoiceTestTarget) (forkchoice.Forkchoice, error) {
+	return NewProtoForkChoice(init.Spec, init.Finalized, init.Justified, init.AnchorRoot, init.AnchorSlot, init.AnchorParent, init.Balances, init.TotalActiveBalance,
+		testNodeSink(ft))
+}
+
+func testNodeSink(ft *fctest.ForkChoiceTestTarget) NodeSink {
+	return NodeSinkFn(func(ctx context.Context, ref forkchoice.NodeRef, canonical bool) error {
+		// whenever something is pruned, check if it was allowed to be pruned,
+		// and if it's marked as canonical correctly.
+		expectedCanonical, ok := ft.Pruneable[ref]
+		if !ok {
+			return fmt.Errorf("unexpected pruning of node %s", ref)
+		}
+		if canonical != expectedCanonical {
+			return fmt.Errorf("bad pruning, pruned as canonical=%v, but expected %v", canonical, expectedCanonical)
+		}
+		return nil
 	})
+}
+
+// Every test must give the same results when the forkchoice is restored from a snapshot after every step.
+func TestProtoForkChoiceSnapshot(t *testing.T) {
+	defs := map[string]func() *fctest.ForkChoiceTestDef{
+		"lighthouse":   fctest.LighthouseTestDef,
+		"prune":        fctest.PruneTestDef,
+		"boost":        fctest.ProposerBoostTestDef,
+		"boostslashed": fctest.ProposerBoostSlashedTestDef,
+		"tiebreak":     fctest.TieBreakTestDef,
+		"equivocation": fctest.EquivocationTestDef,
+		"unrealized":   fctest.UnrealizedTestDef,
+		"optimistic":   fctest.OptimisticTestDef,
+	}
+	for name, def := range defs {
+		t.Run(name, func(t *testing.T) {
+			var sink NodeSink
+			d := def()
+			err := d.RunWithRestarts(func(init *fctest.ForkChoiceTestInit, ft *fctest.ForkChoiceTestTarget) (forkchoice.Forkchoice, error) {
+				sink = testNodeSink(ft)
+				return NewProtoForkChoice(init.Spec, init.Finalized, init.Justified, init.AnchorRoot, init.AnchorSlot, init.AnchorParent, init.Balances, init.TotalActiveBalance, sink)
+			}, func(fc forkchoice.Forkchoice) (forkchoice.Forkchoice, error) {
+				var buf bytes.Buffer
+				if err := fc.(*forkchoice.ProtoForkChoice).Serialize(&buf); err != nil {
+					return nil, err
+				}
+				return RestoreProtoForkChoice(d.Init.Spec, &buf, sink)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestProtoArrayProposerBoost(t *testing.T) {
+	runTestDef(t, fctest.ProposerBoostTestDef())
+}
+
+func TestProtoArrayProposerBoostSlashed(t *testing.T) {
+	runTestDef(t, fctest.ProposerBoostSlashedTestDef())
+}
+
+func TestProtoArrayTieBreak(t *testing.T) {
+	runTestDef(t, fctest.TieBreakTestDef())
+}
+
+func TestProtoArrayEquivocation(t *testing.T) {
+	runTestDef(t, fctest.EquivocationTestDef())
+}
+
+func TestProtoArrayUnrealized(t *testing.T) {
+	runTestDef(t, fctest.UnrealizedTestDef())
+}
+
+func TestProtoArrayOptimistic(t *testing.T) {
+	runTestDef(t, fctest.OptimisticTestDef())
+}
+
+// Before pruning, nodes that conflict with the finalized checkpoint are not viable, regardless of their weight.
+func TestProtoArrayFinalizedDescendant(t *testing.T) {
+	spec := configs.Mainnet
+	hash := func(i uint64) (out forkchoice.Root) {
+		binary.LittleEndian.PutUint64(out[:8], i)
+		return
+	}
+	pr := NewProtoArray(spec, hash(0), hash(0), 0, 0, 0, nil)
+	//     0
+	//    / \
+	//   1   2  (slot 1)
+	//   |   |
+	//   3   4  (slot 33, both justify and finalize epoch 1)
+	for _, b := range []struct {
+		parent, root uint64
+		slot         forkchoice.Slot
+		epoch        forkchoice.Epoch
+	}{{0, 1, 1, 0}, {0, 2, 1, 0}, {1, 3, 33, 1}, {2, 4, 33, 1}} {
+		if !pr.ProcessBlock(hash(b.parent), hash(b.root), b.slot, b.epoch, b.epoch, b.epoch, b.epoch, forkchoice.Hash32{}) {
+			t.Fatalf("failed to add block %d", b.root)
+		}
+	}
+	deltas := make([]forkchoice.SignedGwei, len(pr.nodes))
+	deltas[pr.indices[forkchoice.NodeRef{Root: hash(4), Slot: 33}]] = 100
+	finalized := forkchoice.Checkpoint{Root: hash(1), Epoch: 1}
+	if err := pr.ApplyScoreChanges(deltas, finalized, finalized, 1); err != nil {
+		t.Fatal(err)
+	}
+	head, err := pr.FindHead(hash(0), 0)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if head != (forkchoice.NodeRef{Root: hash(3), Slot: 33}) {
+		t.Fatalf("expected head to descend from the finalized checkpoint, got %s", head)
 	}
 }
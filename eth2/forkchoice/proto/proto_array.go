@@ -20,10 +20,19 @@ type ProtoNode struct {
 	// The forkchoice parent of a node is strictly one slot lower, it cannot be the same slot.
 	ForkchoiceParent NodeIndex
 	// Duplicated to avoid pruning of this useful info.
-	ParentRoot     Root
+	ParentRoot Root
+	// Realized justification and finalization: as seen in the state of the node.
 	JustifiedEpoch Epoch
 	FinalizedEpoch Epoch
-	Weight         SignedGwei
+	// Unrealized justification and finalization: what the state would justify and finalize
+	// if the epoch ended now, i.e. including the attestations of the current epoch.
+	// Never lower than the realized epochs.
+	UnrealizedJustifiedEpoch Epoch
+	UnrealizedFinalizedEpoch Epoch
+	// Execution block hash of the payload, zero if unknown or pre-merge.
+	ExecutionBlockHash Hash32
+	ExecutionStatus    ExecutionStatus
+	Weight             SignedGwei
 	// Relative to ForkchoiceParent relations
 	BestChild NodeIndex
 	// Relative to ForkchoiceParent relations
@@ -45,11 +54,16 @@ type NodeSink interface {
 // Gap slots just have a single node.
 // There may be multiple nodes with the same parent but different blocks (i.e. double proposals, but slashable).
 type ProtoArray struct {
+	spec           *common.Spec
 	sink           NodeSink
-	indexOffset    NodeIndex
 	justifiedEpoch Epoch
 	finalizedEpoch Epoch
-	nodes          []ProtoNode
+	finalizedRoot  Root
+	// The epoch of the current time, as of the last score update.
+	currentEpoch Epoch
+	nodes        []ProtoNode
+	// Per node, if the node descends from the finalized checkpoint. Updated with the best children and descendants.
+	finalizedDescendants []bool
 	// maintains only nodes that are actually part of the tree starting from finalized point.
 	indices map[NodeRef]NodeIndex
 	// Tracks the first slot at or after the block root that the array knows of.
@@ -60,13 +74,14 @@ type ProtoArray struct {
 
 var _ ForkchoiceGraph = (*ProtoArray)(nil)
 
-func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch, sink NodeSink) *ProtoArray {
+func NewProtoArray(spec *common.Spec, parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch, sink NodeSink) *ProtoArray {
 	blockRef := NodeRef{Root: blockRoot, Slot: blockSlot}
 	pr := ProtoArray{
+		spec:               spec,
 		sink:               sink,
-		indexOffset:        0,
 		justifiedEpoch:     justifiedEpoch,
 		finalizedEpoch:     finalizedEpoch,
+		finalizedRoot:      blockRoot,
 		nodes:              make([]ProtoNode, 0, 100),
 		indices:            make(map[NodeRef]NodeIndex, 100),
 		blockSlots:         make(map[Root]Slot, 100),
@@ -75,15 +90,17 @@ func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch E
 	pr.blockSlots[blockRoot] = blockSlot
 	pr.indices[blockRef] = 0
 	pr.nodes = append(pr.nodes, ProtoNode{
-		Ref:              blockRef,
-		TransitionParent: NONE,
-		ForkchoiceParent: NONE,
-		ParentRoot:       parent,
-		JustifiedEpoch:   justifiedEpoch,
-		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		Ref:                      blockRef,
+		TransitionParent:         NONE,
+		ForkchoiceParent:         NONE,
+		ParentRoot:               parent,
+		JustifiedEpoch:           justifiedEpoch,
+		FinalizedEpoch:           finalizedEpoch,
+		UnrealizedJustifiedEpoch: justifiedEpoch,
+		UnrealizedFinalizedEpoch: finalizedEpoch,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
 	return &pr
 }
@@ -91,14 +108,10 @@ func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch E
 var invalidIndexErr = errors.New("invalid index")
 
 func (pr *ProtoArray) getNode(index NodeIndex) (*ProtoNode, error) {
-	if index < pr.indexOffset {
+	if index >= NodeIndex(len(pr.nodes)) {
 		return nil, invalidIndexErr
 	}
-	i := index - pr.indexOffset
-	if i > NodeIndex(len(pr.nodes)) {
-		return nil, invalidIndexErr
-	}
-	return &pr.nodes[i], nil
+	return &pr.nodes[index], nil
 }
 
 func (pr *ProtoArray) Indices() map[NodeRef]NodeIndex {
@@ -114,7 +127,7 @@ func (pr *ProtoArray) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]Extend
 	}
 	chain := make([]ExtendedNodeRef, 0, len(pr.nodes))
 	index := pr.indices[head]
-	for index != NONE && index >= pr.indexOffset {
+	for index != NONE {
 		node, err := pr.getNode(index)
 		if err != nil {
 			return nil, err
@@ -202,7 +215,7 @@ func (pr *ProtoArray) CanonAtSlot(anchor Root, slot Slot, withBlock bool) (at No
 	// Walk back the canonical chain, and stop as soon as we find the node at slot of interest.
 	index := pr.indices[head]
 	var node *ProtoNode
-	for index != NONE && index >= pr.indexOffset {
+	for index != NONE {
 		node, err = pr.getNode(index)
 		if err != nil {
 			return NodeRef{}, err
@@ -296,26 +309,35 @@ var lengthMismatchErr = errors.New("length mismatch")
 // - Compare the current node with the parents best-child, updating it if the current node
 // should become the best child.
 // - If required, update the parents best-descendant with the current node or its best-descendant.
-func (pr *ProtoArray) ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch) error {
+// isInvalidSubtreeRoot checks if the node is invalid, while its forkchoice parent is not.
+// The weight of such a node is not part of the weight of its ancestors.
+func (pr *ProtoArray) isInvalidSubtreeRoot(node *ProtoNode) bool {
+	return node.ExecutionStatus == ExecutionInvalid &&
+		(node.ForkchoiceParent == NONE || pr.nodes[node.ForkchoiceParent].ExecutionStatus != ExecutionInvalid)
+}
+
+func (pr *ProtoArray) ApplyScoreChanges(deltas []SignedGwei, justified Checkpoint, finalized Checkpoint, currentEpoch Epoch) error {
 	if len(deltas) != len(pr.nodes) {
 		return lengthMismatchErr
 	}
-	if justifiedEpoch != pr.justifiedEpoch || finalizedEpoch != pr.finalizedEpoch {
-		pr.justifiedEpoch = justifiedEpoch
-		pr.finalizedEpoch = finalizedEpoch
-	}
+	pr.justifiedEpoch = justified.Epoch
+	pr.finalizedEpoch = finalized.Epoch
+	pr.finalizedRoot = finalized.Root
+	pr.currentEpoch = currentEpoch
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		delta := deltas[i]
 		node := &pr.nodes[i]
 		node.Weight += delta
-		if node.ForkchoiceParent != NONE {
-			deltas[node.ForkchoiceParent-pr.indexOffset] += delta
+		// votes for invalid nodes do not count for their valid ancestors.
+		if node.ForkchoiceParent != NONE && !pr.isInvalidSubtreeRoot(node) {
+			deltas[node.ForkchoiceParent] += delta
 		}
 	}
+	pr.updateFinalizedDescendants()
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		node := &pr.nodes[i]
 		if node.ForkchoiceParent != NONE {
-			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, pr.indexOffset+NodeIndex(i)); err != nil {
+			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, NodeIndex(i)); err != nil {
 				return err
 			}
 		}
@@ -325,10 +347,11 @@ func (pr *ProtoArray) ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoc
 }
 
 func (pr *ProtoArray) updateConnections() error {
+	pr.updateFinalizedDescendants()
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		node := &pr.nodes[i]
 		if node.ForkchoiceParent != NONE {
-			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, pr.indexOffset+NodeIndex(i)); err != nil {
+			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, NodeIndex(i)); err != nil {
 				return err
 			}
 		}
@@ -349,9 +372,23 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 		return
 	}
 	parentIndex := NONE
+	unrealizedJustifiedEpoch, unrealizedFinalizedEpoch := justifiedEpoch, finalizedEpoch
+	var executionBlockHash Hash32
+	executionStatus := ExecutionIrrelevant
 	parentSlot, ok := pr.blockSlots[parent]
 	if ok {
 		parentIndex = pr.indices[NodeRef{Root: parent, Slot: parentSlot}]
+		if parentNode, err := pr.getNode(parentIndex); err == nil {
+			// Empty slots share the execution payload of the block.
+			executionBlockHash = parentNode.ExecutionBlockHash
+			executionStatus = parentNode.ExecutionStatus
+			if parentNode.UnrealizedJustifiedEpoch > unrealizedJustifiedEpoch {
+				unrealizedJustifiedEpoch = parentNode.UnrealizedJustifiedEpoch
+			}
+			if parentNode.UnrealizedFinalizedEpoch > unrealizedFinalizedEpoch {
+				unrealizedFinalizedEpoch = parentNode.UnrealizedFinalizedEpoch
+			}
+		}
 		for i := parentSlot + 1; i < slot; i++ {
 			nodeRef := NodeRef{Root: parent, Slot: i}
 			// remember the last node before (up to and including same slot)
@@ -362,36 +399,44 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 				continue
 			}
 			// No node to represent space between parent slot and new slot yet, so we add it.
-			nodeIndex = pr.indexOffset + NodeIndex(len(pr.nodes))
+			nodeIndex = NodeIndex(len(pr.nodes))
 			pr.indices[nodeRef] = nodeIndex
 			pr.nodes = append(pr.nodes, ProtoNode{
-				Ref:              nodeRef,
-				TransitionParent: parentIndex,
-				ForkchoiceParent: parentIndex,
-				ParentRoot:       parent,
-				JustifiedEpoch:   justifiedEpoch,
-				FinalizedEpoch:   finalizedEpoch,
-				Weight:           0,
-				BestChild:        NONE,
-				BestDescendant:   NONE,
+				Ref:                      nodeRef,
+				TransitionParent:         parentIndex,
+				ForkchoiceParent:         parentIndex,
+				ParentRoot:               parent,
+				JustifiedEpoch:           justifiedEpoch,
+				FinalizedEpoch:           finalizedEpoch,
+				UnrealizedJustifiedEpoch: unrealizedJustifiedEpoch,
+				UnrealizedFinalizedEpoch: unrealizedFinalizedEpoch,
+				ExecutionBlockHash:       executionBlockHash,
+				ExecutionStatus:          executionStatus,
+				Weight:                   0,
+				BestChild:                NONE,
+				BestDescendant:           NONE,
 			})
 			// remember the node as parent for the next
 			parentIndex = nodeIndex
 		}
 	}
 	// Add the node for the slot
-	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
+	nodeIndex := NodeIndex(len(pr.nodes))
 	pr.indices[nodeRef] = nodeIndex
 	pr.nodes = append(pr.nodes, ProtoNode{
-		Ref:              nodeRef,
-		TransitionParent: parentIndex,
-		ForkchoiceParent: parentIndex,
-		ParentRoot:       parent,
-		JustifiedEpoch:   justifiedEpoch,
-		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		Ref:                      nodeRef,
+		TransitionParent:         parentIndex,
+		ForkchoiceParent:         parentIndex,
+		ParentRoot:               parent,
+		JustifiedEpoch:           justifiedEpoch,
+		FinalizedEpoch:           finalizedEpoch,
+		UnrealizedJustifiedEpoch: unrealizedJustifiedEpoch,
+		UnrealizedFinalizedEpoch: unrealizedFinalizedEpoch,
+		ExecutionBlockHash:       executionBlockHash,
+		ExecutionStatus:          executionStatus,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
@@ -401,7 +446,11 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 // If justified or finalized in-between, make sure to call OnSlot with accurate details first.
 //
 // The parent root of the genesis block should be zeroed.
-func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool) {
+//
+// The unrealized epochs are those of the post-block state, after running justification and finalization
+// processing early (the "pulled-up tip"). If lower than the realized epochs, the realized epochs are used.
+func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	unrealizedJustifiedEpoch Epoch, unrealizedFinalizedEpoch Epoch, executionBlockHash Hash32) (ok bool) {
 	blockRef := NodeRef{Root: blockRoot, Slot: blockSlot}
 	// If the block is already known, simply ignore it.
 	if _, ok := pr.indices[blockRef]; ok {
@@ -416,11 +465,17 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 	if !ok || parentBlockSlot >= blockSlot {
 		return false
 	}
-	pr.ProcessSlot(parent, blockSlot, justifiedEpoch, finalizedEpoch)
+	// Any missing slot nodes continue the state of the parent block, not the state of the new block.
+	parentNode, err := pr.getNode(pr.indices[NodeRef{Root: parent, Slot: parentBlockSlot}])
+	if err != nil {
+		return false
+	}
+	pr.ProcessSlot(parent, blockSlot, parentNode.JustifiedEpoch, parentNode.FinalizedEpoch)
 
 	// If the parent node is not known, we cannot add the block.
-	// Note: the forkchoice graph here only considers blocks, not any empty slots. This is legacy and might change.
-	forkchoiceParentIndex, ok := pr.indices[NodeRef{Slot: parentBlockSlot, Root: parent}]
+	// The block competes with the empty slot node of the same slot: both build on the node of the previous slot.
+	// That node is the parent block itself if there are no gap slots, and is never pruned before the block is.
+	forkchoiceParentIndex, ok := pr.indices[NodeRef{Slot: blockSlot - 1, Root: parent}]
 	if !ok {
 		return false
 	}
@@ -429,25 +484,174 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 	if !ok {
 		panic("OnSlot failed to add node for block slot (transition parent)")
 	}
-	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
+	if unrealizedJustifiedEpoch < justifiedEpoch {
+		unrealizedJustifiedEpoch = justifiedEpoch
+	}
+	if unrealizedFinalizedEpoch < finalizedEpoch {
+		unrealizedFinalizedEpoch = finalizedEpoch
+	}
+	// Descendants of invalid nodes are invalid, and blocks with a payload have a payload to verify.
+	executionStatus := ExecutionIrrelevant
+	if pr.nodes[transitionParentIndex].ExecutionStatus == ExecutionInvalid {
+		executionStatus = ExecutionInvalid
+	} else if executionBlockHash != (Hash32{}) {
+		executionStatus = ExecutionOptimistic
+	}
+	nodeIndex := NodeIndex(len(pr.nodes))
 	pr.blockSlots[blockRoot] = blockSlot
 	pr.indices[blockRef] = nodeIndex
 	pr.nodes = append(pr.nodes, ProtoNode{
-		Ref:              blockRef,
-		TransitionParent: transitionParentIndex,
-		ForkchoiceParent: forkchoiceParentIndex,
-		ParentRoot:       parent,
-		JustifiedEpoch:   justifiedEpoch,
-		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		Ref:                      blockRef,
+		TransitionParent:         transitionParentIndex,
+		ForkchoiceParent:         forkchoiceParentIndex,
+		ParentRoot:               parent,
+		JustifiedEpoch:           justifiedEpoch,
+		FinalizedEpoch:           finalizedEpoch,
+		UnrealizedJustifiedEpoch: unrealizedJustifiedEpoch,
+		UnrealizedFinalizedEpoch: unrealizedFinalizedEpoch,
+		ExecutionBlockHash:       executionBlockHash,
+		ExecutionStatus:          executionStatus,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
 	return true
 }
 
+func (pr *ProtoArray) ExecutionStatus(blockRoot Root) (status ExecutionStatus, ok bool) {
+	slot, ok := pr.blockSlots[blockRoot]
+	if !ok {
+		return ExecutionIrrelevant, false
+	}
+	node, err := pr.getNode(pr.indices[NodeRef{Root: blockRoot, Slot: slot}])
+	if err != nil {
+		return ExecutionIrrelevant, false
+	}
+	return node.ExecutionStatus, true
+}
+
+func (pr *ProtoArray) SetPayloadStatus(blockRoot Root, blockHash Hash32, status ExecutionStatus) (ok bool) {
+	slot, ok := pr.blockSlots[blockRoot]
+	if !ok {
+		return false
+	}
+	index, ok := pr.indices[NodeRef{Root: blockRoot, Slot: slot}]
+	if !ok {
+		return false
+	}
+	roots := map[Root]struct{}{blockRoot: {}}
+	for i := range pr.nodes {
+		if pr.nodes[i].Ref.Root == blockRoot {
+			pr.nodes[i].ExecutionBlockHash = blockHash
+		}
+	}
+	if status == ExecutionInvalid {
+		pr.markInvalid(roots)
+		return true
+	}
+	// An invalid payload stays invalid.
+	for i := range pr.nodes {
+		if node := &pr.nodes[i]; node.Ref.Root == blockRoot && node.ExecutionStatus != ExecutionInvalid {
+			node.ExecutionStatus = status
+		}
+	}
+	if status == ExecutionValid {
+		// ancestors of a valid payload are valid too.
+		for i := pr.nodes[index].TransitionParent; i != NONE; i = pr.nodes[i].TransitionParent {
+			roots[pr.nodes[i].Ref.Root] = struct{}{}
+		}
+		pr.markValid(roots)
+	}
+	return true
+}
+
+func (pr *ProtoArray) InvalidatePayload(blockRoot Root, latestValidHash *Hash32) (ok bool) {
+	slot, ok := pr.blockSlots[blockRoot]
+	if !ok {
+		return false
+	}
+	index, ok := pr.indices[NodeRef{Root: blockRoot, Slot: slot}]
+	if !ok {
+		return false
+	}
+	invalid := map[Root]struct{}{blockRoot: {}}
+	if latestValidHash != nil {
+		// Walk back the ancestors, until the latest valid payload.
+		ancestors := make(map[Root]struct{})
+		found := false
+		for i := pr.nodes[index].TransitionParent; i != NONE; i = pr.nodes[i].TransitionParent {
+			node := &pr.nodes[i]
+			if *latestValidHash == (Hash32{}) {
+				// Zero hash: the payload chain is invalid up to the merge.
+				if node.ExecutionStatus == ExecutionIrrelevant {
+					found = true
+					break
+				}
+			} else if node.ExecutionBlockHash == *latestValidHash {
+				found = true
+				valid := map[Root]struct{}{node.Ref.Root: {}}
+				for j := node.TransitionParent; j != NONE; j = pr.nodes[j].TransitionParent {
+					valid[pr.nodes[j].Ref.Root] = struct{}{}
+				}
+				pr.markValid(valid)
+				break
+			}
+			ancestors[node.Ref.Root] = struct{}{}
+		}
+		// If the latest valid hash is unknown, we cannot tell which ancestors are invalid.
+		if found {
+			for root := range ancestors {
+				invalid[root] = struct{}{}
+			}
+		}
+	}
+	pr.markInvalid(invalid)
+	return true
+}
+
+// markValid marks the optimistic nodes of the given block roots as valid.
+func (pr *ProtoArray) markValid(roots map[Root]struct{}) {
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		if _, ok := roots[node.Ref.Root]; ok && node.ExecutionStatus == ExecutionOptimistic {
+			node.ExecutionStatus = ExecutionValid
+		}
+	}
+}
+
+// markInvalid marks the nodes of the given block roots, and all their descendants, as invalid.
+// The weight of the new invalid subtrees is removed from their valid ancestors.
+func (pr *ProtoArray) markInvalid(roots map[Root]struct{}) {
+	// Nodes are always appended after their transition parent, a single forward pass reaches all descendants.
+	newlyInvalid := make([]bool, len(pr.nodes))
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		if node.ExecutionStatus == ExecutionInvalid {
+			continue
+		}
+		if _, ok := roots[node.Ref.Root]; ok {
+			node.ExecutionStatus = ExecutionInvalid
+		} else if p := node.TransitionParent; p != NONE && pr.nodes[p].ExecutionStatus == ExecutionInvalid {
+			node.ExecutionStatus = ExecutionInvalid
+		}
+		newlyInvalid[i] = node.ExecutionStatus == ExecutionInvalid
+	}
+	// Descendants are visited first, so the weight of an earlier invalid subtree is not removed twice.
+	for i := len(pr.nodes) - 1; i >= 0; i-- {
+		node := &pr.nodes[i]
+		if !newlyInvalid[i] || !pr.isInvalidSubtreeRoot(node) {
+			continue
+		}
+		for p := node.ForkchoiceParent; p != NONE; p = pr.nodes[p].ForkchoiceParent {
+			pr.nodes[p].Weight -= node.Weight
+		}
+	}
+	// Invalid nodes are not viable anymore, the best children need to be updated.
+	pr.updatedConnections = false
+}
+
 var UnknownAnchorErr = errors.New("anchor unknown")
 var NoViableHeadErr = errors.New("not a viable head anymore, invalid forkchoice state")
 
@@ -482,7 +686,7 @@ func (pr *ProtoArray) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error
 	if err != nil {
 		return NodeRef{}, err
 	}
-	if !pr.isNodeViableForHead(bestNode) {
+	if !pr.isNodeViableForHead(bestDescIndex, bestNode) {
 		return NodeRef{}, NoViableHeadErr
 	}
 	return bestNode.Ref, nil
@@ -550,13 +754,15 @@ func (pr *ProtoArray) inSubtree(anchorIndex NodeIndex, lookupIndex NodeIndex) (u
 		return false, true
 	}
 	// Root may still be on a different non-canonical branch out of the anchor.
-	for i := lookupNode.TransitionParent; i != NONE && i >= anchorIndex; {
-		tmp := &pr.nodes[i]
-		// early exit: as soon as we find a node that has the same relative head as the anchor,
-		// we know we are in-between the anchor and the head, thus in the subtree, thus an ancestor.
-		if tmp.BestDescendant == anchorNode.BestDescendant {
+	// Walk back the transition parents, until we find the anchor, or pass the slot of the anchor.
+	for i := lookupNode.TransitionParent; i != NONE; {
+		if i == anchorIndex {
 			return false, true
 		}
+		tmp := &pr.nodes[i]
+		if tmp.Ref.Slot < anchorNode.Ref.Slot {
+			break
+		}
 		i = tmp.TransitionParent
 	}
 	return false, false
@@ -564,15 +770,15 @@ func (pr *ProtoArray) inSubtree(anchorIndex NodeIndex, lookupIndex NodeIndex) (u
 
 var HeadUnknownErr = errors.New("array has invalid state, head has no index")
 
-type prunedNode struct {
-	canonical bool
-	node      *ProtoNode
-}
-
 // Update the tree with new finalization information (or alternatively another trusted root and slot)
 // The slot may point to a gap slot,
 // in which case the node with the anchor block of the anchor block-root is pruned,
 // and the next nodes, up to (and excl.) the anchorSlot.
+//
+// Everything that is not the anchor or a descendant of the anchor is pruned.
+// Ancestors of the anchor are passed to the sink as canonical, all other pruned nodes as non-canonical.
+// Nodes are passed to the sink in insertion order, parents before children.
+// If the sink fails, only the nodes that were sent to the sink successfully are pruned.
 func (pr *ProtoArray) OnPrune(ctx context.Context, anchorRoot Root, anchorSlot Slot) error {
 	anchorRef := NodeRef{Root: anchorRoot, Slot: anchorSlot}
 	anchorIndex, ok := pr.indices[anchorRef]
@@ -580,52 +786,81 @@ func (pr *ProtoArray) OnPrune(ctx context.Context, anchorRoot Root, anchorSlot S
 		// if the anchor is unknown, then there is nothing to prune anyway.
 		return nil
 	}
-	if anchorIndex == pr.indexOffset {
-		// nothing to do
-		return nil
-	}
-	// Get the head, it will help quickly determine if pruned nodes are canonical
-	head, err := pr.FindHead(anchorRoot, anchorSlot)
-	if err != nil {
-		return err
+	// Nodes are always appended after their transition parent,
+	// so a single forward pass finds the full subtree of the anchor.
+	keep := make([]bool, len(pr.nodes))
+	keep[anchorIndex] = true
+	for i := anchorIndex + 1; i < NodeIndex(len(pr.nodes)); i++ {
+		if p := pr.nodes[i].TransitionParent; p != NONE && keep[p] {
+			keep[i] = true
+		}
 	}
-	headIndex, ok := pr.indices[head]
-	if !ok {
-		return HeadUnknownErr
+	canonical := make([]bool, len(pr.nodes))
+	for i := pr.nodes[anchorIndex].TransitionParent; i != NONE; i = pr.nodes[i].TransitionParent {
+		canonical[i] = true
 	}
-	// Remove the `self.indices` and `self.blockSlots` key/values for all the to-be-deleted nodes.
-	j := 0
-	var pruned []prunedNode
-	for i := pr.indexOffset; i < anchorIndex; i++ {
-		node := &pr.nodes[j]
+	// Send pruned nodes to the node sink (if any). Continue until it fails.
+	// Only prune what we successfully sent to the sink.
+	var err error
+	for i := range pr.nodes {
+		if keep[i] {
+			continue
+		}
 		if pr.sink != nil {
-			canonical := node.BestDescendant == headIndex
-			pruned = append(pruned, prunedNode{canonical, node})
+			if err = pr.sink.OnPrunedNode(ctx, pr.nodes[i].Ref, canonical[i]); err != nil {
+				// keep everything that was not sent yet
+				for j := i; j < len(pr.nodes); j++ {
+					keep[j] = true
+				}
+				break
+			}
 		}
 	}
-	// Send pruned nodes to the node sink (empty if no sink). Continue until it fails.
-	// Only prune what we successfully sent to the sink.
-	prunedUpTo := 0
-	for _, p := range pruned {
-		if err = pr.sink.OnPrunedNode(ctx, p.node.Ref, p.canonical); err != nil {
-			break
+	// Compact the remaining nodes, and remap all node references.
+	remap := make([]NodeIndex, len(pr.nodes))
+	nodes := make([]ProtoNode, 0, len(pr.nodes))
+	for i := range pr.nodes {
+		if !keep[i] {
+			remap[i] = NONE
+			continue
 		}
-		prunedUpTo++
+		remap[i] = NodeIndex(len(nodes))
+		nodes = append(nodes, pr.nodes[i])
 	}
-	// adjust the slot we know for the anchor root, everything before it was pruned.
-	pr.blockSlots[anchorRoot] = anchorSlot
-	for _, p := range pruned[:prunedUpTo] {
-		delete(pr.indices, p.node.Ref)
-		// Remove the block-slots ref
-		delete(pr.blockSlots, p.node.Ref.Root)
-		// TODO: is this slicing bad for GC?
-		pr.nodes = pr.nodes[1:]
-		// update offset
-		pr.indexOffset++
+	moved := func(index NodeIndex) NodeIndex {
+		if index == NONE {
+			return NONE
+		}
+		return remap[index]
+	}
+	for i := range nodes {
+		node := &nodes[i]
+		node.TransitionParent = moved(node.TransitionParent)
+		node.ForkchoiceParent = moved(node.ForkchoiceParent)
+		node.BestChild = moved(node.BestChild)
+		node.BestDescendant = moved(node.BestDescendant)
 	}
+	pr.nodes = nodes
+	pr.rebuildIndices()
+	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
+	pr.updatedConnections = false
 	return err
 }
 
+// rebuildIndices recomputes the lookup maps from the nodes.
+func (pr *ProtoArray) rebuildIndices() {
+	pr.indices = make(map[NodeRef]NodeIndex, len(pr.nodes))
+	pr.blockSlots = make(map[Root]Slot, len(pr.nodes))
+	for i := range pr.nodes {
+		ref := pr.nodes[i].Ref
+		pr.indices[ref] = NodeIndex(i)
+		// The lowest remaining slot of a block root is what we know of it now.
+		if slot, ok := pr.blockSlots[ref.Root]; !ok || ref.Slot < slot {
+			pr.blockSlots[ref.Root] = ref.Slot
+		}
+	}
+}
+
 // Observe the parent at `parent_index` with respect to the child at `child_index` and
 // potentially modify the `parent.best_child` and `parent.best_descendant` values.
 //
@@ -644,7 +879,7 @@ func (pr *ProtoArray) maybeUpdateBestChildAndDescendant(parentIndex NodeIndex, c
 	if err != nil {
 		return err
 	}
-	childLeadsToViableHead, err := pr.nodeLeadsToViableHead(child)
+	childLeadsToViableHead, err := pr.nodeLeadsToViableHead(childIndex)
 	if err != nil {
 		return err
 	}
@@ -678,7 +913,7 @@ func (pr *ProtoArray) maybeUpdateBestChildAndDescendant(parentIndex NodeIndex, c
 			if err != nil {
 				return err
 			}
-			bestChildLeadsToViableHead, err := pr.nodeLeadsToViableHead(bestChild)
+			bestChildLeadsToViableHead, err := pr.nodeLeadsToViableHead(parent.BestChild)
 			if err != nil {
 				return err
 			}
@@ -690,8 +925,17 @@ func (pr *ProtoArray) maybeUpdateBestChildAndDescendant(parentIndex NodeIndex, c
 				// The best child leads to a viable head, but the child doesn't.
 				// *No change*
 			} else if child.Weight == bestChild.Weight {
-				// Tie-breaker of equal weights by root. (smaller hash wins)
-				if bytes.Compare(child.Ref.Root[:], bestChild.Ref.Root[:]) > 0 {
+				// Tie-breaker of equal weights by root of the block each side leads to. (larger hash wins)
+				// Empty slot nodes repeat the root of the previous block, so look past them.
+				// A side that leads to a new block wins over a side that only leads to empty slots,
+				// like the spec, where an empty slot is never a candidate for the head.
+				childRoot, bestChildRoot := pr.leadingRoot(parent.Ref.Root, child), pr.leadingRoot(parent.Ref.Root, bestChild)
+				childHasBlock, bestChildHasBlock := childRoot != parent.Ref.Root, bestChildRoot != parent.Ref.Root
+				if childHasBlock != bestChildHasBlock {
+					if childHasBlock {
+						changeToChild()
+					}
+				} else if bytes.Compare(childRoot[:], bestChildRoot[:]) > 0 {
 					changeToChild()
 				}
 				// otherwise *no change*
@@ -716,25 +960,81 @@ func (pr *ProtoArray) maybeUpdateBestChildAndDescendant(parentIndex NodeIndex, c
 	return nil
 }
 
+// The root of the first block the node leads to: the node itself if it has a new block,
+// or the first new block along the best children of the empty slot nodes.
+// Returns the parent root if the node only leads to empty slots.
+func (pr *ProtoArray) leadingRoot(parentRoot Root, node *ProtoNode) Root {
+	for node.Ref.Root == parentRoot && node.BestChild != NONE {
+		next, err := pr.getNode(node.BestChild)
+		if err != nil {
+			break
+		}
+		node = next
+	}
+	return node.Ref.Root
+}
+
 // Indicates if the node itself is viable for the head, or if it's best descendant is viable for the head.
-func (pr *ProtoArray) nodeLeadsToViableHead(node *ProtoNode) (bool, error) {
+func (pr *ProtoArray) nodeLeadsToViableHead(index NodeIndex) (bool, error) {
+	node, err := pr.getNode(index)
+	if err != nil {
+		return false, err
+	}
 	if node.BestDescendant != NONE {
 		best, err := pr.getNode(node.BestDescendant)
 		if err != nil {
 			return false, err
 		}
-		return pr.isNodeViableForHead(best), nil
+		return pr.isNodeViableForHead(node.BestDescendant, best), nil
 	} else {
-		return pr.isNodeViableForHead(node), nil
+		return pr.isNodeViableForHead(index, node), nil
+	}
+}
+
+// updateFinalizedDescendants checks for every node if the finalized root is its checkpoint block
+// at the finalized epoch (get_checkpoint_block in the spec): the ancestor at the epoch start slot,
+// or the node itself if it is older than that.
+// Parents are always stored before their children, a single pass over the nodes is enough.
+func (pr *ProtoArray) updateFinalizedDescendants() {
+	if cap(pr.finalizedDescendants) < len(pr.nodes) {
+		pr.finalizedDescendants = make([]bool, len(pr.nodes), cap(pr.nodes))
+	}
+	pr.finalizedDescendants = pr.finalizedDescendants[:len(pr.nodes)]
+	finalizedSlot, _ := pr.spec.EpochStartSlot(pr.finalizedEpoch)
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		if node.Ref.Slot > finalizedSlot && node.ForkchoiceParent != NONE {
+			pr.finalizedDescendants[i] = pr.finalizedDescendants[node.ForkchoiceParent]
+		} else {
+			pr.finalizedDescendants[i] = node.Ref.Root == pr.finalizedRoot
+		}
 	}
 }
 
 // This is the equivalent to the `filter_block_tree` function in the eth2 spec:
 //
-// https://github.com/ethereum/eth2.0-specs/blob/v0.11.1/specs/phase0/fork-choice.md#filter_block_tree
+// https://github.com/ethereum/consensus-specs/blob/v1.4.0/specs/phase0/fork-choice.md#filter_block_tree
 //
-// Any node that has a different finalized or justified epoch should not be viable for the head.
-func (pr *ProtoArray) isNodeViableForHead(node *ProtoNode) bool {
-	return (node.JustifiedEpoch == pr.justifiedEpoch || pr.justifiedEpoch == common.GENESIS_EPOCH) &&
-		(node.FinalizedEpoch == pr.finalizedEpoch || pr.finalizedEpoch == common.GENESIS_EPOCH)
+// The voting source of a node must match the justified checkpoint.
+// Nodes of previous epochs are pulled up: their unrealized justification is their voting source.
+// If the previous epoch is justified, a node is also viable if its unrealized justification
+// is not older than the justified checkpoint, and its voting source is no more than two epochs old.
+// The node must descend from the finalized checkpoint.
+// Nodes with an invalid execution payload are never viable.
+func (pr *ProtoArray) isNodeViableForHead(index NodeIndex, node *ProtoNode) bool {
+	if node.ExecutionStatus == ExecutionInvalid {
+		return false
+	}
+	votingSource := node.JustifiedEpoch
+	if pr.spec.SlotToEpoch(node.Ref.Slot) < pr.currentEpoch {
+		votingSource = node.UnrealizedJustifiedEpoch
+	}
+	correctJustified := pr.justifiedEpoch == common.GENESIS_EPOCH || votingSource == pr.justifiedEpoch
+	// is_previous_epoch_justified in the spec
+	if !correctJustified && pr.justifiedEpoch+1 == pr.currentEpoch {
+		correctJustified = node.UnrealizedJustifiedEpoch >= pr.justifiedEpoch && votingSource+2 >= pr.currentEpoch
+	}
+	correctFinalized := pr.finalizedEpoch == common.GENESIS_EPOCH ||
+		(int(index) < len(pr.finalizedDescendants) && pr.finalizedDescendants[index])
+	return correctJustified && correctFinalized
 }
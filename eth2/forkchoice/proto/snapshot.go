@@ -0,0 +1,138 @@
+package proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+var _ Snapshotter = (*ProtoArray)(nil)
+var _ Snapshotter = (*ProtoVoteStore)(nil)
+
+type protoArraySnapshot struct {
+	JustifiedEpoch     Epoch
+	FinalizedEpoch     Epoch
+	FinalizedRoot      Root
+	CurrentEpoch       Epoch
+	UpdatedConnections bool
+}
+
+// Serialize writes the nodes of the array. The lookup indices are not written, they are rebuilt from the nodes.
+func (pr *ProtoArray) Serialize(w io.Writer) error {
+	snap := protoArraySnapshot{
+		JustifiedEpoch:     pr.justifiedEpoch,
+		FinalizedEpoch:     pr.finalizedEpoch,
+		FinalizedRoot:      pr.finalizedRoot,
+		CurrentEpoch:       pr.currentEpoch,
+		UpdatedConnections: pr.updatedConnections,
+	}
+	if err := binary.Write(w, binary.LittleEndian, &snap); err != nil {
+		return err
+	}
+	return WriteList(w, pr.nodes, len(pr.nodes))
+}
+
+func (pr *ProtoArray) Deserialize(r io.Reader) error {
+	var snap protoArraySnapshot
+	if err := binary.Read(r, binary.LittleEndian, &snap); err != nil {
+		return err
+	}
+	count, err := ReadListLength(r)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("forkchoice graph snapshot has no nodes")
+	}
+	nodes := make([]ProtoNode, count)
+	if err := binary.Read(r, binary.LittleEndian, nodes); err != nil {
+		return err
+	}
+	// Node references must stay within the array, and parents come before their children.
+	for i := range nodes {
+		node := &nodes[i]
+		for _, ref := range []NodeIndex{node.TransitionParent, node.ForkchoiceParent} {
+			if ref != NONE && ref >= NodeIndex(i) {
+				return fmt.Errorf("node %d has invalid parent index %d", i, ref)
+			}
+		}
+		for _, ref := range []NodeIndex{node.BestChild, node.BestDescendant} {
+			if ref != NONE && ref >= NodeIndex(count) {
+				return fmt.Errorf("node %d has invalid descendant index %d", i, ref)
+			}
+		}
+	}
+	pr.justifiedEpoch = snap.JustifiedEpoch
+	pr.finalizedEpoch = snap.FinalizedEpoch
+	pr.finalizedRoot = snap.FinalizedRoot
+	pr.currentEpoch = snap.CurrentEpoch
+	pr.updatedConnections = snap.UpdatedConnections
+	pr.nodes = nodes
+	pr.rebuildIndices()
+	pr.updateFinalizedDescendants()
+	return nil
+}
+
+// Serialize writes the votes, and the equivocating validators.
+func (st *ProtoVoteStore) Serialize(w io.Writer) error {
+	if err := binary.Write(w, binary.LittleEndian, st.changed); err != nil {
+		return err
+	}
+	if err := WriteList(w, st.votes, len(st.votes)); err != nil {
+		return err
+	}
+	equivocating := make([]ValidatorIndex, 0, len(st.equivocating))
+	for i := range st.equivocating {
+		equivocating = append(equivocating, i)
+	}
+	sort.Slice(equivocating, func(i, j int) bool {
+		return equivocating[i] < equivocating[j]
+	})
+	if err := WriteList(w, equivocating, len(equivocating)); err != nil {
+		return err
+	}
+	return WriteList(w, st.pendingEquivocations, len(st.pendingEquivocations))
+}
+
+func (st *ProtoVoteStore) Deserialize(r io.Reader) error {
+	if err := binary.Read(r, binary.LittleEndian, &st.changed); err != nil {
+		return err
+	}
+	count, err := ReadListLength(r)
+	if err != nil {
+		return err
+	}
+	st.votes = make([]VoteTracker, count)
+	if err := binary.Read(r, binary.LittleEndian, st.votes); err != nil {
+		return err
+	}
+	count, err = ReadListLength(r)
+	if err != nil {
+		return err
+	}
+	equivocating := make([]ValidatorIndex, count)
+	if err := binary.Read(r, binary.LittleEndian, equivocating); err != nil {
+		return err
+	}
+	st.equivocating = make(map[ValidatorIndex]struct{}, count)
+	for _, i := range equivocating {
+		st.equivocating[i] = struct{}{}
+	}
+	count, err = ReadListLength(r)
+	if err != nil {
+		return err
+	}
+	st.pendingEquivocations = make([]ValidatorIndex, count)
+	return binary.Read(r, binary.LittleEndian, st.pendingEquivocations)
+}
+
+// RestoreProtoForkChoice restores a forkchoice from a snapshot written by ProtoForkChoice.Serialize,
+// as created by NewProtoForkChoice.
+func RestoreProtoForkChoice(spec *common.Spec, r io.Reader, sink NodeSink) (Forkchoice, error) {
+	return RestoreForkChoice(spec, r, &ProtoArray{spec: spec, sink: sink}, &ProtoVoteStore{spec: spec})
+}
@@ -16,16 +16,23 @@ type ProtoVoteStore struct {
 	spec    *common.Spec
 	votes   []VoteTracker
 	changed bool
+	// Validators that are proven to equivocate. Their votes are ignored.
+	equivocating map[ValidatorIndex]struct{}
+	// Equivocating validators of which the vote weight still has to be removed.
+	pendingEquivocations []ValidatorIndex
 }
 
 var _ VoteStore = (*ProtoVoteStore)(nil)
 
 func NewProtoVoteStore(spec *common.Spec) VoteStore {
-	return &ProtoVoteStore{spec: spec, changed: true}
+	return &ProtoVoteStore{spec: spec, changed: true, equivocating: make(map[ValidatorIndex]struct{})}
 }
 
 // Process an attestation. (Note that the head slot may be for a gap slot after the block root)
 func (st *ProtoVoteStore) ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot) (ok bool) {
+	if _, ok := st.equivocating[index]; ok {
+		return false
+	}
 	if index >= ValidatorIndex(len(st.votes)) {
 		if index < ValidatorIndex(cap(st.votes)) {
 			st.votes = st.votes[:index+1]
@@ -46,6 +53,17 @@ func (st *ProtoVoteStore) ProcessAttestation(index ValidatorIndex, blockRoot Roo
 	return true
 }
 
+func (st *ProtoVoteStore) ProcessEquivocation(indices []ValidatorIndex) {
+	for _, index := range indices {
+		if _, ok := st.equivocating[index]; ok {
+			continue
+		}
+		st.equivocating[index] = struct{}{}
+		st.pendingEquivocations = append(st.pendingEquivocations, index)
+		st.changed = true
+	}
+}
+
 func (st *ProtoVoteStore) HasChanges() bool {
 	return st.changed
 }
@@ -55,6 +73,20 @@ func (st *ProtoVoteStore) HasChanges() bool {
 // The votestore is updated, the next deltas will be 0 if ProcessAttestation is not changing any vote.
 func (st *ProtoVoteStore) ComputeDeltas(indices map[NodeRef]NodeIndex, oldBalances []Gwei, newBalances []Gwei) []SignedGwei {
 	deltas := make([]SignedGwei, len(indices), len(indices))
+	// Remove the weight of equivocating validators, and forget their vote, so it is never counted again.
+	for _, i := range st.pendingEquivocations {
+		if i >= ValidatorIndex(len(st.votes)) {
+			continue
+		}
+		vote := &st.votes[i]
+		if i < ValidatorIndex(len(oldBalances)) {
+			if currentIndex, ok := indices[vote.Current]; ok {
+				deltas[currentIndex] -= SignedGwei(oldBalances[i])
+			}
+		}
+		*vote = VoteTracker{}
+	}
+	st.pendingEquivocations = st.pendingEquivocations[:0]
 	for i := 0; i < len(st.votes); i++ {
 		vote := &st.votes[i]
 		// There is no need to create a score change if the validator has never voted (may not be active)
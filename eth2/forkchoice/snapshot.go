@@ -0,0 +1,180 @@
+package forkchoice
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// SnapshotVersion is the version of the snapshot format, bumped on any change to the encoding.
+const SnapshotVersion uint8 = 2
+
+var snapshotMagic = [4]byte{'z', 'r', 'f', 'c'}
+
+// MaxSnapshotItems limits the length of any list in a snapshot, to not allocate unbounded memory on bad input.
+const MaxSnapshotItems = 1 << 26
+
+var UnsupportedSnapshotErr = errors.New("forkchoice graph or vote store does not support snapshots")
+
+// Snapshotter is implemented by graphs and vote stores that can be stored in a forkchoice snapshot.
+// The encoding is little-endian binary, all lists are prefixed with a uint64 length.
+type Snapshotter interface {
+	Serialize(w io.Writer) error
+	// Deserialize restores the state, the receiver is expected to be a new empty instance.
+	Deserialize(r io.Reader) error
+}
+
+// WriteList writes the length of the list, followed by the fixed-size items of the list.
+func WriteList(w io.Writer, items interface{}, length int) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(length)); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, items)
+}
+
+// ReadListLength reads a list length, and checks it against MaxSnapshotItems.
+func ReadListLength(r io.Reader) (int, error) {
+	var length uint64
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return 0, err
+	}
+	if length > MaxSnapshotItems {
+		return 0, fmt.Errorf("snapshot list too long: %d", length)
+	}
+	return int(length), nil
+}
+
+// forkChoiceSnapshot holds all the fixed-size fields of ProtoForkChoice.
+type forkChoiceSnapshot struct {
+	Justified          Checkpoint
+	Finalized          Checkpoint
+	TotalActiveBalance Gwei
+	HasPin             bool
+	Pin                NodeRef
+	ProposerBoost      NodeRef
+	AppliedBoost       NodeRef
+	AppliedBoostScore  SignedGwei
+	BoostChanged       bool
+	CurrentSlot        Slot
+	ScoredEpoch        Epoch
+}
+
+// Serialize writes a snapshot of the forkchoice: the checkpoints, pin, balances,
+// followed by the snapshot of the graph and of the votes.
+func (fc *ProtoForkChoice) Serialize(w io.Writer) error {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	graph, ok := fc.protoArray.(Snapshotter)
+	if !ok {
+		return UnsupportedSnapshotErr
+	}
+	votes, ok := fc.voteStore.(Snapshotter)
+	if !ok {
+		return UnsupportedSnapshotErr
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic[:]); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(SnapshotVersion); err != nil {
+		return err
+	}
+	snap := forkChoiceSnapshot{
+		Justified:          fc.justified,
+		Finalized:          fc.finalized,
+		TotalActiveBalance: fc.totalActiveBalance,
+		ProposerBoost:      fc.proposerBoost,
+		AppliedBoost:       fc.appliedBoost,
+		AppliedBoostScore:  fc.appliedBoostScore,
+		BoostChanged:       fc.boostChanged,
+		CurrentSlot:        fc.currentSlot,
+		ScoredEpoch:        fc.scoredEpoch,
+	}
+	if fc.pin != nil {
+		snap.HasPin = true
+		snap.Pin = *fc.pin
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &snap); err != nil {
+		return err
+	}
+	if err := WriteList(bw, fc.balances, len(fc.balances)); err != nil {
+		return err
+	}
+	if err := graph.Serialize(bw); err != nil {
+		return fmt.Errorf("failed to serialize forkchoice graph: %v", err)
+	}
+	if err := votes.Serialize(bw); err != nil {
+		return fmt.Errorf("failed to serialize votes: %v", err)
+	}
+	return bw.Flush()
+}
+
+// RestoreForkChoice reads a snapshot written by ProtoForkChoice.Serialize.
+// The graph and votes must be new empty instances, of the same types as those of the serialized forkchoice.
+func RestoreForkChoice(spec *common.Spec, r io.Reader, graph ForkchoiceGraph, votes VoteStore) (Forkchoice, error) {
+	graphSnap, ok := graph.(Snapshotter)
+	if !ok {
+		return nil, UnsupportedSnapshotErr
+	}
+	votesSnap, ok := votes.(Snapshotter)
+	if !ok {
+		return nil, UnsupportedSnapshotErr
+	}
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != snapshotMagic {
+		return nil, errors.New("not a forkchoice snapshot")
+	}
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported forkchoice snapshot version: %d", version)
+	}
+	var snap forkChoiceSnapshot
+	if err := binary.Read(br, binary.LittleEndian, &snap); err != nil {
+		return nil, err
+	}
+	count, err := ReadListLength(br)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]Gwei, count)
+	if err := binary.Read(br, binary.LittleEndian, balances); err != nil {
+		return nil, err
+	}
+	if err := graphSnap.Deserialize(br); err != nil {
+		return nil, fmt.Errorf("failed to restore forkchoice graph: %v", err)
+	}
+	if err := votesSnap.Deserialize(br); err != nil {
+		return nil, fmt.Errorf("failed to restore votes: %v", err)
+	}
+	fc := &ProtoForkChoice{
+		protoArray:         graph,
+		voteStore:          votes,
+		balances:           balances,
+		totalActiveBalance: snap.TotalActiveBalance,
+		justified:          snap.Justified,
+		finalized:          snap.Finalized,
+		spec:               spec,
+		proposerBoost:      snap.ProposerBoost,
+		appliedBoost:       snap.AppliedBoost,
+		appliedBoostScore:  snap.AppliedBoostScore,
+		boostChanged:       snap.BoostChanged,
+		currentSlot:        snap.CurrentSlot,
+		scoredEpoch:        snap.ScoredEpoch,
+	}
+	if snap.HasPin {
+		pin := snap.Pin
+		fc.pin = &pin
+	}
+	return fc, nil
+}
@@ -28,8 +28,58 @@ type AggregatesValBackend interface {
 	MarkAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex)
 }
 
+// aggregateSignatureErrs describes the failure of each of the signature checks of an aggregate, in order.
+var aggregateSignatureErrs = [3]string{"invalid selection proof", "invalid aggregator signature", "invalid aggregate signature"}
+
 func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggregateAndProof,
 	aggVal AggregatesValBackend) ([]common.ValidatorIndex, GossipValidatorResult) {
+	committee, aggRoot, checks, res := prepareAggregateAndProof(ctx, signedAgg, aggVal)
+	if res.Result != ACCEPT {
+		return nil, res
+	}
+	for i := range checks {
+		if !checks[i].Verify() {
+			return nil, GossipValidatorResult{REJECT, errors.New(aggregateSignatureErrs[i])}
+		}
+	}
+	aggVal.MarkAggregate(aggRoot)
+	aggVal.MarkAggregator(signedAgg.Message.Aggregate.Data.Target.Epoch, signedAgg.Message.AggregatorIndex)
+	return committee, GossipValidatorResult{ACCEPT, nil}
+}
+
+// ValidateAggregateAndProofBatched is like ValidateAggregateAndProof, but queues the signature checks in the batch verifier.
+// The result is sent on the returned channel once the signatures are verified.
+func ValidateAggregateAndProofBatched(ctx context.Context, signedAgg *phase0.SignedAggregateAndProof,
+	aggVal AggregatesValBackend, bv *BatchVerifier) <-chan AttestationResult {
+	out := make(chan AttestationResult, 1)
+	committee, aggRoot, checks, res := prepareAggregateAndProof(ctx, signedAgg, aggVal)
+	if res.Result != ACCEPT {
+		out <- AttestationResult{nil, res}
+		return out
+	}
+	epoch, aggregator := signedAgg.Message.Aggregate.Data.Target.Epoch, signedAgg.Message.AggregatorIndex
+	bv.Verify(ctx, checks[:], func(err error) {
+		if errors.Is(err, InvalidSignatureErr) {
+			out <- AttestationResult{nil, GossipValidatorResult{REJECT, errors.New("invalid aggregate signatures")}}
+		} else if err != nil {
+			out <- AttestationResult{nil, GossipValidatorResult{IGNORE, fmt.Errorf("aggregate signatures not verified: %w", err)}}
+		} else if aggVal.SeenAggregator(epoch, aggregator) || aggVal.SeenAggregate(aggRoot) {
+			// another aggregate may have been accepted while this one was queued
+			out <- AttestationResult{nil, GossipValidatorResult{IGNORE, fmt.Errorf("already seen aggregate %s or aggregate by %d for epoch %d", aggRoot, aggregator, epoch)}}
+		} else {
+			aggVal.MarkAggregate(aggRoot)
+			aggVal.MarkAggregator(epoch, aggregator)
+			out <- AttestationResult{committee, GossipValidatorResult{ACCEPT, nil}}
+		}
+	})
+	return out
+}
+
+// prepareAggregateAndProof runs all checks of the aggregate, except the signature verification.
+// The selection proof, aggregator signature and aggregate signature checks are returned,
+// to verify them immediately or as part of a batch.
+func prepareAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggregateAndProof,
+	aggVal AggregatesValBackend) (committee []common.ValidatorIndex, aggRoot common.Root, checks [3]SignatureCheck, res GossipValidatorResult) {
 	spec := aggVal.Spec()
 	// [IGNORE] aggregate.data.slot is within the last ATTESTATION_PROPAGATION_SLOT_RANGE
 	// slots (with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance) --
@@ -37,33 +87,33 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 	// overflow check
 	att := &signedAgg.Message.Aggregate
 	if err := CheckSlotSpan(aggVal.SlotAfter, att.Data.Slot, ATTESTATION_PROPAGATION_SLOT_RANGE); err != nil {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("aggregate attestation not within slot range: %v", err)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("aggregate attestation not within slot range: %v", err)}
 	}
 
 	// [REJECT] The aggregate attestation's epoch matches its target --
 	// i.e. aggregate.data.target.epoch == compute_epoch_at_slot(aggregate.data.slot)
 	attEpoch := spec.SlotToEpoch(att.Data.Slot)
 	if att.Data.Target.Epoch != attEpoch {
-		return nil, GossipValidatorResult{REJECT, fmt.Errorf("attestation slot %d is epoch %d and does not match target %d", att.Data.Slot, attEpoch, att.Data.Target.Epoch)}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("attestation slot %d is epoch %d and does not match target %d", att.Data.Slot, attEpoch, att.Data.Target.Epoch)}
 	}
 
 	// [IGNORE] The aggregate is the first valid aggregate received for the aggregator with index
 	// aggregate_and_proof.aggregator_index for the epoch aggregate.data.target.epoch.
 	if epoch, index := att.Data.Target.Epoch, signedAgg.Message.AggregatorIndex; aggVal.SeenAggregator(epoch, index) {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("already seen aggregate by %d for epoch %d", index, epoch)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("already seen aggregate by %d for epoch %d", index, epoch)}
 	}
 
 	// [IGNORE] The valid aggregate attestation defined by hash_tree_root(aggregate) has not already been seen
 	// (via aggregate gossip, within a verified block, or through the creation of an equivalent aggregate locally).
-	aggRoot := att.HashTreeRoot(spec, tree.GetHashFn())
+	aggRoot = att.HashTreeRoot(spec, tree.GetHashFn())
 	if aggVal.SeenAggregate(aggRoot) {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("attestation aggregate %s has already been seen", aggRoot)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("attestation aggregate %s has already been seen", aggRoot)}
 	}
 
 	// [REJECT] The attestation has participants --
 	// i.e., len(get_attesting_indices(state, aggregate.data, aggregate.aggregation_bits)) >= 1.
 	if att.AggregationBits.OnesCount() < 1 {
-		return nil, GossipValidatorResult{REJECT, fmt.Errorf("attestation has no participants")}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("attestation has no participants")}
 	}
 
 	// [IGNORE] The block being voted for (aggregate.data.beacon_block_root) has been seen (via both gossip and non-gossip sources)
@@ -72,7 +122,7 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 
 	// [REJECT] The block being voted for (aggregate.data.beacon_block_root) passes validation.
 	if aggVal.IsBadBlock(att.Data.BeaconBlockRoot) {
-		return nil, GossipValidatorResult{REJECT, errors.New("aggregate voted for invalid block")}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, errors.New("aggregate voted for invalid block")}
 	}
 
 	ch := aggVal.Chain()
@@ -84,12 +134,12 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 	fin := ch.FinalizedCheckpoint()
 	if att.Data.BeaconBlockRoot != fin.Root {
 		if unknown, inSubtree := ch.InSubtree(fin.Root, att.Data.BeaconBlockRoot); unknown {
-			return nil, GossipValidatorResult{IGNORE, errors.New("unknown block, cannot check if in subtree")}
+			return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, errors.New("unknown block, cannot check if in subtree")}
 		} else if !inSubtree {
-			return nil, GossipValidatorResult{IGNORE, errors.New("block not in subtree of finalized root")}
+			return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, errors.New("block not in subtree of finalized root")}
 		}
 	} else if fin.Epoch > att.Data.Target.Epoch {
-		return nil, GossipValidatorResult{REJECT, errors.New("cannot vote for finalized root as target")}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, errors.New("cannot vote for finalized root as target")}
 	}
 
 	// 3 combined steps:
@@ -108,60 +158,98 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 
 	entry, err := ch.Towards(towardsCtx, att.Data.Target.Root, startSlot)
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
 	epc, err := entry.EpochsContext(ctx)
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
 	state, err := entry.State(ctx)
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
-	if valid, err := phase0.ValidateAggregateSelectionProof(spec, epc, state, att.Data.Slot, att.Data.Index, signedAgg.Message.AggregatorIndex, signedAgg.Message.SelectionProof); err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+	if valid, err := phase0.ValidateAggregateSelectionProofNoSignature(spec, epc, state, att.Data.Slot, att.Data.Index, signedAgg.Message.AggregatorIndex, signedAgg.Message.SelectionProof); err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	} else if !valid {
-		return nil, GossipValidatorResult{REJECT, errors.New("invalid aggregate")}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, errors.New("invalid aggregate")}
 	}
-
-	// [REJECT] The aggregator signature, signed_aggregate_and_proof.signature, is valid.
-	dom, err := common.GetDomain(state, common.DOMAIN_AGGREGATE_AND_PROOF, att.Data.Target.Epoch)
+	domFn := func(typ common.BLSDomainType, epoch common.Epoch) (common.BLSDomain, error) {
+		return common.GetDomain(state, typ, epoch)
+	}
+	selectionRoot, err := phase0.AggregateSelectionProofSigningRoot(spec, domFn, att.Data.Slot)
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
-	sigRoot := common.ComputeSigningRoot(signedAgg.Message.HashTreeRoot(spec, tree.GetHashFn()), dom)
 	pub, ok := epc.ValidatorPubkeyCache.Pubkey(signedAgg.Message.AggregatorIndex)
 	if !ok {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("missing pubkey: %d", signedAgg.Message.AggregatorIndex)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("missing pubkey: %d", signedAgg.Message.AggregatorIndex)}
 	}
 	blsPub, err := pub.Pubkey()
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("failed to deserialize cached pubkey: %v", err)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("failed to deserialize cached pubkey: %v", err)}
 	}
-	sig, err := signedAgg.Signature.Signature()
+	selectionSig, err := signedAgg.Message.SelectionProof.Signature()
+	if err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("failed to deserialize selection proof: %v", err)}
+	}
+	checks[0] = SignatureCheck{Pubkey: blsPub, Root: selectionRoot, Signature: selectionSig}
+
+	// [REJECT] The aggregator signature, signed_aggregate_and_proof.signature, is valid.
+	dom, err := domFn(common.DOMAIN_AGGREGATE_AND_PROOF, att.Data.Target.Epoch)
 	if err != nil {
-		return nil, GossipValidatorResult{REJECT, fmt.Errorf("failed to deserialize aggregate signature: %v", err)}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
-	if !blsu.Verify(blsPub, sigRoot[:2], sig) {
-		return nil, GossipValidatorResult{REJECT, errors.New("invalid aggregate signature")}
+	sigRoot := common.ComputeSigningRoot(signedAgg.Message.HashTreeRoot(spec, tree.GetHashFn()), dom)
+	sig, err := signedAgg.Signature.Signature()
+	if err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("failed to deserialize aggregate signature: %v", err)}
 	}
+	checks[1] = SignatureCheck{Pubkey: blsPub, Root: sigRoot, Signature: sig}
 
 	// [REJECT] The signature of aggregate is valid.
-	// Check signature and bitfields
-	committee, err := epc.GetBeaconCommittee(att.Data.Slot, att.Data.Index)
+	// Check bitfields, and aggregate the pubkeys of the participants for the signature check
+	committee, err = epc.GetBeaconCommittee(att.Data.Slot, att.Data.Index)
 	if err != nil {
-		return nil, GossipValidatorResult{IGNORE, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
 	}
-	if indexedAtt, err := att.ConvertToIndexed(spec, committee); err != nil {
+	indexedAtt, err := att.ConvertToIndexed(spec, committee)
+	if err != nil {
 		// it should always convert.
 		// Something is very wrong if not, e.g. bad bitfield length.
-		return nil, GossipValidatorResult{REJECT, err}
-	} else if err := phase0.ValidateIndexedAttestation(spec, epc, state, indexedAtt); err != nil {
-		return nil, GossipValidatorResult{REJECT, err}
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, err}
+	}
+	if err := phase0.ValidateIndexedAttestationNoSignature(spec, state, indexedAtt); err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, err}
+	}
+	pubkeys := make([]*blsu.Pubkey, 0, len(indexedAtt.AttestingIndices))
+	for _, i := range indexedAtt.AttestingIndices {
+		pub, ok := epc.ValidatorPubkeyCache.Pubkey(i)
+		if !ok {
+			return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("missing pubkey: %d", i)}
+		}
+		blsPub, err := pub.Pubkey()
+		if err != nil {
+			return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, fmt.Errorf("failed to deserialize cached pubkey: %v", err)}
+		}
+		pubkeys = append(pubkeys, blsPub)
+	}
+	aggPub, err := blsu.AggregatePubkeys(pubkeys)
+	if err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("failed to aggregate pubkeys: %v", err)}
+	}
+	attDom, err := domFn(common.DOMAIN_BEACON_ATTESTER, att.Data.Target.Epoch)
+	if err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{IGNORE, err}
+	}
+	attSig, err := att.Signature.Signature()
+	if err != nil {
+		return nil, common.Root{}, checks, GossipValidatorResult{REJECT, fmt.Errorf("failed to deserialize attestation signature: %v", err)}
+	}
+	checks[2] = SignatureCheck{
+		Pubkey:    aggPub,
+		Root:      common.ComputeSigningRoot(att.Data.HashTreeRoot(tree.GetHashFn()), attDom),
+		Signature: attSig,
 	}
 
-	aggVal.MarkAggregate(aggRoot)
-	aggVal.MarkAggregator(att.Data.Target.Epoch, signedAgg.Message.AggregatorIndex)
-
-	return committee, GossipValidatorResult{ACCEPT, nil}
+	return committee, aggRoot, checks, GossipValidatorResult{ACCEPT, nil}
 }
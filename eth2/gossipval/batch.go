@@ -0,0 +1,147 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+var InvalidSignatureErr = errors.New("invalid signature")
+
+// SignatureCheck is a signature over a signing root, to be verified against a (possibly aggregated) pubkey.
+type SignatureCheck struct {
+	Pubkey    *blsu.Pubkey
+	Root      common.Root
+	Signature *blsu.Signature
+}
+
+func (c *SignatureCheck) Verify() bool {
+	return blsu.Verify(c.Pubkey, c.Root[:], c.Signature)
+}
+
+// verifyChecks verifies all checks in one randomized batch.
+func verifyChecks(checks []SignatureCheck) bool {
+	if len(checks) == 1 {
+		return checks[0].Verify()
+	}
+	pubkeys := make([]*blsu.Pubkey, len(checks))
+	messages := make([][]byte, len(checks))
+	sigs := make([]*blsu.Signature, len(checks))
+	for i := range checks {
+		pubkeys[i] = checks[i].Pubkey
+		messages[i] = checks[i].Root[:]
+		sigs[i] = checks[i].Signature
+	}
+	valid, err := blsu.SignatureSetVerify(pubkeys, messages, sigs)
+	return err == nil && valid
+}
+
+type batchJob struct {
+	ctx    context.Context
+	checks []SignatureCheck
+	done   func(err error)
+}
+
+// BatchVerifier collects signature checks of many messages, and verifies them together in one randomized batch.
+// If the batch is invalid, the checks of each message are verified separately, to only fail the invalid messages.
+type BatchVerifier struct {
+	maxBatch int
+	maxDelay time.Duration
+	jobs     chan batchJob
+}
+
+// NewBatchVerifier creates a verifier that verifies a batch once it has maxBatch signatures,
+// or once maxDelay passed since the first signature of the batch was queued.
+// Run must be called to process the queued signatures.
+func NewBatchVerifier(maxBatch int, maxDelay time.Duration) *BatchVerifier {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	return &BatchVerifier{
+		maxBatch: maxBatch,
+		maxDelay: maxDelay,
+		jobs:     make(chan batchJob, maxBatch),
+	}
+}
+
+// Verify queues the signature checks of a single message. The done callback is called exactly once,
+// with nil if all checks are valid, InvalidSignatureErr if any is invalid,
+// or the context error if the context is done before the checks were verified.
+// Callbacks are called from the Run routine, one at a time.
+// Verify blocks while the queue is full.
+func (bv *BatchVerifier) Verify(ctx context.Context, checks []SignatureCheck, done func(err error)) {
+	if err := ctx.Err(); err != nil {
+		done(err)
+		return
+	}
+	select {
+	case bv.jobs <- batchJob{ctx: ctx, checks: checks, done: done}:
+	case <-ctx.Done():
+		done(ctx.Err())
+	}
+}
+
+// Run processes queued signature checks until the context is done.
+func (bv *BatchVerifier) Run(ctx context.Context) {
+	var batch []batchJob
+	count := 0
+	timer := time.NewTimer(bv.maxDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			for _, job := range batch {
+				job.done(ctx.Err())
+			}
+			return
+		case job := <-bv.jobs:
+			if len(batch) == 0 {
+				timer.Reset(bv.maxDelay)
+			}
+			batch = append(batch, job)
+			count += len(job.checks)
+			if count < bv.maxBatch {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		bv.verifyBatch(batch)
+		batch = batch[:0]
+		count = 0
+	}
+}
+
+func (bv *BatchVerifier) verifyBatch(batch []batchJob) {
+	var checks []SignatureCheck
+	live := batch[:0:0]
+	for _, job := range batch {
+		if err := job.ctx.Err(); err != nil {
+			job.done(err)
+			continue
+		}
+		checks = append(checks, job.checks...)
+		live = append(live, job)
+	}
+	if len(checks) == 0 || verifyChecks(checks) {
+		for _, job := range live {
+			job.done(nil)
+		}
+		return
+	}
+	// fall back to verifying each message on its own
+	for _, job := range live {
+		if len(job.checks) == 0 || verifyChecks(job.checks) {
+			job.done(nil)
+		} else {
+			job.done(InvalidSignatureErr)
+		}
+	}
+}
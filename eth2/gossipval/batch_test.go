@@ -0,0 +1,71 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func testSignatureCheck(t *testing.T, key byte, root common.Root) SignatureCheck {
+	var skBytes [32]byte
+	skBytes[31] = key
+	var sk blsu.SecretKey
+	if err := sk.Deserialize(&skBytes); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := blsu.SkToPk(&sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SignatureCheck{Pubkey: pub, Root: root, Signature: blsu.Sign(&sk, root[:])}
+}
+
+func TestBatchVerifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bv := NewBatchVerifier(4, 50*time.Millisecond)
+	go bv.Run(ctx)
+
+	invalid := testSignatureCheck(t, 3, common.Root{3})
+	invalid.Root = common.Root{4}
+	jobs := [][]SignatureCheck{
+		{testSignatureCheck(t, 1, common.Root{1})},
+		{testSignatureCheck(t, 2, common.Root{2}), testSignatureCheck(t, 2, common.Root{5})},
+		{invalid},
+		// not a full batch, verified after the delay
+		{testSignatureCheck(t, 4, common.Root{6})},
+	}
+	results := make([]chan error, len(jobs))
+	for i, checks := range jobs {
+		res := make(chan error, 1)
+		results[i] = res
+		bv.Verify(ctx, checks, func(err error) { res <- err })
+	}
+	for i, res := range results {
+		select {
+		case err := <-res:
+			if i == 2 {
+				if !errors.Is(err, InvalidSignatureErr) {
+					t.Fatalf("expected invalid signature for job %d, got %v", i, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected job %d to be valid, got %v", i, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("job %d was not verified", i)
+		}
+	}
+
+	// checks are not queued when the context is done
+	cancel()
+	res := make(chan error, 1)
+	bv.Verify(ctx, jobs[0], func(err error) { res <- err })
+	if err := <-res; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled verification, got %v", err)
+	}
+}
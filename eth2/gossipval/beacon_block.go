@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/protolambda/zrnt/eth2/beacon"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
 
@@ -84,38 +85,46 @@ func ValidateBeaconBlock(ctx context.Context, block *common.BeaconBlockEnvelope,
 	// [REJECT] The block is proposed by the expected proposer_index for the block's slot in the context of
 	// the current shuffling (defined by parent_root/slot).
 
-	targetEpoch := spec.SlotToEpoch(block.Slot)
+	proposer, res := expectedProposer(ctx, spec, ch, block.ParentRoot, parentRef, parentEpc, block.Slot)
+	if res.Result != ACCEPT {
+		return res
+	}
+	if proposer != block.ProposerIndex {
+		return GossipValidatorResult{REJECT, fmt.Errorf("expected proposer %d, but block was proposed by %d", proposer, block.ProposerIndex)}
+	}
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+// expectedProposer computes the proposer of the slot, in the context of the shuffling defined by the parent block.
+func expectedProposer(ctx context.Context, spec *common.Spec, ch beacon.Chain, parentRoot common.Root, parentRef beacon.ChainEntry,
+	parentEpc *common.EpochsContext, slot common.Slot) (common.ValidatorIndex, GossipValidatorResult) {
+	targetEpoch := spec.SlotToEpoch(slot)
 	parentEpoch := spec.SlotToEpoch(parentRef.Step().Slot())
-	var proposer common.ValidatorIndex
 	if parentEpoch == targetEpoch {
-		proposer, err = parentEpc.GetBeaconProposer(block.Slot)
+		proposer, err := parentEpc.GetBeaconProposer(slot)
 		if err != nil {
-			return GossipValidatorResult{IGNORE, fmt.Errorf("could not get proposer index for slot %d, from same epoch as parent block", block.Slot)}
+			return 0, GossipValidatorResult{IGNORE, fmt.Errorf("could not get proposer index for slot %d, from same epoch as parent block", slot)}
 		}
+		return proposer, GossipValidatorResult{ACCEPT, nil}
 	} else if parentEpoch > targetEpoch {
-		return GossipValidatorResult{REJECT, fmt.Errorf("expected parent epoch %d to not be after target %d", parentEpoch, targetEpoch)}
-	} else {
-		towardsCtx, cancel := context.WithTimeout(ctx, catchupTimeout)
-		defer cancel()
-		// the block slot was valid, so this must be valid.
-		targetSlot, _ := spec.EpochStartSlot(targetEpoch)
-		slotRef, err := ch.Towards(towardsCtx, block.ParentRoot, targetSlot)
-		if err != nil {
-			return GossipValidatorResult{IGNORE, fmt.Errorf("could not transition towards target: %v", err)}
-		}
-		slotEpc, err := slotRef.EpochsContext(ctx)
-		if err != nil {
-			return GossipValidatorResult{IGNORE, fmt.Errorf("could not fetch epochs context for slot reference: %v", err)}
-		}
-		proposer, err = slotEpc.GetBeaconProposer(block.Slot)
-		if err != nil {
-			return GossipValidatorResult{IGNORE, fmt.Errorf("could not fetch block proposer slot reference: %v", err)}
-		}
+		return 0, GossipValidatorResult{REJECT, fmt.Errorf("expected parent epoch %d to not be after target %d", parentEpoch, targetEpoch)}
 	}
-
-	if proposer != block.ProposerIndex {
-		return GossipValidatorResult{REJECT, fmt.Errorf("expected proposer %d, but block was proposed by %d", proposer, block.ProposerIndex)}
+	towardsCtx, cancel := context.WithTimeout(ctx, catchupTimeout)
+	defer cancel()
+	// the slot was valid, so this must be valid.
+	targetSlot, _ := spec.EpochStartSlot(targetEpoch)
+	slotRef, err := ch.Towards(towardsCtx, parentRoot, targetSlot)
+	if err != nil {
+		return 0, GossipValidatorResult{IGNORE, fmt.Errorf("could not transition towards target: %v", err)}
 	}
-
-	return GossipValidatorResult{ACCEPT, nil}
+	slotEpc, err := slotRef.EpochsContext(ctx)
+	if err != nil {
+		return 0, GossipValidatorResult{IGNORE, fmt.Errorf("could not fetch epochs context for slot reference: %v", err)}
+	}
+	proposer, err := slotEpc.GetBeaconProposer(slot)
+	if err != nil {
+		return 0, GossipValidatorResult{IGNORE, fmt.Errorf("could not fetch block proposer slot reference: %v", err)}
+	}
+	return proposer, GossipValidatorResult{ACCEPT, nil}
 }
@@ -0,0 +1,139 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+type BlobSidecarValBackend interface {
+	Spec
+	SlotAfter
+	Chain
+	GenesisValidatorsRoot
+	BadBlockValidator
+
+	// Checks if a sidecar was seen for the (slot, proposer, index) tuple, does not do any tracking.
+	SeenBlobSidecar(slot common.Slot, proposer common.ValidatorIndex, index uint64) bool
+
+	// When the sidecar header signature and inclusion proof are validated,
+	// the tuple can be marked as seen to avoid future duplicate sidecars from being propagated.
+	MarkBlobSidecar(slot common.Slot, proposer common.ValidatorIndex, index uint64)
+
+	// VerifyBlobKZGProof checks the KZG proof of the blob against the commitment,
+	// as verify_blob_kzg_proof in the polynomial commitments spec.
+	VerifyBlobKZGProof(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error
+}
+
+func ValidateBlobSidecar(ctx context.Context, subnet uint64, sidecar *deneb.BlobSidecar,
+	blobVal BlobSidecarValBackend) GossipValidatorResult {
+	spec := blobVal.Spec()
+	header := &sidecar.SignedBlockHeader.Message
+	index := uint64(sidecar.Index)
+
+	// [REJECT] The sidecar's index is consistent with MAX_BLOBS_PER_BLOCK -- i.e. blob_sidecar.index < MAX_BLOBS_PER_BLOCK.
+	if index >= uint64(spec.MAX_BLOBS_PER_BLOCK) {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob index %d is not below max blobs per block %d", index, spec.MAX_BLOBS_PER_BLOCK)}
+	}
+
+	// [REJECT] The sidecar is for the correct subnet -- i.e. compute_subnet_for_blob_sidecar(blob_sidecar.index) == subnet_id.
+	if expected := index % uint64(spec.BLOB_SIDECAR_SUBNET_COUNT); expected != subnet {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob %d is expected on subnet %d, not %d", index, expected, subnet)}
+	}
+
+	// [IGNORE] The sidecar is not from a future slot (with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance) --
+	// i.e. validate that block_header.slot <= current_slot
+	if maxSlot := blobVal.SlotAfter(MAXIMUM_GOSSIP_CLOCK_DISPARITY); maxSlot < header.Slot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar slot %d is later than max slot %d", header.Slot, maxSlot)}
+	}
+
+	ch := blobVal.Chain()
+	// [IGNORE] The sidecar is from a slot greater than the latest finalized slot --
+	// i.e. validate that block_header.slot > compute_start_slot_at_epoch(state.finalized_checkpoint.epoch)
+	fin := ch.FinalizedCheckpoint()
+	if finSlot, _ := spec.EpochStartSlot(fin.Epoch); header.Slot <= finSlot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar slot %d is not after finalized slot %d", header.Slot, finSlot)}
+	}
+
+	// [IGNORE] The sidecar's block's parent (defined by block_header.parent_root) has been seen
+	// (via both gossip and non-gossip sources)
+	parentRef, ok := ch.ByBlock(header.ParentRoot)
+	if !ok {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar has unavailable parent block %s", header.ParentRoot)}
+	}
+	// [REJECT] The sidecar's block's parent (defined by block_header.parent_root) passes validation.
+	if blobVal.IsBadBlock(header.ParentRoot) {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob sidecar has bad parent block %s", header.ParentRoot)}
+	}
+	// [REJECT] The sidecar is from a higher slot than the sidecar's block's parent (defined by block_header.parent_root).
+	if refSlot := parentRef.Step().Slot(); refSlot >= header.Slot {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob sidecar slot %d not after parent %d (%s)", header.Slot, refSlot, header.ParentRoot)}
+	}
+	// [REJECT] The current finalized_checkpoint is an ancestor of the sidecar's block -- i.e.
+	// get_checkpoint_block(store, block_header.parent_root, store.finalized_checkpoint.epoch) == store.finalized_checkpoint.root
+	if unknown, inSubtree := ch.InSubtree(fin.Root, header.ParentRoot); unknown {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("failed to determine if parent block %s is in subtree of finalized block %s", header.ParentRoot, fin.Root)}
+	} else if !inSubtree {
+		return GossipValidatorResult{REJECT, fmt.Errorf("parent block %s is not in subtree of finalized root %s", header.ParentRoot, fin.Root)}
+	}
+
+	// [REJECT] The sidecar's inclusion proof is valid as verified by verify_blob_sidecar_inclusion_proof(blob_sidecar).
+	if err := sidecar.VerifyInclusionProof(spec); err != nil {
+		return GossipValidatorResult{REJECT, err}
+	}
+
+	// [IGNORE] The sidecar is the first sidecar for the tuple (block_header.slot, block_header.proposer_index, blob_sidecar.index)
+	// with valid header signature, sidecar inclusion proof, and kzg proof.
+	if blobVal.SeenBlobSidecar(header.Slot, header.ProposerIndex, index) {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("already seen blob sidecar %d for slot %d proposer %d", index, header.Slot, header.ProposerIndex)}
+	}
+
+	parentEpc, err := parentRef.EpochsContext(ctx)
+	if err != nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot find context for parent block %s", header.ParentRoot)}
+	}
+	// [REJECT] The proposer signature of blob_sidecar.signed_block_header, is valid with respect to the block_header.proposer_index pubkey.
+	cachedPub, ok := parentEpc.ValidatorPubkeyCache.Pubkey(header.ProposerIndex)
+	if !ok {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot find pubkey for proposer index %d", header.ProposerIndex)}
+	}
+	pub, err := cachedPub.Pubkey()
+	if err != nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot decode pubkey of proposer %d: %v", header.ProposerIndex, err)}
+	}
+	sig, err := sidecar.SignedBlockHeader.Signature.Signature()
+	if err != nil {
+		return GossipValidatorResult{REJECT, fmt.Errorf("failed to decode block header signature: %v", err)}
+	}
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_PROPOSER, spec.ForkVersion(header.Slot), blobVal.GenesisValidatorsRoot())
+	signingRoot := common.ComputeSigningRoot(header.HashTreeRoot(tree.GetHashFn()), dom)
+	if !blsu.Verify(pub, signingRoot[:], sig) {
+		return GossipValidatorResult{REJECT, errors.New("invalid block header signature")}
+	}
+
+	// [REJECT] The sidecar's blob is valid as verified by
+	// verify_blob_kzg_proof(blob_sidecar.blob, blob_sidecar.kzg_commitment, blob_sidecar.kzg_proof).
+	if err := blobVal.VerifyBlobKZGProof(sidecar.Blob, sidecar.KZGCommitment, sidecar.KZGProof); err != nil {
+		return GossipValidatorResult{REJECT, fmt.Errorf("invalid KZG proof for blob %d: %v", index, err)}
+	}
+
+	blobVal.MarkBlobSidecar(header.Slot, header.ProposerIndex, index)
+
+	// [REJECT] The sidecar is proposed by the expected proposer_index for the block's slot in the context of
+	// the current shuffling (defined by block_header.parent_root/block_header.slot).
+	proposer, res := expectedProposer(ctx, spec, ch, header.ParentRoot, parentRef, parentEpc, header.Slot)
+	if res.Result != ACCEPT {
+		return res
+	}
+	if proposer != header.ProposerIndex {
+		return GossipValidatorResult{REJECT, fmt.Errorf("expected proposer %d, but blob sidecar was proposed by %d", proposer, header.ProposerIndex)}
+	}
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
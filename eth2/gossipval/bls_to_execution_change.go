@@ -0,0 +1,48 @@
+package gossipval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type BLSToExecutionChangeValBackend interface {
+	Spec
+	SlotAfter
+	HeadInfo
+	// Checks if a valid BLS to execution change for the given validator has been seen before.
+	SeenBLSToExecutionChange(index common.ValidatorIndex) bool
+	// Marks BLS to execution change as seen
+	MarkBLSToExecutionChange(index common.ValidatorIndex)
+}
+
+func ValidateBLSToExecutionChange(ctx context.Context, change *common.SignedBLSToExecutionChange,
+	changeVal BLSToExecutionChangeValBackend) GossipValidatorResult {
+	spec := changeVal.Spec()
+	// [IGNORE] current_epoch >= CAPELLA_FORK_EPOCH, where current_epoch is defined by the current wall-clock time.
+	if epoch := spec.SlotToEpoch(changeVal.SlotAfter(0)); epoch < spec.CAPELLA_FORK_EPOCH {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("current epoch %d is before the capella fork epoch %d", epoch, spec.CAPELLA_FORK_EPOCH)}
+	}
+
+	// [IGNORE] The signed_bls_to_execution_change is the first valid signed bls to execution change received
+	// for the validator with index signed_bls_to_execution_change.message.validator_index.
+	index := change.BLSToExecutionChange.ValidatorIndex
+	if changeVal.SeenBLSToExecutionChange(index) {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("already seen bls to execution change for validator %d", index)}
+	}
+
+	// [REJECT] All of the conditions within process_bls_to_execution_change pass validation.
+	_, epc, state, err := changeVal.HeadInfo(ctx)
+	if err != nil {
+		return GossipValidatorResult{IGNORE, err}
+	}
+	if err := capella.ValidateBLSToExecutionChange(spec, epc, state, change); err != nil {
+		return GossipValidatorResult{REJECT, err}
+	}
+
+	changeVal.MarkBLSToExecutionChange(index)
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
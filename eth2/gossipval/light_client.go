@@ -0,0 +1,136 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type LightClientFinalityUpdateValBackend interface {
+	Spec
+	SlotAfter
+
+	// The last forwarded finality update, nil if none was forwarded yet.
+	LastFinalityUpdate() *altair.LightClientFinalityUpdate
+	// Marks the update as forwarded
+	MarkFinalityUpdate(update *altair.LightClientFinalityUpdate)
+
+	// The finality update computed locally from the chain, nil if not available.
+	LocalFinalityUpdate(ctx context.Context) (*altair.LightClientFinalityUpdate, error)
+}
+
+func ValidateLightClientFinalityUpdate(ctx context.Context, update *altair.LightClientFinalityUpdate,
+	updateVal LightClientFinalityUpdateValBackend) GossipValidatorResult {
+	spec := updateVal.Spec()
+
+	// [IGNORE] The finalized_header.beacon.slot is greater than that of all previously forwarded finality_updates,
+	// or it matches the highest previously forwarded slot and also has a sync_aggregate indicating supermajority (> 2/3)
+	// sync committee participation while the previously forwarded finality_update for that slot did not indicate supermajority
+	if last := updateVal.LastFinalityUpdate(); last != nil {
+		slot, lastSlot := update.FinalizedHeader.Slot, last.FinalizedHeader.Slot
+		if slot < lastSlot {
+			return GossipValidatorResult{IGNORE, fmt.Errorf("finalized slot %d is older than previously forwarded %d", slot, lastSlot)}
+		}
+		if slot == lastSlot && (syncSupermajority(spec, &last.SyncAggregate) || !syncSupermajority(spec, &update.SyncAggregate)) {
+			return GossipValidatorResult{IGNORE, fmt.Errorf("finality update for slot %d does not improve participation of previously forwarded update", slot)}
+		}
+	}
+
+	// [IGNORE] The finality_update is received after the block at signature_slot was given enough time
+	// to propagate through the network -- i.e. validate that one-third of finality_update.signature_slot
+	// has transpired (SECONDS_PER_SLOT / INTERVALS_PER_SLOT seconds after the start of the slot,
+	// with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance)
+	if err := checkSignatureSlotThird(spec, updateVal.SlotAfter, update.SignatureSlot); err != nil {
+		return GossipValidatorResult{IGNORE, err}
+	}
+
+	// [IGNORE] The received finality_update matches the locally computed one exactly
+	local, err := updateVal.LocalFinalityUpdate(ctx)
+	if err != nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot compute local finality update: %v", err)}
+	}
+	hFn := tree.GetHashFn()
+	if local == nil || local.HashTreeRoot(spec, hFn) != update.HashTreeRoot(spec, hFn) {
+		return GossipValidatorResult{IGNORE, errors.New("finality update does not match locally computed update")}
+	}
+
+	updateVal.MarkFinalityUpdate(update)
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+type LightClientOptimisticUpdateValBackend interface {
+	Spec
+	SlotAfter
+
+	// The last forwarded optimistic update, nil if none was forwarded yet.
+	LastOptimisticUpdate() *altair.LightClientOptimisticUpdate
+	// Marks the update as forwarded
+	MarkOptimisticUpdate(update *altair.LightClientOptimisticUpdate)
+
+	// The optimistic update computed locally from the chain, nil if not available.
+	LocalOptimisticUpdate(ctx context.Context) (*altair.LightClientOptimisticUpdate, error)
+}
+
+func ValidateLightClientOptimisticUpdate(ctx context.Context, update *altair.LightClientOptimisticUpdate,
+	updateVal LightClientOptimisticUpdateValBackend) GossipValidatorResult {
+	spec := updateVal.Spec()
+
+	// [IGNORE] The attested_header.beacon.slot is greater than that of all previously forwarded optimistic_updates
+	if last := updateVal.LastOptimisticUpdate(); last != nil && update.AttestedHeader.Slot <= last.AttestedHeader.Slot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("attested slot %d is not newer than previously forwarded %d",
+			update.AttestedHeader.Slot, last.AttestedHeader.Slot)}
+	}
+
+	// [IGNORE] The optimistic_update is received after the block at signature_slot was given enough time
+	// to propagate through the network -- i.e. validate that one-third of optimistic_update.signature_slot
+	// has transpired (SECONDS_PER_SLOT / INTERVALS_PER_SLOT seconds after the start of the slot,
+	// with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance)
+	if err := checkSignatureSlotThird(spec, updateVal.SlotAfter, update.SignatureSlot); err != nil {
+		return GossipValidatorResult{IGNORE, err}
+	}
+
+	// [IGNORE] The received optimistic_update matches the locally computed one exactly
+	local, err := updateVal.LocalOptimisticUpdate(ctx)
+	if err != nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot compute local optimistic update: %v", err)}
+	}
+	hFn := tree.GetHashFn()
+	if local == nil || local.HashTreeRoot(spec, hFn) != update.HashTreeRoot(spec, hFn) {
+		return GossipValidatorResult{IGNORE, errors.New("optimistic update does not match locally computed update")}
+	}
+
+	updateVal.MarkOptimisticUpdate(update)
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+// INTERVALS_PER_SLOT is the number of intervals in a slot, the sync committee signs the head
+// at the end of the first interval of the slot.
+const INTERVALS_PER_SLOT = 3
+
+// checkSignatureSlotThird checks if one interval of the signature slot has passed, with clock disparity allowance.
+func checkSignatureSlotThird(spec *common.Spec, slotAfter func(delta time.Duration) common.Slot, signatureSlot common.Slot) error {
+	interval := time.Duration(spec.SECONDS_PER_SLOT) * time.Second / INTERVALS_PER_SLOT
+	// the slot of (current time - interval + disparity) must be at least the signature slot
+	if slot := slotAfter(MAXIMUM_GOSSIP_CLOCK_DISPARITY - interval); slot < signatureSlot {
+		return fmt.Errorf("update for signature slot %d received before one third of the slot passed (at slot %d)", signatureSlot, slot)
+	}
+	return nil
+}
+
+// syncSupermajority checks if more than 2/3 of the sync committee participated in the aggregate.
+func syncSupermajority(spec *common.Spec, agg *altair.SyncAggregate) bool {
+	count := uint64(0)
+	for _, b := range agg.SyncCommitteeBits {
+		count += uint64(bits.OnesCount8(b))
+	}
+	return count*3 > uint64(spec.SYNC_COMMITTEE_SIZE)*2
+}
@@ -0,0 +1,416 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// SeenLimits bounds the seen caches of the MemBackend, a zero limit means no limit.
+// When a cache is full, the least recently marked entry of the oldest epoch is evicted.
+// The caches that are pruned by MemBackend.Prune never evict the entries of the latest epoch and the epoch before,
+// these may be needed to ignore duplicates of current messages, and are only removed by pruning.
+type SeenLimits struct {
+	// (slot, proposer) pairs of blocks
+	Blocks int
+	// (target epoch, validator) pairs of attestations
+	Attestations int
+	// Aggregate attestation roots
+	Aggregates int
+	// (target epoch, aggregator) pairs of aggregates
+	Aggregators int
+	// (slot, proposer, index) tuples of blob sidecars
+	BlobSidecars int
+	// (validator, slot, subnet) tuples of sync committee messages
+	SyncCommMsgs int
+	// (aggregator, slot, subnet) tuples of sync committee contributions
+	Contributions int
+	// Validators with seen exits, proposer slashings, attester slashings or BLS to execution changes, per operation type.
+	Operations int
+	// Blocks marked as bad
+	BadBlocks int
+}
+
+// DefaultSeenLimits bounds the entries of older epochs, kept while finality is delayed, and the seen operations.
+// The current and previous epoch are not bounded by these, e.g. the attestations of every active validator are kept.
+var DefaultSeenLimits = SeenLimits{
+	Blocks:        1 << 10,
+	Attestations:  1 << 20,
+	Aggregates:    1 << 16,
+	Aggregators:   1 << 16,
+	BlobSidecars:  1 << 12,
+	SyncCommMsgs:  1 << 12,
+	Contributions: 1 << 10,
+	Operations:    1 << 16,
+	BadBlocks:     1 << 10,
+}
+
+type blockKey struct {
+	slot     common.Slot
+	proposer common.ValidatorIndex
+}
+
+type voteKey struct {
+	epoch     common.Epoch
+	validator common.ValidatorIndex
+}
+
+type blobKey struct {
+	slot     common.Slot
+	proposer common.ValidatorIndex
+	index    uint64
+}
+
+type syncKey struct {
+	validator common.ValidatorIndex
+	slot      common.Slot
+	subnet    uint64
+}
+
+// MemBackend implements the backends of all gossip validators, with in-memory seen caches.
+// The seen entries of blocks, attestations, aggregates, blob sidecars and sync committee messages
+// are pruned with Prune, after finalization. The seen operations per validator are only bounded by the limits,
+// since these remain invalid after they are included in the chain.
+type MemBackend struct {
+	sync.Mutex
+
+	spec  *common.Spec
+	chain beacon.Chain
+	// clock, time.Now by default
+	now func() time.Time
+
+	blocks        *seenCache
+	attestations  *seenCache
+	aggregates    *seenCache
+	aggregators   *seenCache
+	blobSidecars  *seenCache
+	syncCommMsgs  *seenCache
+	contributions *seenCache
+	exits         *seenCache
+	propSlashings *seenCache
+	attSlashings  *seenCache
+	blsChanges    *seenCache
+	badBlocks     *seenCache
+
+	kzg func(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error
+
+	lastFinality    *altair.LightClientFinalityUpdate
+	lastOptimistic  *altair.LightClientOptimisticUpdate
+	localFinality   *altair.LightClientFinalityUpdate
+	localOptimistic *altair.LightClientOptimisticUpdate
+}
+
+// NewMemBackend creates a backend for the chain, using the clock to determine the current slot.
+// If now is nil, time.Now is used.
+func NewMemBackend(spec *common.Spec, chain beacon.Chain, now func() time.Time, limits SeenLimits) *MemBackend {
+	if now == nil {
+		now = time.Now
+	}
+	return &MemBackend{
+		spec:          spec,
+		chain:         chain,
+		now:           now,
+		blocks:        newSeenCache(limits.Blocks, true),
+		attestations:  newSeenCache(limits.Attestations, true),
+		aggregates:    newSeenCache(limits.Aggregates, true),
+		aggregators:   newSeenCache(limits.Aggregators, true),
+		blobSidecars:  newSeenCache(limits.BlobSidecars, true),
+		syncCommMsgs:  newSeenCache(limits.SyncCommMsgs, true),
+		contributions: newSeenCache(limits.Contributions, true),
+		exits:         newSeenCache(limits.Operations, false),
+		propSlashings: newSeenCache(limits.Operations, false),
+		attSlashings:  newSeenCache(limits.Operations, false),
+		blsChanges:    newSeenCache(limits.Operations, false),
+		badBlocks:     newSeenCache(limits.BadBlocks, false),
+	}
+}
+
+// Prune removes the seen entries of epochs before the finalized epoch,
+// messages of these epochs are ignored by the validators regardless.
+func (b *MemBackend) Prune(finalized common.Epoch) {
+	b.Lock()
+	defer b.Unlock()
+	for _, c := range []*seenCache{b.blocks, b.attestations, b.aggregates, b.aggregators,
+		b.blobSidecars, b.syncCommMsgs, b.contributions} {
+		c.Prune(finalized)
+	}
+}
+
+func (b *MemBackend) Spec() *common.Spec {
+	return b.spec
+}
+
+func (b *MemBackend) Chain() beacon.Chain {
+	return b.chain
+}
+
+func (b *MemBackend) SlotAfter(delta time.Duration) common.Slot {
+	t := b.now().Add(delta).Unix()
+	if t < 0 {
+		return 0
+	}
+	return b.spec.TimeToSlot(common.Timestamp(t), b.chain.Genesis().Time)
+}
+
+func (b *MemBackend) currentEpoch() common.Epoch {
+	return b.spec.SlotToEpoch(b.SlotAfter(0))
+}
+
+func (b *MemBackend) GenesisValidatorsRoot() common.Root {
+	return b.chain.Genesis().ValidatorsRoot
+}
+
+func (b *MemBackend) GetDomain(typ common.BLSDomainType, epoch common.Epoch) (common.BLSDomain, error) {
+	slot, err := b.spec.EpochStartSlot(epoch)
+	if err != nil {
+		return common.BLSDomain{}, err
+	}
+	return common.ComputeDomain(typ, b.spec.ForkVersion(slot), b.GenesisValidatorsRoot()), nil
+}
+
+func (b *MemBackend) HeadInfo(ctx context.Context) (beacon.ChainEntry, *common.EpochsContext, common.BeaconState, error) {
+	return RetrieveHeadInfo(ctx, b.chain)
+}
+
+// MarkBadBlock marks the block as bad, votes for it and blocks building on it are rejected.
+func (b *MemBackend) MarkBadBlock(root common.Root) {
+	b.Lock()
+	defer b.Unlock()
+	b.badBlocks.Mark(root, 0)
+}
+
+func (b *MemBackend) IsBadBlock(root common.Root) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.badBlocks.Seen(root)
+}
+
+func (b *MemBackend) SeenBlock(slot common.Slot, proposer common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.blocks.Seen(blockKey{slot, proposer})
+}
+
+func (b *MemBackend) MarkBlock(slot common.Slot, proposer common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.blocks.Mark(blockKey{slot, proposer}, b.spec.SlotToEpoch(slot))
+}
+
+func (b *MemBackend) SeenAttestation(targetEpoch common.Epoch, voter common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.attestations.Seen(voteKey{targetEpoch, voter})
+}
+
+func (b *MemBackend) MarkAttestation(targetEpoch common.Epoch, voter common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.attestations.Mark(voteKey{targetEpoch, voter}, targetEpoch)
+}
+
+func (b *MemBackend) SeenAggregate(aggRoot common.Root) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.aggregates.Seen(aggRoot)
+}
+
+func (b *MemBackend) MarkAggregate(aggRoot common.Root) {
+	// the epoch of the aggregate is not known, it is kept for at least as long with the current epoch.
+	epoch := b.currentEpoch()
+	b.Lock()
+	defer b.Unlock()
+	b.aggregates.Mark(aggRoot, epoch)
+}
+
+func (b *MemBackend) SeenAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.aggregators.Seen(voteKey{targetEpoch, aggregator})
+}
+
+func (b *MemBackend) MarkAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.aggregators.Mark(voteKey{targetEpoch, aggregator}, targetEpoch)
+}
+
+func (b *MemBackend) SeenBlobSidecar(slot common.Slot, proposer common.ValidatorIndex, index uint64) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.blobSidecars.Seen(blobKey{slot, proposer, index})
+}
+
+func (b *MemBackend) MarkBlobSidecar(slot common.Slot, proposer common.ValidatorIndex, index uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.blobSidecars.Mark(blobKey{slot, proposer, index}, b.spec.SlotToEpoch(slot))
+}
+
+// SetKZGVerifier sets the function to verify blob KZG proofs with.
+// Without it, all blob sidecars fail validation.
+func (b *MemBackend) SetKZGVerifier(fn func(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error) {
+	b.Lock()
+	defer b.Unlock()
+	b.kzg = fn
+}
+
+func (b *MemBackend) VerifyBlobKZGProof(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error {
+	b.Lock()
+	fn := b.kzg
+	b.Unlock()
+	if fn == nil {
+		return errors.New("no KZG verifier available")
+	}
+	return fn(blob, commitment, proof)
+}
+
+func (b *MemBackend) SeenSyncCommMsg(validator common.ValidatorIndex, slot common.Slot, subnet uint64) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.syncCommMsgs.Seen(syncKey{validator, slot, subnet})
+}
+
+func (b *MemBackend) MarkSyncCommMsg(validator common.ValidatorIndex, slot common.Slot, subnet uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.syncCommMsgs.Mark(syncKey{validator, slot, subnet}, b.spec.SlotToEpoch(slot))
+}
+
+func (b *MemBackend) SeenContribution(aggregator common.ValidatorIndex, slot common.Slot, subnet uint64) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.contributions.Seen(syncKey{aggregator, slot, subnet})
+}
+
+func (b *MemBackend) MarkContribution(aggregator common.ValidatorIndex, slot common.Slot, subnet uint64) {
+	b.Lock()
+	defer b.Unlock()
+	b.contributions.Mark(syncKey{aggregator, slot, subnet}, b.spec.SlotToEpoch(slot))
+}
+
+func (b *MemBackend) SeenExit(index common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.exits.Seen(index)
+}
+
+func (b *MemBackend) MarkExit(index common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.exits.Mark(index, 0)
+}
+
+func (b *MemBackend) SeenProposerSlashing(proposer common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.propSlashings.Seen(proposer)
+}
+
+func (b *MemBackend) MarkProposerSlashing(index common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.propSlashings.Mark(index, 0)
+}
+
+func (b *MemBackend) AttesterSlashableAllSeen(indices []common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	for _, index := range indices {
+		if !b.attSlashings.Seen(index) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *MemBackend) MarkAttesterSlashings(indices []common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	for _, index := range indices {
+		b.attSlashings.Mark(index, 0)
+	}
+}
+
+func (b *MemBackend) SeenBLSToExecutionChange(index common.ValidatorIndex) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.blsChanges.Seen(index)
+}
+
+func (b *MemBackend) MarkBLSToExecutionChange(index common.ValidatorIndex) {
+	b.Lock()
+	defer b.Unlock()
+	b.blsChanges.Mark(index, 0)
+}
+
+// SetLocalFinalityUpdate sets the finality update computed from the local chain, to validate gossip against.
+func (b *MemBackend) SetLocalFinalityUpdate(update *altair.LightClientFinalityUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	b.localFinality = update
+}
+
+func (b *MemBackend) LocalFinalityUpdate(ctx context.Context) (*altair.LightClientFinalityUpdate, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.localFinality, nil
+}
+
+func (b *MemBackend) LastFinalityUpdate() *altair.LightClientFinalityUpdate {
+	b.Lock()
+	defer b.Unlock()
+	return b.lastFinality
+}
+
+func (b *MemBackend) MarkFinalityUpdate(update *altair.LightClientFinalityUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	b.lastFinality = update
+}
+
+// SetLocalOptimisticUpdate sets the optimistic update computed from the local chain, to validate gossip against.
+func (b *MemBackend) SetLocalOptimisticUpdate(update *altair.LightClientOptimisticUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	b.localOptimistic = update
+}
+
+func (b *MemBackend) LocalOptimisticUpdate(ctx context.Context) (*altair.LightClientOptimisticUpdate, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.localOptimistic, nil
+}
+
+func (b *MemBackend) LastOptimisticUpdate() *altair.LightClientOptimisticUpdate {
+	b.Lock()
+	defer b.Unlock()
+	return b.lastOptimistic
+}
+
+func (b *MemBackend) MarkOptimisticUpdate(update *altair.LightClientOptimisticUpdate) {
+	b.Lock()
+	defer b.Unlock()
+	b.lastOptimistic = update
+}
+
+var (
+	_ BeaconBlockValBackend                 = (*MemBackend)(nil)
+	_ AttestationValBackend                 = (*MemBackend)(nil)
+	_ AggregatesValBackend                  = (*MemBackend)(nil)
+	_ AttesterSlashingValBackend            = (*MemBackend)(nil)
+	_ ProposerSlashingValBackend            = (*MemBackend)(nil)
+	_ VoluntaryExitValBackend               = (*MemBackend)(nil)
+	_ BLSToExecutionChangeValBackend        = (*MemBackend)(nil)
+	_ BlobSidecarValBackend                 = (*MemBackend)(nil)
+	_ SyncCommitteeSubnetValBackend         = (*MemBackend)(nil)
+	_ SyncContribAndProofValBackend         = (*MemBackend)(nil)
+	_ LightClientFinalityUpdateValBackend   = (*MemBackend)(nil)
+	_ LightClientOptimisticUpdateValBackend = (*MemBackend)(nil)
+)
@@ -0,0 +1,84 @@
+package gossipval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testChain struct {
+	beacon.Chain
+	genesis beacon.GenesisInfo
+}
+
+func (c *testChain) Genesis() beacon.GenesisInfo {
+	return c.genesis
+}
+
+func TestMemBackend(t *testing.T) {
+	spec := configs.Minimal
+	genesis := beacon.GenesisInfo{Time: 1000, ValidatorsRoot: common.Root{1}}
+	now := time.Unix(1000+int64(spec.SECONDS_PER_SLOT)*20, 0)
+	b := NewMemBackend(spec, &testChain{genesis: genesis}, func() time.Time { return now }, SeenLimits{Attestations: 3})
+
+	if slot := b.SlotAfter(0); slot != 20 {
+		t.Fatalf("expected slot 20, got %d", slot)
+	}
+	if slot := b.SlotAfter(-time.Hour); slot != 0 {
+		t.Fatalf("expected slot to clip on genesis, got %d", slot)
+	}
+	if b.GenesisValidatorsRoot() != genesis.ValidatorsRoot {
+		t.Fatal("unexpected genesis validators root")
+	}
+
+	// the attestation of the oldest epoch is evicted
+	for i := common.ValidatorIndex(0); i < 4; i++ {
+		b.MarkAttestation(common.Epoch(i), i)
+	}
+	if b.SeenAttestation(0, 0) || !b.SeenAttestation(1, 1) || !b.SeenAttestation(3, 3) {
+		t.Fatal("expected oldest attestation to be evicted")
+	}
+	// attestations of the latest epoch and the epoch before are kept beyond the limit
+	for i := common.ValidatorIndex(10); i < 20; i++ {
+		b.MarkAttestation(4, i)
+	}
+	if b.SeenAttestation(1, 1) || b.SeenAttestation(2, 2) || !b.SeenAttestation(3, 3) {
+		t.Fatal("expected only attestations before the previous epoch to be evicted")
+	}
+	for i := common.ValidatorIndex(10); i < 20; i++ {
+		if !b.SeenAttestation(4, i) {
+			t.Fatalf("expected attestation of validator %d in the latest epoch to be kept", i)
+		}
+	}
+	b.MarkAttestation(1, 1)
+	b.MarkAttestation(4, 4)
+	if b.SeenAttestation(1, 1) || b.attestations.Len() != 12 {
+		t.Fatal("expected attestation of old epoch to be evicted right away")
+	}
+
+	// pruning removes entries before the finalized epoch, but not operations per validator
+	b.MarkBlock(2*spec.SLOTS_PER_EPOCH, 5)
+	b.MarkBlock(4*spec.SLOTS_PER_EPOCH, 6)
+	b.MarkExit(7)
+	b.Prune(4)
+	if b.SeenBlock(2*spec.SLOTS_PER_EPOCH, 5) || !b.SeenBlock(4*spec.SLOTS_PER_EPOCH, 6) {
+		t.Fatal("expected block before finalized epoch to be pruned")
+	}
+	if b.SeenAttestation(1, 1) || b.SeenAttestation(3, 3) || !b.SeenAttestation(4, 4) {
+		t.Fatal("expected attestations before finalized epoch to be pruned")
+	}
+	if !b.SeenExit(7) {
+		t.Fatal("expected exit to be kept")
+	}
+
+	b.MarkAttesterSlashings([]common.ValidatorIndex{1, 2})
+	if !b.AttesterSlashableAllSeen([]common.ValidatorIndex{2, 1}) || b.AttesterSlashableAllSeen([]common.ValidatorIndex{1, 3}) {
+		t.Fatal("unexpected attester slashing seen state")
+	}
+	if err := b.VerifyBlobKZGProof(nil, common.KZGCommitment{}, common.KZGProof{}); err == nil {
+		t.Fatal("expected error without KZG verifier")
+	}
+}
@@ -0,0 +1,103 @@
+package gossipval
+
+import (
+	"container/list"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type seenEntry struct {
+	key   interface{}
+	epoch common.Epoch
+}
+
+// seenCache is a bounded set of keys, each with the epoch it is relevant to.
+// When full, the least recently marked key of the oldest epoch is evicted.
+// If keepRecent is set, the keys of the latest marked epoch and the epoch before are never evicted,
+// these are only removed by Prune: the cache may grow beyond the limit with these.
+type seenCache struct {
+	limit      int
+	keepRecent bool
+	// the latest epoch that a key was marked with
+	latest common.Epoch
+	items  map[interface{}]*list.Element
+	// per epoch, most recently marked at the front
+	epochs map[common.Epoch]*list.List
+}
+
+func newSeenCache(limit int, keepRecent bool) *seenCache {
+	return &seenCache{
+		limit:      limit,
+		keepRecent: keepRecent,
+		items:      make(map[interface{}]*list.Element),
+		epochs:     make(map[common.Epoch]*list.List),
+	}
+}
+
+func (c *seenCache) Seen(key interface{}) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *seenCache) Mark(key interface{}, epoch common.Epoch) {
+	if epoch > c.latest {
+		c.latest = epoch
+	}
+	if el, ok := c.items[key]; ok {
+		if e := el.Value.(*seenEntry); e.epoch == epoch {
+			c.epochs[epoch].MoveToFront(el)
+			return
+		}
+		c.remove(el)
+	}
+	l, ok := c.epochs[epoch]
+	if !ok {
+		l = list.New()
+		c.epochs[epoch] = l
+	}
+	c.items[key] = l.PushFront(&seenEntry{key: key, epoch: epoch})
+	for c.limit > 0 && len(c.items) > c.limit {
+		oldest, ok := c.oldestEpoch()
+		if !ok || (c.keepRecent && oldest+1 >= c.latest) {
+			break
+		}
+		c.remove(c.epochs[oldest].Back())
+	}
+}
+
+// oldestEpoch returns the oldest epoch with keys, there are only a few epochs between Prune calls.
+func (c *seenCache) oldestEpoch() (oldest common.Epoch, ok bool) {
+	for epoch := range c.epochs {
+		if !ok || epoch < oldest {
+			oldest, ok = epoch, true
+		}
+	}
+	return
+}
+
+func (c *seenCache) remove(el *list.Element) {
+	e := el.Value.(*seenEntry)
+	l := c.epochs[e.epoch]
+	l.Remove(el)
+	if l.Len() == 0 {
+		delete(c.epochs, e.epoch)
+	}
+	delete(c.items, e.key)
+}
+
+// Prune removes all keys of epochs before the given epoch.
+func (c *seenCache) Prune(epoch common.Epoch) {
+	for e, l := range c.epochs {
+		if e >= epoch {
+			continue
+		}
+		for el := l.Front(); el != nil; el = el.Next() {
+			delete(c.items, el.Value.(*seenEntry).key)
+		}
+		delete(c.epochs, e)
+	}
+}
+
+func (c *seenCache) Len() int {
+	return len(c.items)
+}
@@ -0,0 +1,268 @@
+package gossipval
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// TopicValBackend is the backend for the validation of all gossip topics.
+type TopicValBackend interface {
+	BeaconBlockValBackend
+	AttestationValBackend
+	AggregatesValBackend
+	AttesterSlashingValBackend
+	ProposerSlashingValBackend
+	VoluntaryExitValBackend
+	BLSToExecutionChangeValBackend
+	BlobSidecarValBackend
+	SyncCommitteeSubnetValBackend
+	SyncContribAndProofValBackend
+	LightClientFinalityUpdateValBackend
+	LightClientOptimisticUpdateValBackend
+}
+
+var _ TopicValBackend = (*MemBackend)(nil)
+
+type topicHandler struct {
+	// number of subnets, 0 if not a subnet topic
+	subnets  uint64
+	alloc    func() interface{}
+	validate func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult
+}
+
+// TopicRegistry maps the gossip topics of each fork to the message type and validator of the topic.
+type TopicRegistry struct {
+	spec   *common.Spec
+	forks  *beacon.ForkDecoder
+	topics map[common.ForkDigest]map[string]*topicHandler
+}
+
+// NewTopicRegistry registers the topics of all forks known to the fork decoder,
+// validating messages with the given backend.
+func NewTopicRegistry(forks *beacon.ForkDecoder, val TopicValBackend) *TopicRegistry {
+	spec := forks.Spec
+	r := &TopicRegistry{
+		spec:   spec,
+		forks:  forks,
+		topics: make(map[common.ForkDigest]map[string]*topicHandler),
+	}
+
+	phase0Topics := func(digest common.ForkDigest) map[string]*topicHandler {
+		allocBlock, _ := forks.BlockAllocator(digest)
+		return map[string]*topicHandler{
+			BeaconBlockTopic: {
+				alloc: func() interface{} { return allocBlock() },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					return ValidateBeaconBlock(ctx, msg.(beacon.OpaqueBlock).Envelope(spec, topic.Digest), val)
+				},
+			},
+			BeaconAggregateAndProofTopic: {
+				alloc: func() interface{} { return new(phase0.SignedAggregateAndProof) },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					_, res := ValidateAggregateAndProof(ctx, msg.(*phase0.SignedAggregateAndProof), val)
+					return res
+				},
+			},
+			BeaconAttestationTopic: {
+				subnets: common.ATTESTATION_SUBNET_COUNT,
+				alloc:   func() interface{} { return new(phase0.Attestation) },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					_, res := ValidateAttestation(ctx, topic.Subnet, msg.(*phase0.Attestation), val)
+					return res
+				},
+			},
+			VoluntaryExitTopic: {
+				alloc: func() interface{} { return new(phase0.SignedVoluntaryExit) },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					return ValidateVoluntaryExit(ctx, msg.(*phase0.SignedVoluntaryExit), val)
+				},
+			},
+			ProposerSlashingTopic: {
+				alloc: func() interface{} { return new(phase0.ProposerSlashing) },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					return ValidateProposerSlashing(ctx, msg.(*phase0.ProposerSlashing), val)
+				},
+			},
+			AttesterSlashingTopic: {
+				alloc: func() interface{} { return new(phase0.AttesterSlashing) },
+				validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+					return ValidateAttesterSlashing(ctx, msg.(*phase0.AttesterSlashing), val)
+				},
+			},
+		}
+	}
+	altairTopics := func(digest common.ForkDigest) map[string]*topicHandler {
+		topics := phase0Topics(digest)
+		topics[SyncCommitteeContributionAndProofTopic] = &topicHandler{
+			alloc: func() interface{} { return new(altair.SignedContributionAndProof) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				_, res := ValidateSyncContribAndProof(ctx, msg.(*altair.SignedContributionAndProof), val)
+				return res
+			},
+		}
+		topics[SyncCommitteeTopic] = &topicHandler{
+			subnets: common.SYNC_COMMITTEE_SUBNET_COUNT,
+			alloc:   func() interface{} { return new(altair.SyncCommitteeMessage) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				_, res := ValidateSyncCommitteeSubnet(ctx, topic.Subnet, msg.(*altair.SyncCommitteeMessage), val)
+				return res
+			},
+		}
+		return topics
+	}
+	// The light client types are the Altair ones, without execution header,
+	// these are only valid on the Altair and Bellatrix topics.
+	addLightClientTopics := func(topics map[string]*topicHandler) {
+		topics[LightClientFinalityUpdateTopic] = &topicHandler{
+			alloc: func() interface{} { return new(altair.LightClientFinalityUpdate) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				return ValidateLightClientFinalityUpdate(ctx, msg.(*altair.LightClientFinalityUpdate), val)
+			},
+		}
+		topics[LightClientOptimisticUpdateTopic] = &topicHandler{
+			alloc: func() interface{} { return new(altair.LightClientOptimisticUpdate) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				return ValidateLightClientOptimisticUpdate(ctx, msg.(*altair.LightClientOptimisticUpdate), val)
+			},
+		}
+	}
+	capellaTopics := func(digest common.ForkDigest) map[string]*topicHandler {
+		topics := altairTopics(digest)
+		topics[BLSToExecutionChangeTopic] = &topicHandler{
+			alloc: func() interface{} { return new(common.SignedBLSToExecutionChange) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				return ValidateBLSToExecutionChange(ctx, msg.(*common.SignedBLSToExecutionChange), val)
+			},
+		}
+		return topics
+	}
+	denebTopics := func(digest common.ForkDigest) map[string]*topicHandler {
+		topics := capellaTopics(digest)
+		topics[BlobSidecarTopic] = &topicHandler{
+			subnets: uint64(spec.BLOB_SIDECAR_SUBNET_COUNT),
+			alloc:   func() interface{} { return new(deneb.BlobSidecar) },
+			validate: func(ctx context.Context, topic Topic, msg interface{}) GossipValidatorResult {
+				return ValidateBlobSidecar(ctx, topic.Subnet, msg.(*deneb.BlobSidecar), val)
+			},
+		}
+		return topics
+	}
+
+	// later forks overwrite earlier forks, in case of a shared fork digest
+	r.topics[forks.Genesis] = phase0Topics(forks.Genesis)
+	for _, digest := range []common.ForkDigest{forks.Altair, forks.Bellatrix} {
+		topics := altairTopics(digest)
+		addLightClientTopics(topics)
+		r.topics[digest] = topics
+	}
+	r.topics[forks.Capella] = capellaTopics(forks.Capella)
+	r.topics[forks.Deneb] = denebTopics(forks.Deneb)
+	return r
+}
+
+func (r *TopicRegistry) handler(topic Topic) (*topicHandler, error) {
+	topics, ok := r.topics[topic.Digest]
+	if !ok {
+		return nil, fmt.Errorf("unknown fork digest %s", topic.Digest)
+	}
+	h, ok := topics[topic.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown topic %q for fork digest %s", topic.Name, topic.Digest)
+	}
+	if h.subnets > 0 && topic.Subnet >= h.subnets {
+		return nil, fmt.Errorf("subnet %d of topic %q is not below subnet count %d", topic.Subnet, topic.Name, h.subnets)
+	}
+	return h, nil
+}
+
+// Topics lists all topics of the fork, including every subnet of the subnet topics.
+func (r *TopicRegistry) Topics(digest common.ForkDigest) []Topic {
+	topics := r.topics[digest]
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []Topic
+	for _, name := range names {
+		if subnets := topics[name].subnets; subnets > 0 {
+			for i := uint64(0); i < subnets; i++ {
+				out = append(out, Topic{Digest: digest, Name: name, Subnet: i})
+			}
+		} else {
+			out = append(out, Topic{Digest: digest, Name: name})
+		}
+	}
+	return out
+}
+
+// Decode decompresses and deserializes the message data of the topic.
+// The message is a pointer to the message type of the topic, beacon blocks decode to a beacon.OpaqueBlock.
+func (r *TopicRegistry) Decode(topic Topic, data []byte) (interface{}, error) {
+	h, err := r.handler(topic)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := DecompressMessage(r.spec, data)
+	if err != nil {
+		return nil, err
+	}
+	obj := h.alloc()
+	dr := codec.NewDecodingReader(bytes.NewReader(dec), uint64(len(dec)))
+	var fixed uint64
+	switch x := obj.(type) {
+	case common.SpecObj:
+		fixed = x.FixedLength(r.spec)
+		err = x.Deserialize(r.spec, dr)
+	case common.SSZObj:
+		fixed = x.FixedLength()
+		err = x.Deserialize(dr)
+	default:
+		return nil, fmt.Errorf("cannot decode message type %T", obj)
+	}
+	if fixed != 0 && uint64(len(dec)) != fixed {
+		return nil, fmt.Errorf("message size %d does not match fixed size %d of topic %q", len(dec), fixed, topic.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message of topic %q: %v", topic.Name, err)
+	}
+	return obj, nil
+}
+
+// Validate decodes and validates the message data received on the topic.
+// Messages of unknown topics and undecodable messages are rejected.
+func (r *TopicRegistry) Validate(ctx context.Context, topic string, data []byte) (interface{}, GossipValidatorResult) {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return nil, GossipValidatorResult{REJECT, err}
+	}
+	h, err := r.handler(t)
+	if err != nil {
+		return nil, GossipValidatorResult{REJECT, err}
+	}
+	msg, err := r.Decode(t, data)
+	if err != nil {
+		return nil, GossipValidatorResult{REJECT, err}
+	}
+	return msg, h.validate(ctx, t, msg)
+}
+
+// MessageID computes the gossipsub message-id of the message data received on the topic.
+// The phase0 message-id is used for topics of the genesis fork digest.
+func (r *TopicRegistry) MessageID(topic string, data []byte) [20]byte {
+	withTopic := true
+	if t, err := ParseTopic(topic); err == nil && t.Digest == r.forks.Genesis {
+		withTopic = false
+	}
+	return MessageID(r.spec, topic, data, withTopic)
+}
@@ -0,0 +1,154 @@
+package gossipval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Gossip topic names. The topics of subnets are suffixed with the subnet id, e.g. beacon_attestation_{subnet_id}.
+const (
+	BeaconBlockTopic                       = "beacon_block"
+	BeaconAggregateAndProofTopic           = "beacon_aggregate_and_proof"
+	BeaconAttestationTopic                 = "beacon_attestation"
+	VoluntaryExitTopic                     = "voluntary_exit"
+	ProposerSlashingTopic                  = "proposer_slashing"
+	AttesterSlashingTopic                  = "attester_slashing"
+	SyncCommitteeContributionAndProofTopic = "sync_committee_contribution_and_proof"
+	SyncCommitteeTopic                     = "sync_committee"
+	LightClientFinalityUpdateTopic         = "light_client_finality_update"
+	LightClientOptimisticUpdateTopic       = "light_client_optimistic_update"
+	BLSToExecutionChangeTopic              = "bls_to_execution_change"
+	BlobSidecarTopic                       = "blob_sidecar"
+)
+
+// The only gossip encoding: SSZ, compressed with the snappy block format.
+const GossipEncoding = "ssz_snappy"
+
+func isSubnetTopic(name string) bool {
+	switch name {
+	case BeaconAttestationTopic, SyncCommitteeTopic, BlobSidecarTopic:
+		return true
+	default:
+		return false
+	}
+}
+
+// Topic is a parsed gossip topic: /eth2/{fork_digest}/{name}/ssz_snappy
+type Topic struct {
+	Digest common.ForkDigest
+	// Name of the topic, without subnet suffix.
+	Name string
+	// Subnet of the topic, only used if the topic is a subnet topic.
+	Subnet uint64
+}
+
+func (t Topic) String() string {
+	name := t.Name
+	if isSubnetTopic(name) {
+		name = name + "_" + strconv.FormatUint(t.Subnet, 10)
+	}
+	return "/eth2/" + hex.EncodeToString(t.Digest[:]) + "/" + name + "/" + GossipEncoding
+}
+
+// ParseTopic parses a gossip topic. The topic name is not checked against the known topics.
+func ParseTopic(topic string) (Topic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "eth2" {
+		return Topic{}, fmt.Errorf("topic %q is not an eth2 topic", topic)
+	}
+	if parts[4] != GossipEncoding {
+		return Topic{}, fmt.Errorf("topic %q has unsupported encoding %q", topic, parts[4])
+	}
+	var out Topic
+	if len(parts[2]) != 2*len(out.Digest) {
+		return Topic{}, fmt.Errorf("topic %q has invalid fork digest length", topic)
+	}
+	if _, err := hex.Decode(out.Digest[:], []byte(parts[2])); err != nil {
+		return Topic{}, fmt.Errorf("topic %q has invalid fork digest: %v", topic, err)
+	}
+	name := parts[3]
+	if i := strings.LastIndexByte(name, '_'); i >= 0 && isSubnetTopic(name[:i]) {
+		subnet, err := strconv.ParseUint(name[i+1:], 10, 64)
+		// only accept the canonical formatting, e.g. no leading zeroes
+		if err != nil || strconv.FormatUint(subnet, 10) != name[i+1:] {
+			return Topic{}, fmt.Errorf("topic %q has invalid subnet", topic)
+		}
+		out.Name, out.Subnet = name[:i], subnet
+	} else if isSubnetTopic(name) {
+		return Topic{}, fmt.Errorf("topic %q is missing a subnet", topic)
+	} else {
+		out.Name = name
+	}
+	return out, nil
+}
+
+// MaxCompressedLen is the worst-case snappy compressed length of n bytes, as max_compressed_len in the p2p spec.
+func MaxCompressedLen(n uint64) uint64 {
+	return 32 + n + n/6
+}
+
+// DecompressMessage snappy-decompresses the data of a gossip message.
+// The compressed and uncompressed sizes are limited by GOSSIP_MAX_SIZE, before decompressing anything.
+func DecompressMessage(spec *common.Spec, data []byte) ([]byte, error) {
+	maxSize := uint64(spec.GOSSIP_MAX_SIZE)
+	if uint64(len(data)) > MaxCompressedLen(maxSize) {
+		return nil, fmt.Errorf("compressed message size %d exceeds limit %d", len(data), MaxCompressedLen(maxSize))
+	}
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy message: %v", err)
+	}
+	if uint64(n) > maxSize {
+		return nil, fmt.Errorf("message size %d exceeds GOSSIP_MAX_SIZE %d", n, maxSize)
+	}
+	out, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy message: %v", err)
+	}
+	return out, nil
+}
+
+// EncodeMessage serializes and snappy-compresses the object, to publish it on a gossip topic.
+// Spec dependent objects can be wrapped with spec.Wrap.
+func EncodeMessage(spec *common.Spec, obj common.SSZObj) ([]byte, error) {
+	size := obj.ByteLength()
+	if size > uint64(spec.GOSSIP_MAX_SIZE) {
+		return nil, fmt.Errorf("message size %d exceeds GOSSIP_MAX_SIZE %d", size, spec.GOSSIP_MAX_SIZE)
+	}
+	var buf bytes.Buffer
+	if err := obj.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %v", err)
+	}
+	return snappy.Encode(nil, buf.Bytes()), nil
+}
+
+// MessageID computes the gossipsub message-id of the message data received on the topic.
+// Since Altair the topic is part of the message-id, before Altair (withTopic=false) it is not.
+func MessageID(spec *common.Spec, topic string, data []byte, withTopic bool) (out [20]byte) {
+	h := sha256.New()
+	if dec, err := DecompressMessage(spec, data); err == nil {
+		h.Write(spec.MESSAGE_DOMAIN_VALID_SNAPPY[:])
+		data = dec
+	} else {
+		h.Write(spec.MESSAGE_DOMAIN_INVALID_SNAPPY[:])
+	}
+	if withTopic {
+		var topicLen [8]byte
+		binary.LittleEndian.PutUint64(topicLen[:], uint64(len(topic)))
+		h.Write(topicLen[:])
+		h.Write([]byte(topic))
+	}
+	h.Write(data)
+	copy(out[:], h.Sum(nil))
+	return
+}
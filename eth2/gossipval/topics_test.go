@@ -0,0 +1,138 @@
+package gossipval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestParseTopic(t *testing.T) {
+	for _, topic := range []string{
+		"/eth2/b5303f2a/beacon_block/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_63/ssz_snappy",
+		"/eth2/b5303f2a/sync_committee_contribution_and_proof/ssz_snappy",
+		"/eth2/b5303f2a/sync_committee_3/ssz_snappy",
+	} {
+		parsed, err := ParseTopic(topic)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", topic, err)
+		}
+		if s := parsed.String(); s != topic {
+			t.Fatalf("expected %q, got %q", topic, s)
+		}
+	}
+	parsed, _ := ParseTopic("/eth2/b5303f2a/blob_sidecar_5/ssz_snappy")
+	if parsed != (Topic{Digest: common.ForkDigest{0xb5, 0x30, 0x3f, 0x2a}, Name: BlobSidecarTopic, Subnet: 5}) {
+		t.Fatalf("unexpected parsed topic: %+v", parsed)
+	}
+	for _, topic := range []string{
+		"/eth2/b5303f2a/beacon_block/ssz",
+		"/eth2/b5303f2a/beacon_attestation/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_01/ssz_snappy",
+		"/eth2/b5303f/beacon_block/ssz_snappy",
+		"/eth1/b5303f2a/beacon_block/ssz_snappy",
+		"eth2/b5303f2a/beacon_block/ssz_snappy",
+	} {
+		if _, err := ParseTopic(topic); err == nil {
+			t.Fatalf("expected %q to be invalid", topic)
+		}
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	spec := configs.Mainnet
+	topic := "/eth2/b5303f2a/beacon_block/ssz_snappy"
+	payload := []byte("hello")
+	data := snappy.Encode(nil, payload)
+
+	h := sha256.New()
+	h.Write(spec.MESSAGE_DOMAIN_VALID_SNAPPY[:])
+	h.Write(payload)
+	var expected [20]byte
+	copy(expected[:], h.Sum(nil))
+	if id := MessageID(spec, topic, data, false); id != expected {
+		t.Fatal("unexpected phase0 message id")
+	}
+
+	// invalid snappy data is hashed as-is, with the topic since altair
+	invalid := []byte{0xff, 0xff, 0xff}
+	h = sha256.New()
+	h.Write(spec.MESSAGE_DOMAIN_INVALID_SNAPPY[:])
+	var topicLen [8]byte
+	binary.LittleEndian.PutUint64(topicLen[:], uint64(len(topic)))
+	h.Write(topicLen[:])
+	h.Write([]byte(topic))
+	h.Write(invalid)
+	copy(expected[:], h.Sum(nil))
+	if id := MessageID(spec, topic, invalid, true); id != expected {
+		t.Fatal("unexpected altair message id of invalid snappy data")
+	}
+}
+
+func TestDecompressMessageLimit(t *testing.T) {
+	spec := *configs.Minimal
+	spec.GOSSIP_MAX_SIZE = 100
+	if _, err := DecompressMessage(&spec, snappy.Encode(nil, make([]byte, 100))); err != nil {
+		t.Fatalf("expected message at limit to decompress: %v", err)
+	}
+	if _, err := DecompressMessage(&spec, snappy.Encode(nil, make([]byte, 101))); err == nil {
+		t.Fatal("expected message over limit to be rejected")
+	}
+}
+
+func TestTopicRegistry(t *testing.T) {
+	spec := configs.Mainnet
+	forks := beacon.NewForkDecoder(spec, common.Root{1})
+	r := NewTopicRegistry(forks, NewMemBackend(spec, &testChain{}, nil, DefaultSeenLimits))
+
+	countNames := func(digest common.ForkDigest, name string) (n int) {
+		for _, topic := range r.Topics(digest) {
+			if topic.Name == name {
+				n++
+			}
+		}
+		return
+	}
+	if n := countNames(forks.Genesis, BeaconAttestationTopic); n != common.ATTESTATION_SUBNET_COUNT {
+		t.Fatalf("expected %d attestation subnets, got %d", common.ATTESTATION_SUBNET_COUNT, n)
+	}
+	if countNames(forks.Genesis, SyncCommitteeTopic) != 0 || countNames(forks.Altair, SyncCommitteeTopic) != common.SYNC_COMMITTEE_SUBNET_COUNT {
+		t.Fatal("expected sync committee topics since altair")
+	}
+	if countNames(forks.Capella, BLSToExecutionChangeTopic) != 1 || countNames(forks.Deneb, BlobSidecarTopic) != int(spec.BLOB_SIDECAR_SUBNET_COUNT) {
+		t.Fatal("expected capella and deneb topics")
+	}
+
+	exit := &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{Epoch: 3, ValidatorIndex: 42}}
+	data, err := EncodeMessage(spec, exit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := Topic{Digest: forks.Deneb, Name: VoluntaryExitTopic}
+	msg, err := r.Decode(topic, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.(*phase0.SignedVoluntaryExit); got.Message != exit.Message {
+		t.Fatalf("unexpected decoded exit: %+v", got.Message)
+	}
+	if _, err := r.Decode(topic, data[:len(data)-1]); err == nil {
+		t.Fatal("expected truncated message to fail decoding")
+	}
+	if _, err := r.Decode(Topic{Digest: forks.Deneb, Name: BeaconAttestationTopic, Subnet: 64}, data); err == nil {
+		t.Fatal("expected unknown subnet to be rejected")
+	}
+	if _, res := r.Validate(context.Background(), "/eth2/00000000/voluntary_exit/ssz_snappy", data); res.Result != REJECT ||
+		!strings.Contains(res.Err.Error(), "unknown fork digest") {
+		t.Fatalf("expected unknown fork digest to be rejected, got %v", res)
+	}
+}
@@ -1,13 +1,18 @@
 package pool
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 	"github.com/protolambda/ztyp/tree"
 )
@@ -40,6 +45,13 @@ type MinAggregates struct {
 	Extra []Aggregate
 }
 
+// mergedAggregate is the result of merging all attestations of an attestation data.
+type mergedAggregate struct {
+	agg   Aggregate
+	count int
+	err   error
+}
+
 type AttestationPool struct {
 	sync.RWMutex
 	spec *common.Spec
@@ -54,56 +66,116 @@ type AttestationPool struct {
 	// This helps filter duplicate aggregate attestations:
 	// if all aggregate participants already voted, it can be ignored (and maybe slashed if bad double votes).
 	aggPerValidator map[Assignment]common.Root
-	// Keep some extra data around, which is already covered by larger aggregates, to try and pack better results.
-	maxExtraAggregates uint64
+	// att data root -> all attestations of the data merged into one, computed once when needed.
+	// Entries are removed when attestations of the data are added or evicted.
+	// Filled while holding the read lock, so mergedLock guards it, together with counters.MergeFailures.
+	merged     map[common.Root]*mergedAggregate
+	mergedLock sync.Mutex
+	// Bounds the memory of the pool. Also keeps some extra data around,
+	// which is already covered by larger aggregates, to try and pack better results.
+	limits   AttestationPoolLimits
+	counters PoolCounters
+	// The number of attestation data in the pool per target epoch
+	epochs map[common.Epoch]uint64
+	// Optional, if set, conflicting attestations are turned into attester slashings for this pool
+	slashings *AttesterSlashingPool
 }
 
 func NewAttestationPool(spec *common.Spec) *AttestationPool {
 	return &AttestationPool{
-		spec:               spec,
-		datas:              make(map[common.Root]*IndexedAttData),
-		individual:         make(map[Assignment]*AttRef),
-		aggregate:          make(map[common.Root]*MinAggregates),
-		maxExtraAggregates: 10, // TODO: worth tuning
+		spec:            spec,
+		datas:           make(map[common.Root]*IndexedAttData),
+		individual:      make(map[Assignment]*AttRef),
+		aggregate:       make(map[common.Root]*MinAggregates),
+		aggPerValidator: make(map[Assignment]common.Root),
+		merged:          make(map[common.Root]*mergedAggregate),
+		limits:          DefaultAttestationPoolLimits,
+		epochs:          make(map[common.Epoch]uint64),
 	}
 }
 
+// FeedSlashings enables slashing detection: when an attestation conflicts with an attestation in the pool,
+// as double vote or surround vote, an attester slashing is added to the given slashings pool.
+// Only the epochs retained in the attestation pool are checked.
+// Attestations are expected to be verified before adding them to the pool, the slashings are not verified again.
+func (ap *AttestationPool) FeedSlashings(asp *AttesterSlashingPool) {
+	ap.Lock()
+	defer ap.Unlock()
+	ap.slashings = asp
+}
+
 func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attestation, committee common.CommitteeIndices) error {
+	slashings, asp, err := ap.addAttestation(att, committee)
+	// the slashings pool is only fed after the attestation pool is unlocked.
+	for _, sl := range slashings {
+		// the slashing may already be known, e.g. when the same attestation is received again.
+		_ = asp.AddAttesterSlashing(ctx, sl)
+	}
+	return err
+}
+
+// addAttestation adds the attestation, and returns the detected slashings with the pool to add them to.
+func (ap *AttestationPool) addAttestation(att *phase0.Attestation, committee common.CommitteeIndices) (
+	slashings []*phase0.AttesterSlashing, asp *AttesterSlashingPool, err error) {
 	ap.Lock()
 	defer ap.Unlock()
 
 	count := att.AggregationBits.OnesCount()
 	if count == 0 {
-		return errors.New("empty attestations are not allowed")
+		return nil, nil, errors.New("empty attestations are not allowed")
 	}
 
 	// store data and committee, so we won't have to inevitably fetch the info from a state or cache later.
 	dataRoot := att.Data.HashTreeRoot(tree.GetHashFn())
 	if _, ok := ap.datas[dataRoot]; !ok {
+		if ap.limits.MaxDataRoots != 0 && uint64(len(ap.datas)) >= ap.limits.MaxDataRoots {
+			if !ap.evictDatas(&att.Data) {
+				ap.counters.Rejected++
+				return nil, nil, PoolFullErr
+			}
+		}
 		ap.datas[dataRoot] = &IndexedAttData{
 			Data:      att.Data,
 			Committee: committee,
 		}
+		ap.epochs[att.Data.Target.Epoch]++
+		// if the attestation is not added, the new data is not referenced by anything, and removed again.
+		defer func() {
+			if err != nil {
+				ap.removeData(dataRoot)
+			}
+		}()
+	}
+
+	if ap.slashings != nil {
+		slashings, asp = ap.detectSlashings(att, dataRoot, committee), ap.slashings
 	}
 
 	// unaggregated attestation: track separately. For efficiency and easy aggregation.
 	if count == 1 {
 		val, err := att.AggregationBits.SingleParticipant(committee)
 		if err != nil { // e.g. the bitfield length doesn't match the committee.
-			return fmt.Errorf("could not get attestation participant from bitfield and committee combi: %v", err)
+			return slashings, asp, fmt.Errorf("could not get attestation participant from bitfield and committee combi: %v", err)
 		}
 		key := Assignment{Index: val, Epoch: att.Data.Target.Epoch}
 		if existing, ok := ap.individual[key]; ok {
 			if existing.DataRoot != dataRoot {
 				// double votes are slashable bad behavior. We mark it as a bad attestation.
-				return fmt.Errorf("double vote by: %d, epoch %d, data root: %s", key.Index, key.Epoch, dataRoot)
+				return slashings, asp, fmt.Errorf("double vote by: %d, epoch %d, data root: %s", key.Index, key.Epoch, dataRoot)
 			} else {
 				// already have this exact attestation in the pool
-				return nil
+				return slashings, asp, nil
+			}
+		}
+		if ap.limits.MaxIndividual != 0 && uint64(len(ap.individual)) >= ap.limits.MaxIndividual {
+			if !ap.evictIndividual(key.Epoch) {
+				ap.counters.Rejected++
+				return slashings, asp, PoolFullErr
 			}
 		}
 		ap.individual[key] = &AttRef{DataRoot: dataRoot, Sig: att.Signature}
-		return nil
+		delete(ap.merged, dataRoot)
+		return slashings, asp, nil
 	}
 
 	// aggregates: don't store more than we have to.
@@ -111,20 +183,40 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 	// No aggregation yet, we can put together the best version later.
 	if existing, ok := ap.aggregate[dataRoot]; ok {
 		if covers, err := existing.Participants.Covers(att.AggregationBits); err != nil {
-			return fmt.Errorf("could not compare aggregation bitfields: %v", err)
+			return slashings, asp, fmt.Errorf("could not compare aggregation bitfields: %v", err)
 		} else if covers {
 			// New attestation doesn't add any new info,
 			// but if it packs better than something we had before, we should keep it for better performance.
 			// To avoid spam / DoS, we only keep a limited number of these
-			if uint64(len(existing.Extra)) < ap.maxExtraAggregates {
-				existing.Extra = append(existing.Extra,
-					Aggregate{Participants: att.AggregationBits, Sig: att.Signature})
+			agg := Aggregate{Participants: att.AggregationBits, Sig: att.Signature}
+			if ap.limits.MaxExtraAggregates == 0 || uint64(len(existing.Extra)) < ap.limits.MaxExtraAggregates {
+				existing.Extra = append(existing.Extra, agg)
+			} else if replaceSmallest(existing.Extra, agg) {
+				ap.counters.Evicted++
+			} else {
+				ap.counters.Rejected++
+				return slashings, asp, nil
 			}
-			return nil
+			delete(ap.merged, dataRoot)
+			return slashings, asp, nil
 		} else {
 			// this aggregate adds additional participants compared to the total we had before, keep it!
-			existing.Aggregates = append(existing.Aggregates,
-				Aggregate{Participants: att.AggregationBits, Sig: att.Signature})
+			agg := Aggregate{Participants: att.AggregationBits, Sig: att.Signature}
+			if ap.limits.MaxAggregatesPerRoot == 0 || uint64(len(existing.Aggregates)) < ap.limits.MaxAggregatesPerRoot {
+				existing.Aggregates = append(existing.Aggregates, agg)
+				existing.Participants.Or(att.AggregationBits)
+			} else if replaceSmallest(existing.Aggregates, agg) {
+				ap.counters.Evicted++
+				// the replaced aggregate may have had participants that are not covered anymore
+				existing.Participants = att.AggregationBits.Copy()
+				for _, a := range existing.Aggregates {
+					existing.Participants.Or(a.Participants)
+				}
+			} else {
+				ap.counters.Rejected++
+				return slashings, asp, PoolFullErr
+			}
+			delete(ap.merged, dataRoot)
 
 			// remember the participants attested this epoch
 			key := Assignment{Index: 0, Epoch: att.Data.Target.Epoch}
@@ -134,7 +226,7 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 					ap.aggPerValidator[key] = dataRoot
 				}
 			}
-			return nil
+			return slashings, asp, nil
 		}
 	} else {
 		hasNewAttester := false
@@ -155,12 +247,114 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 				// copy, we mutate this bitfield later, while still using the original (stored in above array)
 				Participants: att.AggregationBits.Copy(),
 			}
+			delete(ap.merged, dataRoot)
 		} else {
-			return fmt.Errorf("ignoring new attestation for different data:" +
+			return slashings, asp, fmt.Errorf("ignoring new attestation for different data:" +
 				"all participants voted for other data this epoch already, whole attestation is likely slashable")
 		}
+		return slashings, asp, nil
+	}
+}
+
+// detectSlashings checks the votes of the participants of the attestation against the pool,
+// and returns the attester slashings for the conflicting attestations in the pool.
+// Every participant with a conflicting vote is covered by one of the slashings.
+func (ap *AttestationPool) detectSlashings(att *phase0.Attestation, dataRoot common.Root, committee common.CommitteeIndices) (out []*phase0.AttesterSlashing) {
+	var indexed *phase0.IndexedAttestation
+	type conflict struct {
+		root  common.Root
+		index common.ValidatorIndex
+	}
+	// the conflicting votes that are already covered by a slashing
+	covered := make(map[conflict]struct{})
+	key := Assignment{}
+	for i, vi := range committee {
+		if !att.AggregationBits.GetBit(uint64(i)) {
+			continue
+		}
+		key.Index = vi
+		for epoch := range ap.epochs {
+			key.Epoch = epoch
+			var votes []common.Root
+			if ref, ok := ap.individual[key]; ok {
+				votes = append(votes, ref.DataRoot)
+			}
+			if root, ok := ap.aggPerValidator[key]; ok {
+				votes = append(votes, root)
+			}
+			for _, other := range votes {
+				if other == dataRoot {
+					continue
+				}
+				if _, ok := covered[conflict{other, vi}]; ok {
+					continue
+				}
+				d, ok := ap.datas[other]
+				if !ok {
+					continue
+				}
+				// a surround vote is only slashable with the surrounding attestation first
+				existingFirst := phase0.IsSlashableAttestationData(&d.Data, &att.Data)
+				if !existingFirst && !phase0.IsSlashableAttestationData(&att.Data, &d.Data) {
+					continue
+				}
+				existing := ap.indexedAttestation(other, vi)
+				if existing == nil {
+					continue
+				}
+				if indexed == nil {
+					var err error
+					if indexed, err = att.ConvertToIndexed(ap.spec, committee); err != nil {
+						return nil
+					}
+				}
+				// the other participants of the existing attestation are slashed with it too
+				for _, index := range existing.AttestingIndices {
+					covered[conflict{other, index}] = struct{}{}
+				}
+				sl := &phase0.AttesterSlashing{Attestation1: *existing, Attestation2: *indexed}
+				if !existingFirst {
+					sl.Attestation1, sl.Attestation2 = sl.Attestation2, sl.Attestation1
+				}
+				out = append(out, sl)
+			}
+		}
+	}
+	return out
+}
+
+// indexedAttestation returns an attestation in the pool for the data root that includes the validator,
+// or nil if there is none.
+func (ap *AttestationPool) indexedAttestation(dataRoot common.Root, vi common.ValidatorIndex) *phase0.IndexedAttestation {
+	d, ok := ap.datas[dataRoot]
+	if !ok {
+		return nil
+	}
+	if ref, ok := ap.individual[Assignment{Index: vi, Epoch: d.Data.Target.Epoch}]; ok && ref.DataRoot == dataRoot {
+		return &phase0.IndexedAttestation{AttestingIndices: common.CommitteeIndices{vi}, Data: d.Data, Signature: ref.Sig}
+	}
+	agg, ok := ap.aggregate[dataRoot]
+	if !ok {
 		return nil
 	}
+	for i, v := range d.Committee {
+		if v != vi {
+			continue
+		}
+		for _, aggs := range [][]Aggregate{agg.Aggregates, agg.Extra} {
+			for _, a := range aggs {
+				if a.Participants.BitLen() != uint64(len(d.Committee)) || !a.Participants.GetBit(uint64(i)) {
+					continue
+				}
+				att := phase0.Attestation{AggregationBits: a.Participants, Data: d.Data, Signature: a.Sig}
+				if indexed, err := att.ConvertToIndexed(ap.spec, d.Committee); err == nil {
+					return indexed
+				}
+			}
+		}
+		break
+	}
+	return nil
 }
 
 type attSearch struct {
@@ -203,13 +397,130 @@ func (ap *AttestationPool) Search(opts ...AttSearchOption) (out []*phase0.Attest
 	return out
 }
 
+// AggregateAttestation returns the best aggregate attestation for the attestation data root,
+// as needed for the aggregator duty. Starting from the largest known aggregate,
+// the aggregates and individual attestations that do not overlap with it are merged in.
+func (ap *AttestationPool) AggregateAttestation(dataRoot common.Root) (*phase0.Attestation, error) {
+	ap.RLock()
+	defer ap.RUnlock()
+	d, ok := ap.datas[dataRoot]
+	if !ok {
+		return nil, fmt.Errorf("unknown attestation data root: %s", dataRoot)
+	}
+	agg, _, err := ap.mergedAggregate(dataRoot, d)
+	if err != nil {
+		return nil, err
+	}
+	return &phase0.Attestation{AggregationBits: agg.Participants, Data: d.Data, Signature: agg.Sig}, nil
+}
+
+// mergedAggregate returns the merged aggregate of the attestation data, merging the attestations only
+// if they changed since the last merge. Failed merges are counted, and not retried until the attestations change.
+// The pool must be locked, at least for reading.
+func (ap *AttestationPool) mergedAggregate(dataRoot common.Root, d *IndexedAttData) (Aggregate, int, error) {
+	ap.mergedLock.Lock()
+	defer ap.mergedLock.Unlock()
+	m, ok := ap.merged[dataRoot]
+	if !ok {
+		m = new(mergedAggregate)
+		m.agg, m.count, m.err = ap.mergeAggregates(dataRoot, d)
+		if m.err != nil {
+			ap.counters.MergeFailures++
+		}
+		ap.merged[dataRoot] = m
+	}
+	return m.agg, m.count, m.err
+}
+
+// mergeAggregates merges the aggregates and individual attestations of the attestation data into a single aggregate.
+// Aggregates are merged largest first, and skipped if they overlap with what is already merged.
+// Individual attestations then fill the gaps. The number of merged attestations is returned as well.
+func (ap *AttestationPool) mergeAggregates(dataRoot common.Root, d *IndexedAttData) (out Aggregate, count int, err error) {
+	size := uint64(len(d.Committee))
+	var parts []Aggregate
+	if agg, ok := ap.aggregate[dataRoot]; ok {
+		parts = make([]Aggregate, 0, len(agg.Aggregates)+len(agg.Extra))
+		for _, a := range agg.Aggregates {
+			if a.Participants.BitLen() == size {
+				parts = append(parts, a)
+			}
+		}
+		for _, a := range agg.Extra {
+			if a.Participants.BitLen() == size {
+				parts = append(parts, a)
+			}
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].Participants.OnesCount() > parts[j].Participants.OnesCount()
+	})
+
+	bits := newAttestationBits(size)
+	var sigs []*blsu.Signature
+	addSig := func(sig *common.BLSSignature) error {
+		s, err := sig.Signature()
+		if err != nil {
+			return fmt.Errorf("invalid signature in pool: %v", err)
+		}
+		sigs = append(sigs, s)
+		return nil
+	}
+	for _, a := range parts {
+		if overlaps(bits, a.Participants) {
+			continue
+		}
+		if err := addSig(&a.Sig); err != nil {
+			return Aggregate{}, 0, err
+		}
+		bits.Or(a.Participants)
+	}
+	for i, vi := range d.Committee {
+		if bits.GetBit(uint64(i)) {
+			continue
+		}
+		if ref, ok := ap.individual[Assignment{Index: vi, Epoch: d.Data.Target.Epoch}]; ok && ref.DataRoot == dataRoot {
+			if err := addSig(&ref.Sig); err != nil {
+				return Aggregate{}, 0, err
+			}
+			bits.SetBit(uint64(i), true)
+		}
+	}
+	if len(sigs) == 0 {
+		return Aggregate{}, 0, fmt.Errorf("no attestations to aggregate for data root: %s", dataRoot)
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		return Aggregate{}, 0, fmt.Errorf("failed to aggregate signatures: %v", err)
+	}
+	return Aggregate{Participants: bits, Sig: sig.Serialize()}, len(sigs), nil
+}
+
+// overlaps checks if any participant is set in both bitfields, the bitfields must be of the same length.
+func overlaps(a, b phase0.AttestationBits) bool {
+	n := a.BitLen()
+	for i := uint64(0); i < n; i++ {
+		if a.GetBit(i) && b.GetBit(i) {
+			return true
+		}
+	}
+	return false
+}
+
 // Prune pool based on current epoch, attestations which cannot be included anymore will get pruned.
 func (ap *AttestationPool) Prune(epoch common.Epoch) {
+	ap.Lock()
+	defer ap.Unlock()
 	min := epoch.Previous()
 	for k, v := range ap.datas {
 		if v.Data.Target.Epoch < min {
 			delete(ap.datas, k)
 			delete(ap.aggregate, k)
+			delete(ap.merged, k)
+		}
+	}
+	for k := range ap.epochs {
+		if k < min {
+			delete(ap.epochs, k)
 		}
 	}
 	for k := range ap.individual {
@@ -224,16 +535,292 @@ func (ap *AttestationPool) Prune(epoch common.Epoch) {
 	}
 }
 
+// newAttestationBits creates an empty bitlist for a committee of the given size.
+func newAttestationBits(size uint64) phase0.AttestationBits {
+	bits := make(phase0.AttestationBits, size/8+1)
+	// the delimiter bit marks the length of the bitlist
+	bits[size/8] |= 1 << (size % 8)
+	return bits
+}
+
+// packCandidate is an aggregate that may be packed, with its estimated value.
+type packCandidate struct {
+	data *IndexedAttData
+	agg  Aggregate
+	// the value of packing the candidate, as of the last time it was computed.
+	score uint64
+}
+
+// packCandidates is a max-heap of candidates by score.
+type packCandidates []*packCandidate
+
+func (h packCandidates) Len() int           { return len(h) }
+func (h packCandidates) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h packCandidates) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *packCandidates) Push(x interface{}) {
+	*h = append(*h, x.(*packCandidate))
+}
+func (h *packCandidates) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// candidates collects the aggregates, the extra aggregates, and the individual attestations
+// of all the attestation data that is accepted.
+func (ap *AttestationPool) candidates(accept func(d *IndexedAttData) bool) (out packCandidates) {
+	for dataRoot, d := range ap.datas {
+		if !accept(d) {
+			continue
+		}
+		add := func(agg Aggregate) {
+			if agg.Participants.BitLen() != uint64(len(d.Committee)) {
+				return
+			}
+			out = append(out, &packCandidate{data: d, agg: agg})
+		}
+		if agg, ok := ap.aggregate[dataRoot]; ok {
+			for _, a := range agg.Aggregates {
+				add(a)
+			}
+			for _, a := range agg.Extra {
+				add(a)
+			}
+		}
+		for i, vi := range d.Committee {
+			if ref, ok := ap.individual[Assignment{Index: vi, Epoch: d.Data.Target.Epoch}]; ok && ref.DataRoot == dataRoot {
+				bits := newAttestationBits(uint64(len(d.Committee)))
+				bits.SetBit(uint64(i), true)
+				add(Aggregate{Participants: bits, Sig: ref.Sig})
+			}
+		}
+		// the merged aggregate may cover more than any of the separate attestations.
+		// Failures, e.g. an invalid signature in the pool, are counted, the separate attestations can still be packed.
+		if merged, count, err := ap.mergedAggregate(dataRoot, d); err == nil && count > 1 {
+			add(merged)
+		}
+	}
+	return out
+}
+
+// greedyPack packs the candidate with the highest score every round, until maxCount is reached,
+// there are no candidates with a positive score left, or the deadline passes.
+// The score of a candidate may only decrease after packing other candidates,
+// so scores are only recomputed for the best candidate (lazy greedy max-coverage).
+// The mark function is called for every packed candidate, to update the state that scores are based on.
+func greedyPack(ctx context.Context, candidates packCandidates, maxCount uint64, deadline time.Time,
+	score func(c *packCandidate) uint64, mark func(c *packCandidate)) ([]phase0.Attestation, error) {
+	for _, c := range candidates {
+		c.score = score(c)
+	}
+	heap.Init(&candidates)
+	var out []phase0.Attestation
+	for uint64(len(out)) < maxCount && len(candidates) > 0 {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		best := candidates[0]
+		newScore := score(best)
+		if newScore == 0 {
+			heap.Pop(&candidates)
+			continue
+		}
+		if newScore < best.score {
+			// the score went down because of earlier packing, the candidate may not be the best anymore.
+			best.score = newScore
+			heap.Fix(&candidates, 0)
+			continue
+		}
+		heap.Pop(&candidates)
+		mark(best)
+		out = append(out, phase0.Attestation{
+			AggregationBits: best.agg.Participants.Copy(),
+			Data:            best.data.Data,
+			Signature:       best.agg.Sig,
+		})
+	}
+	return out, nil
+}
+
 // Approximation of the optimal attestation packing.
 // Attestations must match source, get prioritized if the target is correct, and more if the head is correct.
 // Attestations may not be included if they already are (checked via included func).
 // Maximum attestation output and packing-time constraints apply.
+//
+// This is a greedy max-coverage algorithm: every round the aggregate that adds the most value is packed,
+// where the value is the number of participants that are not covered yet, weighted by the correctness of the vote.
+// Attestations of the given head slot and older are packed, if they can still be included in the next slot.
+// If the time runs out, the attestations that are packed so far are returned.
 func (ap *AttestationPool) Packing(ctx context.Context,
 	source common.Checkpoint, target common.Checkpoint,
 	headRoot common.Root, headSlot common.Slot,
 	maxCount uint64, maxTime time.Duration,
-	included func(epoch common.Epoch, index common.ValidatorIndex)) ([]phase0.Attestation, error) {
+	included func(epoch common.Epoch, index common.ValidatorIndex) bool) ([]phase0.Attestation, error) {
+	ap.RLock()
+	defer ap.RUnlock()
 
-	// TODO find best attestations to pack for profit.
-	return nil, nil
+	deadline := time.Now().Add(maxTime)
+	if maxCount > uint64(ap.spec.MAX_ATTESTATIONS) {
+		maxCount = uint64(ap.spec.MAX_ATTESTATIONS)
+	}
+	candidates := ap.candidates(func(d *IndexedAttData) bool {
+		return d.Data.Source == source && d.Data.Slot <= headSlot && headSlot < d.Data.Slot+ap.spec.SLOTS_PER_EPOCH
+	})
+
+	covered := make(map[Assignment]struct{})
+	score := func(c *packCandidate) uint64 {
+		weight := uint64(1)
+		if c.data.Data.Target == target {
+			weight += 1
+			if c.data.Data.BeaconBlockRoot == headRoot {
+				weight += 1
+			}
+		}
+		epoch := c.data.Data.Target.Epoch
+		count := uint64(0)
+		for i, vi := range c.data.Committee {
+			if !c.agg.Participants.GetBit(uint64(i)) {
+				continue
+			}
+			if _, ok := covered[Assignment{Index: vi, Epoch: epoch}]; ok {
+				continue
+			}
+			if included != nil && included(epoch, vi) {
+				continue
+			}
+			count += 1
+		}
+		return weight * count
+	}
+	mark := func(c *packCandidate) {
+		for i, vi := range c.data.Committee {
+			if c.agg.Participants.GetBit(uint64(i)) {
+				covered[Assignment{Index: vi, Epoch: c.data.Data.Target.Epoch}] = struct{}{}
+			}
+		}
+	}
+	return greedyPack(ctx, candidates, maxCount, deadline, score, mark)
+}
+
+// AltairPacking packs the attestations that earn the proposer the most rewards,
+// for a block on top of the given state, which must be processed up to the slot of the block.
+//
+// The participation flags of the state are used to only count the flags that are newly set,
+// and the timeliness of the source, target and head flags depends on the inclusion delay.
+// Attestations that do not match the justified checkpoint of the state, or that are out of the
+// inclusion window, are not packed. Like Packing, this is a greedy approximation,
+// and the attestations that are packed so far are returned if the time runs out.
+func (ap *AttestationPool) AltairPacking(ctx context.Context, epc *common.EpochsContext,
+	state altair.AltairLikeBeaconState, maxCount uint64, maxTime time.Duration) ([]phase0.Attestation, error) {
+	ap.RLock()
+	defer ap.RUnlock()
+
+	deadline := time.Now().Add(maxTime)
+	if maxCount > uint64(ap.spec.MAX_ATTESTATIONS) {
+		maxCount = uint64(ap.spec.MAX_ATTESTATIONS)
+	}
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	currentEpoch := ap.spec.SlotToEpoch(slot)
+	previousEpoch := currentEpoch.Previous()
+	prevView, err := state.PreviousEpochParticipation()
+	if err != nil {
+		return nil, err
+	}
+	prevFlags, err := prevView.Raw()
+	if err != nil {
+		return nil, err
+	}
+	currView, err := state.CurrentEpochParticipation()
+	if err != nil {
+		return nil, err
+	}
+	currFlags, err := currView.Raw()
+	if err != nil {
+		return nil, err
+	}
+	// Deneb extends the inclusion window of attestations up to the end of the next epoch.
+	isDeneb := currentEpoch >= ap.spec.DENEB_FORK_EPOCH
+
+	// The flags each attestation data would set, if included in this block.
+	applyFlags := make(map[*IndexedAttData]altair.ParticipationFlags)
+	candidates := ap.candidates(func(d *IndexedAttData) bool {
+		data := &d.Data
+		if data.Target.Epoch != currentEpoch && data.Target.Epoch != previousEpoch {
+			return false
+		}
+		if data.Slot+ap.spec.MIN_ATTESTATION_INCLUSION_DELAY > slot {
+			return false
+		}
+		var flags altair.ParticipationFlags
+		var err error
+		if isDeneb {
+			flags, err = deneb.GetApplicableAttestationParticipationFlags(ap.spec, state, data, slot-data.Slot)
+		} else {
+			if slot > data.Slot+ap.spec.SLOTS_PER_EPOCH {
+				return false
+			}
+			flags, err = altair.GetApplicableAttestationParticipationFlags(ap.spec, state, data, slot-data.Slot)
+		}
+		// an error means the source does not match
+		if err != nil || flags == 0 {
+			return false
+		}
+		applyFlags[d] = flags
+		return true
+	})
+
+	baseRewardPerIncrement := ap.spec.EFFECTIVE_BALANCE_INCREMENT * common.Gwei(ap.spec.BASE_REWARD_FACTOR) / epc.TotalActiveStakeSqRoot
+	registry := func(c *packCandidate) altair.ParticipationRegistry {
+		if c.data.Data.Target.Epoch == currentEpoch {
+			return currFlags
+		}
+		return prevFlags
+	}
+	// The score is the proposer reward numerator, like in altair.ProcessAttestation.
+	score := func(c *packCandidate) uint64 {
+		flags := applyFlags[c.data]
+		reg := registry(c)
+		total := common.Gwei(0)
+		for i, vi := range c.data.Committee {
+			if !c.agg.Participants.GetBit(uint64(i)) || uint64(vi) >= uint64(len(reg)) {
+				continue
+			}
+			newFlags := flags &^ reg[vi]
+			if newFlags == 0 {
+				continue
+			}
+			weight := common.Gwei(0)
+			if newFlags&altair.TIMELY_SOURCE_FLAG != 0 {
+				weight += altair.TIMELY_SOURCE_WEIGHT
+			}
+			if newFlags&altair.TIMELY_TARGET_FLAG != 0 {
+				weight += altair.TIMELY_TARGET_WEIGHT
+			}
+			if newFlags&altair.TIMELY_HEAD_FLAG != 0 {
+				weight += altair.TIMELY_HEAD_WEIGHT
+			}
+			increments := epc.EffectiveBalances[vi] / ap.spec.EFFECTIVE_BALANCE_INCREMENT
+			total += increments * baseRewardPerIncrement * weight
+		}
+		return uint64(total)
+	}
+	mark := func(c *packCandidate) {
+		flags := applyFlags[c.data]
+		reg := registry(c)
+		for i, vi := range c.data.Committee {
+			if c.agg.Participants.GetBit(uint64(i)) && uint64(vi) < uint64(len(reg)) {
+				reg[vi] |= flags
+			}
+		}
+	}
+	return greedyPack(ctx, candidates, maxCount, deadline, score, mark)
 }
@@ -0,0 +1,437 @@
+package pool
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func testAttestation(data phase0.AttestationData, committeeSize uint64, participants ...uint64) *phase0.Attestation {
+	bits := newAttestationBits(committeeSize)
+	for _, i := range participants {
+		bits.SetBit(i, true)
+	}
+	return &phase0.Attestation{AggregationBits: bits, Data: data}
+}
+
+// testSecretKey is the secret key of the validator, as used in the test states.
+func testSecretKey(t *testing.T, index common.ValidatorIndex) *blsu.SecretKey {
+	var key [32]byte
+	binary.BigEndian.PutUint64(key[24:], uint64(index)+1)
+	var sk blsu.SecretKey
+	if err := sk.Deserialize(&key); err != nil {
+		t.Fatal(err)
+	}
+	return &sk
+}
+
+// testAttestationSigningRoot is the root that the attesters of the data sign.
+func testAttestationSigningRoot(spec *common.Spec, data *phase0.AttestationData) common.Root {
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_ATTESTER, spec.GENESIS_FORK_VERSION, common.Root{})
+	return common.ComputeSigningRoot(data.HashTreeRoot(tree.GetHashFn()), dom)
+}
+
+// testSignedAttestation is like testAttestation, with the aggregate signature of the participants.
+func testSignedAttestation(t *testing.T, spec *common.Spec, data phase0.AttestationData,
+	committee common.CommitteeIndices, participants ...uint64) *phase0.Attestation {
+	att := testAttestation(data, uint64(len(committee)), participants...)
+	root := testAttestationSigningRoot(spec, &data)
+	sigs := make([]*blsu.Signature, 0, len(participants))
+	for _, i := range participants {
+		sigs = append(sigs, blsu.Sign(testSecretKey(t, committee[i]), root[:]))
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	att.Signature = sig.Serialize()
+	return att
+}
+
+// testVerifyAttestation checks the aggregate signature of the attestation.
+func testVerifyAttestation(t *testing.T, spec *common.Spec, att *phase0.Attestation, committee common.CommitteeIndices) {
+	var pubs []*blsu.Pubkey
+	for i, vi := range committee {
+		if !att.AggregationBits.GetBit(uint64(i)) {
+			continue
+		}
+		pub, err := blsu.SkToPk(testSecretKey(t, vi))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, pub)
+	}
+	sig, err := att.Signature.Signature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := testAttestationSigningRoot(spec, &att.Data)
+	if !blsu.FastAggregateVerify(pubs, root[:], sig) {
+		t.Fatal("invalid aggregate signature")
+	}
+}
+
+func TestAttestationPoolPacking(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	ap := NewAttestationPool(spec)
+
+	source := common.Checkpoint{Epoch: 1, Root: common.Root{1}}
+	target := common.Checkpoint{Epoch: 2, Root: common.Root{2}}
+	headRoot := common.Root{3}
+	headSlot := common.Slot(17)
+	committeeA := common.CommitteeIndices{0, 1, 2, 3, 4, 5, 6, 7}
+	committeeB := common.CommitteeIndices{8, 9, 10, 11, 12, 13, 14, 15}
+
+	correct := phase0.AttestationData{Slot: 16, Index: 0, BeaconBlockRoot: headRoot, Source: source, Target: target}
+	for _, att := range []*phase0.Attestation{
+		testSignedAttestation(t, spec, correct, committeeA, 0, 1, 2, 3),
+		testSignedAttestation(t, spec, correct, committeeA, 3, 4, 5),
+		testSignedAttestation(t, spec, correct, committeeA, 6),
+	} {
+		if err := ap.AddAttestation(ctx, att, committeeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wrong target and head, more participants
+	wrong := phase0.AttestationData{Slot: 16, Index: 1, BeaconBlockRoot: common.Root{4}, Source: source,
+		Target: common.Checkpoint{Epoch: 2, Root: common.Root{5}}}
+	if err := ap.AddAttestation(ctx, testSignedAttestation(t, spec, wrong, committeeB, 0, 1, 2, 3, 4, 5, 6), committeeB); err != nil {
+		t.Fatal(err)
+	}
+	// other source, cannot be included
+	otherSource := correct
+	otherSource.Index = 2
+	otherSource.Source = common.Checkpoint{Epoch: 0, Root: common.Root{6}}
+	committeeC := common.CommitteeIndices{16, 17, 18, 19, 20, 21, 22, 23}
+	if err := ap.AddAttestation(ctx, testSignedAttestation(t, spec, otherSource, committeeC, 0, 1), committeeC); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ap.Packing(ctx, source, target, headRoot, headSlot, 3, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("expected 3 attestations, got %d", len(out))
+	}
+	// 5 merged correct votes (weight 3) > 7 new wrong votes (weight 1) > 2 new correct votes (weight 3)
+	if out[0].Data != correct || out[0].AggregationBits.OnesCount() != 5 {
+		t.Fatalf("unexpected first attestation: %v", out[0].AggregationBits)
+	}
+	if out[1].Data != wrong {
+		t.Fatal("expected the large aggregate with wrong target second")
+	}
+	if out[2].Data != correct || !out[2].AggregationBits.GetBit(4) || !out[2].AggregationBits.GetBit(5) {
+		t.Fatalf("expected the aggregate that adds two new participants third, got %v", out[2].AggregationBits)
+	}
+	// the merged aggregate signature is valid
+	testVerifyAttestation(t, spec, &out[0], committeeA)
+	testVerifyAttestation(t, spec, &out[1], committeeB)
+	testVerifyAttestation(t, spec, &out[2], committeeA)
+
+	// already included validators do not count
+	out, err = ap.Packing(ctx, source, target, headRoot, headSlot, 10, time.Second,
+		func(epoch common.Epoch, index common.ValidatorIndex) bool {
+			return epoch == 2 && index <= 3
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, att := range out {
+		if att.Data.Source != source {
+			t.Fatal("packed attestation with other source")
+		}
+		if att.Data == correct && !att.AggregationBits.GetBit(4) && !att.AggregationBits.GetBit(5) && !att.AggregationBits.GetBit(6) {
+			t.Fatal("packed attestation without new participants")
+		}
+	}
+	// the wrong aggregate, {3, 4, 5}, and one that adds 6
+	if len(out) != 3 {
+		t.Fatalf("expected 3 attestations, got %d", len(out))
+	}
+
+	// attestations after the head slot cannot be included yet
+	out, err = ap.Packing(ctx, source, target, headRoot, 15, 10, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("expected no attestations, got %d", len(out))
+	}
+}
+
+// testAltairState creates an altair state with the given number of validators, processed up to the given slot.
+func testAltairState(t *testing.T, spec *common.Spec, count uint64, slot common.Slot) (*altair.BeaconStateView, *common.EpochsContext) {
+	vals := make([]phase0.KickstartValidatorData, 0, count)
+	for i := uint64(0); i < count; i++ {
+		pub, err := blsu.SkToPk(testSecretKey(t, common.ValidatorIndex(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, phase0.KickstartValidatorData{
+			Pubkey:                pub.Serialize(),
+			WithdrawalCredentials: common.Root{0xbb},
+			Balance:               spec.MAX_EFFECTIVE_BALANCE,
+		})
+	}
+	pre, epc, err := phase0.KickStartState(spec, common.Root{123}, 1564000000, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := altair.UpgradeToAltair(spec, epc, pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &beacon.StandardUpgradeableBeaconState{BeaconState: post}
+	if err := common.ProcessSlots(context.Background(), spec, epc, state, slot); err != nil {
+		t.Fatal(err)
+	}
+	return state.BeaconState.(*altair.BeaconStateView), epc
+}
+
+func TestAttestationPoolAltairPacking(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	ctx := context.Background()
+	state, epc := testAltairState(t, &spec, 64, 2)
+
+	source, err := state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no blocks: the head and target are the genesis block at every slot
+	genesisRoot, err := common.GetBlockRootAtSlot(&spec, state, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := common.Checkpoint{Epoch: 0, Root: genesisRoot}
+
+	ap := NewAttestationPool(&spec)
+	add := func(data phase0.AttestationData, participants ...uint64) common.CommitteeIndices {
+		committee, err := epc.GetBeaconCommittee(data.Slot, data.Index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ap.AddAttestation(ctx, testAttestation(data, uint64(len(committee)), participants...), committee); err != nil {
+			t.Fatal(err)
+		}
+		return committee
+	}
+	// included with delay 1: source, target and head flags
+	timely := phase0.AttestationData{Slot: 1, Index: 0, BeaconBlockRoot: genesisRoot, Source: source, Target: target}
+	timelyCommittee := add(timely, 0, 1)
+	// included with delay 2: no head flag, but more participants
+	late := phase0.AttestationData{Slot: 0, Index: 0, BeaconBlockRoot: genesisRoot, Source: source, Target: target}
+	add(late, 0, 1, 2)
+	// wrong head, would have been timely
+	wrongHead := phase0.AttestationData{Slot: 1, Index: 1, BeaconBlockRoot: common.Root{1}, Source: source, Target: target}
+	add(wrongHead, 0, 1)
+	// other source: cannot be included
+	otherSource := phase0.AttestationData{Slot: 0, Index: 1, BeaconBlockRoot: genesisRoot,
+		Source: common.Checkpoint{Epoch: 0, Root: common.Root{2}}, Target: target}
+	add(otherSource, 0, 1, 2, 3)
+	// too new to be included
+	tooNew := phase0.AttestationData{Slot: 2, Index: 0, BeaconBlockRoot: genesisRoot, Source: source, Target: target}
+	add(tooNew, 0, 1, 2, 3)
+
+	out, err := ap.AltairPacking(ctx, epc, state, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 * (source + target) = 120 > 2 * (source + target + head) = 108 > 2 * (source + target) = 80
+	if len(out) != 1 || out[0].Data != late {
+		t.Fatalf("expected the late attestation with most new flags, got %v", out)
+	}
+
+	// participants that already have the source and target flags only add the head flag.
+	curr, err := state.CurrentEpochParticipation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1} {
+		if err := curr.SetFlags(timelyCommittee[i], altair.TIMELY_SOURCE_FLAG|altair.TIMELY_TARGET_FLAG); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err = ap.AltairPacking(ctx, epc, state, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[phase0.AttestationData]bool)
+	for _, att := range out {
+		got[att.Data] = true
+	}
+	if len(out) != 3 || !got[timely] || !got[late] || !got[wrongHead] {
+		t.Fatalf("unexpected packing: %v", out)
+	}
+	// the timely attestation only adds head flags now, it is worth the least
+	if out[2].Data != timely {
+		t.Fatalf("expected the timely attestation last, got %v", out[2].Data)
+	}
+}
+
+func TestAttestationPoolAggregateAttestation(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	ap := NewAttestationPool(spec)
+
+	data := phase0.AttestationData{Slot: 16, Index: 0, BeaconBlockRoot: common.Root{3},
+		Source: common.Checkpoint{Epoch: 1, Root: common.Root{1}}, Target: common.Checkpoint{Epoch: 2, Root: common.Root{2}}}
+	committee := common.CommitteeIndices{0, 1, 2, 3, 4, 5, 6, 7}
+	signed := func(participants ...uint64) *phase0.Attestation {
+		return testSignedAttestation(t, spec, data, committee, participants...)
+	}
+
+	dataRoot := data.HashTreeRoot(tree.GetHashFn())
+	if _, err := ap.AggregateAttestation(dataRoot); err == nil {
+		t.Fatal("expected error for unknown data root")
+	}
+	// {3, 4} overlaps with the larger aggregate, the individual vote by 3 is already covered.
+	for _, att := range []*phase0.Attestation{
+		signed(0, 1, 2, 3),
+		signed(3, 4),
+		signed(4, 5),
+		signed(3),
+		signed(6),
+		signed(7),
+	} {
+		if err := ap.AddAttestation(ctx, att, committee); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := ap.AggregateAttestation(dataRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Data != data || out.AggregationBits.OnesCount() != uint64(len(committee)) {
+		t.Fatalf("expected full aggregate, got %v", out.AggregationBits)
+	}
+	testVerifyAttestation(t, spec, out, committee)
+
+	// block packing uses the merged aggregate as well
+	packed, err := ap.Packing(ctx, data.Source, data.Target, data.BeaconBlockRoot, 17, 1, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) != 1 || packed[0].AggregationBits.OnesCount() != uint64(len(committee)) {
+		t.Fatal("expected the merged aggregate to be packed")
+	}
+
+	// attestations that cannot be merged are counted once, until the attestations of the data change.
+	invalid := data
+	invalid.Index = 1
+	invalidCommittee := common.CommitteeIndices{8, 9, 10, 11, 12, 13, 14, 15}
+	if err := ap.AddAttestation(ctx, testAttestation(invalid, 8, 0, 1), invalidCommittee); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ap.Packing(ctx, data.Source, data.Target, data.BeaconBlockRoot, 17, 10, time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+		if c := ap.Counters(); c.MergeFailures != 1 {
+			t.Fatalf("expected 1 merge failure, got %d", c.MergeFailures)
+		}
+	}
+	if err := ap.AddAttestation(ctx, testAttestation(invalid, 8, 2, 3), invalidCommittee); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ap.AggregateAttestation(invalid.HashTreeRoot(tree.GetHashFn())); err == nil {
+		t.Fatal("expected merge error")
+	}
+	if c := ap.Counters(); c.MergeFailures != 2 {
+		t.Fatalf("expected 2 merge failures, got %d", c.MergeFailures)
+	}
+}
+
+func TestAttestationPoolSlashingDetection(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	ap := NewAttestationPool(spec)
+	asp := NewAttesterSlashingPool(spec)
+	ap.FeedSlashings(asp)
+
+	source := common.Checkpoint{Epoch: 1, Root: common.Root{1}}
+	target := common.Checkpoint{Epoch: 2, Root: common.Root{2}}
+	committee := common.CommitteeIndices{7, 6, 5, 4, 3, 2, 1, 0}
+	a := phase0.AttestationData{Slot: 16, Index: 0, BeaconBlockRoot: common.Root{3}, Source: source, Target: target}
+	if err := ap.AddAttestation(ctx, testAttestation(a, 8, 0, 1, 2), committee); err != nil {
+		t.Fatal(err)
+	}
+	if err := ap.AddAttestation(ctx, testAttestation(a, 8, 3), committee); err != nil {
+		t.Fatal(err)
+	}
+	if len(asp.All()) != 0 {
+		t.Fatal("unexpected slashing")
+	}
+
+	// double vote by validator 4, individually
+	b := a
+	b.BeaconBlockRoot = common.Root{4}
+	if err := ap.AddAttestation(ctx, testAttestation(b, 8, 3), committee); err == nil {
+		t.Fatal("expected double vote error")
+	}
+	slashings := asp.All()
+	if len(slashings) != 1 {
+		t.Fatalf("expected 1 slashing, got %d", len(slashings))
+	}
+	if got := slashings[0].EquivocatingIndices(); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("expected validator 4 to be slashable, got %v", got)
+	}
+
+	// surround vote of a, by an aggregate in the next epoch
+	c := phase0.AttestationData{Slot: 24, Index: 0, BeaconBlockRoot: common.Root{5},
+		Source: common.Checkpoint{Epoch: 0, Root: common.Root{6}}, Target: common.Checkpoint{Epoch: 3, Root: common.Root{7}}}
+	if err := ap.AddAttestation(ctx, testAttestation(c, 8, 1, 2, 5), committee); err != nil {
+		t.Fatal(err)
+	}
+	slashings = asp.All()
+	if len(slashings) != 2 {
+		t.Fatalf("expected 2 slashings, got %d", len(slashings))
+	}
+	found := false
+	for _, sl := range slashings {
+		if sl.Attestation1.Data != c {
+			continue
+		}
+		if got := sl.EquivocatingIndices(); len(got) != 2 || got[0] != 5 || got[1] != 6 {
+			t.Fatalf("expected validators 5 and 6 to be slashable, got %v", got)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("missing surround vote slashing")
+	}
+
+	// double vote of a by validators 5 and 4, which voted for a in different attestations,
+	// and surrounded by c for validator 5.
+	d := a
+	d.BeaconBlockRoot = common.Root{8}
+	if err := ap.AddAttestation(ctx, testAttestation(d, 8, 2, 3), committee); err != nil {
+		t.Fatal(err)
+	}
+	slashable := make(map[common.ValidatorIndex]int)
+	count := 0
+	for _, sl := range asp.All() {
+		if sl.Attestation2.Data != d {
+			continue
+		}
+		count++
+		for _, vi := range sl.EquivocatingIndices() {
+			slashable[vi]++
+		}
+	}
+	if count != 3 || slashable[4] != 1 || slashable[5] != 2 {
+		t.Fatalf("expected 3 slashings, of validator 4 once and of validator 5 twice, got %d: %v", count, slashable)
+	}
+}
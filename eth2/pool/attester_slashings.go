@@ -1,8 +1,10 @@
 package pool
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -14,15 +16,33 @@ type AttesterSlashingPool struct {
 	sync.RWMutex
 	spec      *common.Spec
 	slashings map[common.Root]*phase0.AttesterSlashing
+	limit     uint64
+	counters  PoolCounters
 }
 
 func NewAttesterSlashingPool(spec *common.Spec) *AttesterSlashingPool {
 	return &AttesterSlashingPool{
 		spec:      spec,
 		slashings: make(map[common.Root]*phase0.AttesterSlashing),
+		limit:     DefaultMaxAttesterSlashings,
 	}
 }
 
+// SetLimit changes the maximum number of slashings in the pool, zero means no limit.
+// When full, the slashing that slashes the fewest validators is replaced,
+// if the new slashing slashes more validators.
+func (asp *AttesterSlashingPool) SetLimit(limit uint64) {
+	asp.Lock()
+	defer asp.Unlock()
+	asp.limit = limit
+}
+
+func (asp *AttesterSlashingPool) Counters() PoolCounters {
+	asp.RLock()
+	defer asp.RUnlock()
+	return asp.counters
+}
+
 // This does not filter slashings that are a subset of other slashings.
 // The pool merely collects them. Make sure to protect against spam elsewhere as a caller.
 func (asp *AttesterSlashingPool) AddAttesterSlashing(ctx context.Context, sl *phase0.AttesterSlashing) error {
@@ -32,6 +52,22 @@ func (asp *AttesterSlashingPool) AddAttesterSlashing(ctx context.Context, sl *ph
 	if _, ok := asp.slashings[root]; ok {
 		return fmt.Errorf("already have an attester slashing for message %s", root)
 	}
+	if asp.limit != 0 && uint64(len(asp.slashings)) >= asp.limit {
+		count := len(sl.EquivocatingIndices())
+		var smallest common.Root
+		smallestCount := -1
+		for k, v := range asp.slashings {
+			if c := len(v.EquivocatingIndices()); smallestCount < 0 || c < smallestCount {
+				smallest, smallestCount = k, c
+			}
+		}
+		if smallestCount >= count {
+			asp.counters.Rejected++
+			return PoolFullErr
+		}
+		delete(asp.slashings, smallest)
+		asp.counters.Evicted++
+	}
 	asp.slashings[root] = sl
 	return nil
 }
@@ -49,6 +85,68 @@ func (asp *AttesterSlashingPool) All() []*phase0.AttesterSlashing {
 // Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
 // Slashings with negative rewards will not be packed.
 func (asp *AttesterSlashingPool) Pack(estReward func(sl *phase0.AttesterSlashing) int, n uint) []*phase0.AttesterSlashing {
-	// TODO
-	return nil
+	asp.Lock()
+	defer asp.Unlock()
+	keys := asp.ranked(estReward)
+	if uint(len(keys)) > n {
+		keys = keys[:n]
+	}
+	out := make([]*phase0.AttesterSlashing, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, asp.slashings[k])
+		delete(asp.slashings, k)
+	}
+	return out
+}
+
+// ranked returns the keys of the slashings with a non-negative estimated reward, best first.
+func (asp *AttesterSlashingPool) ranked(estReward func(sl *phase0.AttesterSlashing) int) []common.Root {
+	rewards := make(map[common.Root]int, len(asp.slashings))
+	keys := make([]common.Root, 0, len(asp.slashings))
+	for k, sl := range asp.slashings {
+		if r := estReward(sl); r >= 0 {
+			rewards[k] = r
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := rewards[keys[i]], rewards[keys[j]]; a != b {
+			return a > b
+		}
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	return keys
+}
+
+// PackValid packs up to n slashings that are valid against the state, ranked like Pack.
+// Slashings that only slash validators in packed are skipped, the validators they slash are added to packed.
+// Invalid slashings are pruned from the pool. Packed slashings are kept,
+// these are pruned once the state includes them, as they are not effective anymore.
+func (asp *AttesterSlashingPool) PackValid(epc *common.EpochsContext, state common.BeaconState,
+	estReward func(sl *phase0.AttesterSlashing) int, n uint, packed PackedValidators) ([]*phase0.AttesterSlashing, error) {
+	asp.Lock()
+	defer asp.Unlock()
+	var out []*phase0.AttesterSlashing
+	for _, k := range asp.ranked(estReward) {
+		if uint(len(out)) >= n {
+			break
+		}
+		sl := asp.slashings[k]
+		slashable, err := phase0.ValidateAttesterSlashing(asp.spec, epc, state, sl)
+		if err != nil {
+			delete(asp.slashings, k)
+			continue
+		}
+		effective := false
+		for _, i := range slashable {
+			if !packed.Has(i) {
+				effective = true
+				packed.Add(i)
+			}
+		}
+		if effective {
+			out = append(out, sl)
+		}
+	}
+	return out, nil
 }
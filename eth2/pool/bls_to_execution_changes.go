@@ -0,0 +1,149 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+type BLSToExecutionChangePool struct {
+	sync.RWMutex
+	spec     *common.Spec
+	changes  map[common.ValidatorIndex]*common.SignedBLSToExecutionChange
+	limit    uint64
+	counters PoolCounters
+}
+
+func NewBLSToExecutionChangePool(spec *common.Spec) *BLSToExecutionChangePool {
+	return &BLSToExecutionChangePool{
+		spec:    spec,
+		changes: make(map[common.ValidatorIndex]*common.SignedBLSToExecutionChange),
+		limit:   DefaultMaxBLSToExecutionChanges,
+	}
+}
+
+// SetLimit changes the maximum number of changes in the pool, zero means no limit.
+// When full, new changes are rejected.
+func (bp *BLSToExecutionChangePool) SetLimit(limit uint64) {
+	bp.Lock()
+	defer bp.Unlock()
+	bp.limit = limit
+}
+
+func (bp *BLSToExecutionChangePool) Counters() PoolCounters {
+	bp.RLock()
+	defer bp.RUnlock()
+	return bp.counters
+}
+
+// AddBLSToExecutionChange adds the change, only the first change of a validator is kept:
+// a validator can only change its withdrawal credentials once.
+func (bp *BLSToExecutionChangePool) AddBLSToExecutionChange(ctx context.Context, ch *common.SignedBLSToExecutionChange) error {
+	bp.Lock()
+	defer bp.Unlock()
+	key := ch.BLSToExecutionChange.ValidatorIndex
+	if _, ok := bp.changes[key]; ok {
+		return fmt.Errorf("already have bls to execution change for validator %d", key)
+	}
+	if bp.limit != 0 && uint64(len(bp.changes)) >= bp.limit {
+		bp.counters.Rejected++
+		return PoolFullErr
+	}
+	bp.changes[key] = ch
+	return nil
+}
+
+func (bp *BLSToExecutionChangePool) All() []*common.SignedBLSToExecutionChange {
+	bp.RLock()
+	defer bp.RUnlock()
+	out := make([]*common.SignedBLSToExecutionChange, 0, len(bp.changes))
+	for _, a := range bp.changes {
+		out = append(out, a)
+	}
+	return out
+}
+
+// applicable checks if the change can still be applied to the validator registry:
+// the validator must exist, and still have BLS withdrawal credentials of the pubkey of the change.
+// The signature is not verified, changes are expected to be verified before entering the pool.
+func applicable(validators common.ValidatorRegistry, count uint64, ch *common.SignedBLSToExecutionChange) (bool, error) {
+	if uint64(ch.BLSToExecutionChange.ValidatorIndex) >= count {
+		return false, nil
+	}
+	validator, err := validators.Validator(ch.BLSToExecutionChange.ValidatorIndex)
+	if err != nil {
+		return false, err
+	}
+	creds, err := validator.WithdrawalCredentials()
+	if err != nil {
+		return false, err
+	}
+	if creds[0] != common.BLS_WITHDRAWAL_PREFIX {
+		return false, nil
+	}
+	pubHash := hashing.Hash(ch.BLSToExecutionChange.FromBLSPubKey[:])
+	return bytes.Equal(creds[1:], pubHash[1:]), nil
+}
+
+// Prune removes the changes that cannot be applied to the state anymore,
+// e.g. because the change, or another change of the same validator, was already applied.
+func (bp *BLSToExecutionChangePool) Prune(state common.BeaconState) error {
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	count, err := validators.ValidatorCount()
+	if err != nil {
+		return err
+	}
+	bp.Lock()
+	defer bp.Unlock()
+	for k, ch := range bp.changes {
+		if ok, err := applicable(validators, count, ch); err != nil {
+			return err
+		} else if !ok {
+			delete(bp.changes, k)
+		}
+	}
+	return nil
+}
+
+// Pack up to MAX_BLS_TO_EXECUTION_CHANGES changes that can be applied to the state, ordered by validator index.
+// The changes are not removed from the pool, they are pruned once the state includes them.
+func (bp *BLSToExecutionChangePool) Pack(state common.BeaconState) (common.SignedBLSToExecutionChanges, error) {
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	count, err := validators.ValidatorCount()
+	if err != nil {
+		return nil, err
+	}
+	bp.RLock()
+	defer bp.RUnlock()
+	keys := make([]common.ValidatorIndex, 0, len(bp.changes))
+	for k := range bp.changes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	out := make(common.SignedBLSToExecutionChanges, 0, bp.spec.MAX_BLS_TO_EXECUTION_CHANGES)
+	for _, k := range keys {
+		if uint64(len(out)) >= uint64(bp.spec.MAX_BLS_TO_EXECUTION_CHANGES) {
+			break
+		}
+		ch := bp.changes[k]
+		if ok, err := applicable(validators, count, ch); err != nil {
+			return nil, err
+		} else if ok {
+			out = append(out, *ch)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package pool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+func TestBLSToExecutionChangePool(t *testing.T) {
+	spec := *configs.Minimal
+	spec.MAX_BLS_TO_EXECUTION_CHANGES = 2
+	ctx := context.Background()
+
+	blsCreds := func(pub common.BLSPubkey) (out common.Root) {
+		out = hashing.Hash(pub[:])
+		out[0] = common.BLS_WITHDRAWAL_PREFIX
+		return out
+	}
+	state, _ := testAltairState(t, &spec, 64, 1)
+	validators, err := state.Validators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubs := make([]common.BLSPubkey, 4)
+	for i := range pubs {
+		val, err := validators.Validator(common.ValidatorIndex(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pubs[i], err = val.Pubkey(); err != nil {
+			t.Fatal(err)
+		}
+		if err := val.SetWithdrawalCredentials(blsCreds(pubs[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	change := func(index common.ValidatorIndex, pub common.BLSPubkey) *common.SignedBLSToExecutionChange {
+		return &common.SignedBLSToExecutionChange{
+			BLSToExecutionChange: common.BLSToExecutionChange{ValidatorIndex: index, FromBLSPubKey: pub},
+		}
+	}
+
+	bp := NewBLSToExecutionChangePool(&spec)
+	for _, ch := range []*common.SignedBLSToExecutionChange{
+		change(0, pubs[0]),
+		change(1, common.BLSPubkey{0xff}), // wrong pubkey
+		change(2, pubs[2]),
+		change(3, pubs[3]),
+		change(100, common.BLSPubkey{0xff}), // unknown validator
+	} {
+		if err := bp.AddBLSToExecutionChange(ctx, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bp.AddBLSToExecutionChange(ctx, change(0, pubs[0])); err == nil {
+		t.Fatal("expected duplicate change to be rejected")
+	}
+
+	out, err := bp.Pack(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].BLSToExecutionChange.ValidatorIndex != 0 || out[1].BLSToExecutionChange.ValidatorIndex != 2 {
+		t.Fatalf("unexpected packing: %v", out)
+	}
+
+	// apply the change of validator 0
+	val, err := validators.Validator(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := val.SetWithdrawalCredentials(common.Root{common.ETH1_ADDRESS_WITHDRAWAL_PREFIX}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.Prune(state); err != nil {
+		t.Fatal(err)
+	}
+	if all := bp.All(); len(all) != 2 {
+		t.Fatalf("expected 2 changes after pruning, got %d", len(all))
+	}
+	out, err = bp.Pack(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].BLSToExecutionChange.ValidatorIndex != 2 || out[1].BLSToExecutionChange.ValidatorIndex != 3 {
+		t.Fatalf("unexpected packing after pruning: %v", out)
+	}
+}
@@ -0,0 +1,183 @@
+package pool
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+var PoolFullErr = errors.New("pool is full")
+
+// PoolCounters counts what a pool did to stay within its limits.
+type PoolCounters struct {
+	// Rejected is the number of items that were not added, because the pool was full.
+	Rejected uint64
+	// Evicted is the number of items that were removed, to make space for new items.
+	Evicted uint64
+	// MergeFailures is the number of times the attestations of an attestation data could not be merged
+	// into one aggregate, e.g. because of an invalid signature. Only used by the attestation pool.
+	MergeFailures uint64
+}
+
+// AttestationPoolLimits bounds the memory used by the attestation pool. A zero limit means no limit.
+type AttestationPoolLimits struct {
+	// MaxDataRoots is the maximum number of different attestation data.
+	// When full, the attestation data with the oldest target and slot is evicted first,
+	// with all aggregates and individual attestations of it.
+	MaxDataRoots uint64
+	// MaxAggregatesPerRoot is the maximum number of aggregates per attestation data, that add new participants.
+	// When full, the aggregate with the fewest participants is replaced, if the new aggregate has more participants.
+	MaxAggregatesPerRoot uint64
+	// MaxExtraAggregates is the maximum number of extra aggregates per attestation data,
+	// that do not add new participants, but may pack better.
+	// When full, the extra aggregate with the fewest participants is replaced, if the new one has more participants.
+	MaxExtraAggregates uint64
+	// MaxIndividual is the maximum number of individual attestations.
+	// When full, the individual attestations of the oldest target epoch are evicted first.
+	MaxIndividual uint64
+}
+
+// DefaultAttestationPoolLimits fits the attestations of two epochs on mainnet, with room for forks.
+var DefaultAttestationPoolLimits = AttestationPoolLimits{
+	MaxDataRoots:         1 << 14,
+	MaxAggregatesPerRoot: 16,
+	MaxExtraAggregates:   10,
+	MaxIndividual:        1 << 20,
+}
+
+// Limits for the operation pools, without a state it is not known which operations are stale,
+// so new operations are rejected when the pool is full.
+// Stale operations are pruned when packing against a state.
+const (
+	DefaultMaxAttesterSlashings     = 1 << 10
+	DefaultMaxProposerSlashings     = 1 << 10
+	DefaultMaxVoluntaryExits        = 1 << 14
+	DefaultMaxBLSToExecutionChanges = 1 << 17
+)
+
+// evictBatch is the number of individual attestations to evict at once when the pool of the given size is full,
+// to not have to find the next stale items for every new item.
+func evictBatch(size int) int {
+	if n := size / 8; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// evictDatas evicts the attestation data with the oldest target epoch and slot,
+// including all aggregates and individual attestations of it, to make space for the new data.
+// Only data older than the new data is evicted. Returns false if nothing was evicted.
+func (ap *AttestationPool) evictDatas(data *phase0.AttestationData) bool {
+	older := func(a, b *phase0.AttestationData) bool {
+		if a.Target.Epoch != b.Target.Epoch {
+			return a.Target.Epoch < b.Target.Epoch
+		}
+		return a.Slot < b.Slot
+	}
+	roots := make([]common.Root, 0, len(ap.datas))
+	for k, d := range ap.datas {
+		if older(&d.Data, data) {
+			roots = append(roots, k)
+		}
+	}
+	// the limits may have been lowered since the data was added
+	n := len(ap.datas) - int(ap.limits.MaxDataRoots) + 1
+	if len(roots) < n {
+		return false
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return older(&ap.datas[roots[i]].Data, &ap.datas[roots[j]].Data)
+	})
+	for _, root := range roots[:n] {
+		ap.removeData(root)
+		ap.counters.Evicted++
+	}
+	return true
+}
+
+// removeData removes the attestation data, including all aggregates and individual attestations of it.
+func (ap *AttestationPool) removeData(root common.Root) {
+	d, ok := ap.datas[root]
+	if !ok {
+		return
+	}
+	key := Assignment{Epoch: d.Data.Target.Epoch}
+	for _, vi := range d.Committee {
+		key.Index = vi
+		if ref, ok := ap.individual[key]; ok && ref.DataRoot == root {
+			delete(ap.individual, key)
+		}
+		if r, ok := ap.aggPerValidator[key]; ok && r == root {
+			delete(ap.aggPerValidator, key)
+		}
+	}
+	delete(ap.datas, root)
+	delete(ap.aggregate, root)
+	delete(ap.merged, root)
+	if ap.epochs[key.Epoch] <= 1 {
+		delete(ap.epochs, key.Epoch)
+	} else {
+		ap.epochs[key.Epoch]--
+	}
+}
+
+// evictIndividual evicts individual attestations of the oldest target epoch,
+// if that is older than the epoch of the new attestation. Returns false if nothing was evicted.
+func (ap *AttestationPool) evictIndividual(epoch common.Epoch) bool {
+	oldest := epoch
+	for k := range ap.individual {
+		if k.Epoch < oldest {
+			oldest = k.Epoch
+		}
+	}
+	if oldest == epoch {
+		// only evict older attestations, the pool is full with attestations as recent as the new one.
+		return false
+	}
+	n := evictBatch(len(ap.individual))
+	for k := range ap.individual {
+		if n == 0 {
+			break
+		}
+		if k.Epoch == oldest {
+			delete(ap.merged, ap.individual[k].DataRoot)
+			delete(ap.individual, k)
+			ap.counters.Evicted++
+			n--
+		}
+	}
+	return true
+}
+
+// replaceSmallest replaces the aggregate with the fewest participants, if the new aggregate has more participants.
+// Returns false if the new aggregate was not added.
+func replaceSmallest(aggs []Aggregate, agg Aggregate) bool {
+	smallest := 0
+	for i := range aggs {
+		if aggs[i].Participants.OnesCount() < aggs[smallest].Participants.OnesCount() {
+			smallest = i
+		}
+	}
+	if len(aggs) == 0 || aggs[smallest].Participants.OnesCount() >= agg.Participants.OnesCount() {
+		return false
+	}
+	aggs[smallest] = agg
+	return true
+}
+
+// SetLimits changes the limits of the pool, these are applied to new attestations.
+func (ap *AttestationPool) SetLimits(limits AttestationPoolLimits) {
+	ap.Lock()
+	defer ap.Unlock()
+	ap.limits = limits
+}
+
+func (ap *AttestationPool) Counters() PoolCounters {
+	ap.RLock()
+	defer ap.RUnlock()
+	ap.mergedLock.Lock()
+	defer ap.mergedLock.Unlock()
+	return ap.counters
+}
@@ -0,0 +1,143 @@
+package pool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func TestAttestationPoolLimits(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	ap := NewAttestationPool(spec)
+	ap.SetLimits(AttestationPoolLimits{MaxDataRoots: 4, MaxAggregatesPerRoot: 2, MaxExtraAggregates: 1, MaxIndividual: 4})
+	committee := common.CommitteeIndices{0, 1, 2, 3, 4, 5, 6, 7}
+	data := func(epoch common.Epoch, head byte) phase0.AttestationData {
+		return phase0.AttestationData{Slot: common.Slot(epoch) * 8, BeaconBlockRoot: common.Root{head},
+			Target: common.Checkpoint{Epoch: epoch}}
+	}
+
+	// individual attestations: full with epoch 2, epoch 1 is rejected, epoch 3 evicts epoch 2
+	for i := uint64(0); i < 4; i++ {
+		if err := ap.AddAttestation(ctx, testAttestation(data(2, 1), 8, i), committee); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ap.AddAttestation(ctx, testAttestation(data(1, 1), 8, 5), committee); err != PoolFullErr {
+		t.Fatalf("expected pool full error, got %v", err)
+	}
+	if err := ap.AddAttestation(ctx, testAttestation(data(3, 1), 8, 5), committee); err != nil {
+		t.Fatal(err)
+	}
+	if c := ap.Counters(); c.Rejected != 1 || c.Evicted != 1 {
+		t.Fatalf("unexpected counters: %+v", c)
+	}
+	// the data of the rejected attestation is not kept
+	rejected := data(1, 1)
+	if _, ok := ap.datas[rejected.HashTreeRoot(tree.GetHashFn())]; ok {
+		t.Fatal("expected data of rejected attestation to be removed")
+	}
+	if _, ok := ap.epochs[1]; ok {
+		t.Fatal("expected epoch of rejected attestation to be removed")
+	}
+
+	// aggregates per root
+	d := data(3, 2)
+	for _, att := range []*phase0.Attestation{
+		testAttestation(d, 8, 0, 1),
+		testAttestation(d, 8, 2, 3, 4),
+		testAttestation(d, 8, 5, 6),    // rejected, not larger than the smallest aggregate
+		testAttestation(d, 8, 5, 6, 7), // replaces the smallest aggregate
+		testAttestation(d, 8, 1, 2),    // extra aggregate
+		testAttestation(d, 8, 2, 3),    // rejected, not larger than the extra aggregate
+	} {
+		if err := ap.AddAttestation(ctx, att, committee); err != nil && err != PoolFullErr {
+			t.Fatal(err)
+		}
+	}
+	aggs := ap.aggregate[d.HashTreeRoot(tree.GetHashFn())]
+	if aggs == nil || len(aggs.Aggregates) != 2 || len(aggs.Extra) != 1 || aggs.Participants.OnesCount() != 6 {
+		t.Fatal("unexpected aggregates")
+	}
+	if c := ap.Counters(); c.Rejected != 3 || c.Evicted != 2 {
+		t.Fatalf("unexpected counters: %+v", c)
+	}
+
+	// data roots: the oldest are evicted, one at a time
+	for head := byte(3); head < 6; head++ {
+		if err := ap.AddAttestation(ctx, testAttestation(data(4, head), 8, 2*uint64(head-3), 2*uint64(head-3)+1), committee); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ap.datas) != 4 {
+		t.Fatalf("expected 4 data roots, got %d", len(ap.datas))
+	}
+	if c := ap.Counters(); c.Evicted != 4 {
+		t.Fatalf("expected 2 evicted data roots, got counters: %+v", c)
+	}
+	old := data(2, 1)
+	if _, ok := ap.datas[old.HashTreeRoot(tree.GetHashFn())]; ok {
+		t.Fatal("expected oldest data to be evicted")
+	}
+	if _, ok := ap.epochs[2]; ok {
+		t.Fatal("expected epoch of evicted data to be removed")
+	}
+	// older data does not evict newer data
+	if err := ap.AddAttestation(ctx, testAttestation(data(3, 9), 8, 0, 1), committee); err != PoolFullErr {
+		t.Fatalf("expected pool full error, got %v", err)
+	}
+	if len(ap.datas) != 4 {
+		t.Fatalf("expected 4 data roots, got %d", len(ap.datas))
+	}
+	for k, ref := range ap.individual {
+		if _, ok := ap.datas[ref.DataRoot]; !ok {
+			t.Fatalf("individual attestation of %d without data", k.Index)
+		}
+	}
+}
+
+func TestOperationPoolLimits(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	vep := NewVoluntaryExitPool(spec)
+	vep.SetLimit(2)
+	for i := common.ValidatorIndex(0); i < 3; i++ {
+		err := vep.AddVoluntaryExit(ctx, &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{ValidatorIndex: i}})
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		} else if i == 2 && err != PoolFullErr {
+			t.Fatalf("expected pool full error, got %v", err)
+		}
+	}
+	if c := vep.Counters(); c.Rejected != 1 || c.Evicted != 0 {
+		t.Fatalf("unexpected counters: %+v", c)
+	}
+
+	asp := NewAttesterSlashingPool(spec)
+	asp.SetLimit(1)
+	slashing := func(indices ...common.ValidatorIndex) *phase0.AttesterSlashing {
+		return &phase0.AttesterSlashing{
+			Attestation1: phase0.IndexedAttestation{AttestingIndices: indices, Data: phase0.AttestationData{BeaconBlockRoot: common.Root{1}}},
+			Attestation2: phase0.IndexedAttestation{AttestingIndices: indices, Data: phase0.AttestationData{BeaconBlockRoot: common.Root{2}}},
+		}
+	}
+	if err := asp.AddAttesterSlashing(ctx, slashing(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := asp.AddAttesterSlashing(ctx, slashing(3)); err != PoolFullErr {
+		t.Fatalf("expected pool full error, got %v", err)
+	}
+	if err := asp.AddAttesterSlashing(ctx, slashing(4, 5, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if all := asp.All(); len(all) != 1 || len(all[0].EquivocatingIndices()) != 3 {
+		t.Fatal("expected the larger slashing to replace the smaller slashing")
+	}
+	if c := asp.Counters(); c.Rejected != 1 || c.Evicted != 1 {
+		t.Fatalf("unexpected counters: %+v", c)
+	}
+}
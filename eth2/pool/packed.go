@@ -0,0 +1,20 @@
+package pool
+
+import (
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// PackedValidators tracks the validators that are slashed or exited by the operations packed into a block so far.
+// Block operations are processed in order: proposer slashings, attester slashings, then voluntary exits.
+// Pass the same set when packing each kind of operation, to not pack operations that become invalid
+// after processing the operations packed before them.
+type PackedValidators map[common.ValidatorIndex]struct{}
+
+func (p PackedValidators) Has(index common.ValidatorIndex) bool {
+	_, ok := p[index]
+	return ok
+}
+
+func (p PackedValidators) Add(index common.ValidatorIndex) {
+	p[index] = struct{}{}
+}
@@ -0,0 +1,99 @@
+package pool
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+// testSign signs the root with the key of the validator, as created by testAltairState.
+func testSign(t *testing.T, index common.ValidatorIndex, state common.BeaconState, dom common.BLSDomainType,
+	epoch common.Epoch, root common.Root) common.BLSSignature {
+	var key [32]byte
+	binary.BigEndian.PutUint64(key[24:], uint64(index)+1)
+	var sk blsu.SecretKey
+	if err := sk.Deserialize(&key); err != nil {
+		t.Fatal(err)
+	}
+	domain, err := common.GetDomain(state, dom, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigRoot := common.ComputeSigningRoot(root, domain)
+	return blsu.Sign(&sk, sigRoot[:]).Serialize()
+}
+
+func TestPackValid(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.SHARD_COMMITTEE_PERIOD = 0
+	ctx := context.Background()
+	state, epc := testAltairState(t, &spec, 64, 1)
+
+	proposerSlashing := func(index common.ValidatorIndex, signer common.ValidatorIndex) *phase0.ProposerSlashing {
+		var sl phase0.ProposerSlashing
+		for i, h := range []*common.SignedBeaconBlockHeader{&sl.SignedHeader1, &sl.SignedHeader2} {
+			h.Message = common.BeaconBlockHeader{Slot: 1, ProposerIndex: index, BodyRoot: common.Root{byte(i)}}
+			h.Signature = testSign(t, signer, state, common.DOMAIN_BEACON_PROPOSER, 0, h.Message.HashTreeRoot(tree.GetHashFn()))
+		}
+		return &sl
+	}
+	psp := NewProposerSlashingPool(&spec)
+	for _, sl := range []*phase0.ProposerSlashing{
+		proposerSlashing(5, 5),
+		proposerSlashing(6, 7), // bad signature
+	} {
+		if err := psp.AddProposerSlashing(ctx, sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exit := func(index common.ValidatorIndex, signer common.ValidatorIndex, epoch common.Epoch) *phase0.SignedVoluntaryExit {
+		ex := &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{Epoch: epoch, ValidatorIndex: index}}
+		ex.Signature = testSign(t, signer, state, common.DOMAIN_VOLUNTARY_EXIT, epoch, ex.Message.HashTreeRoot(tree.GetHashFn()))
+		return ex
+	}
+	vep := NewVoluntaryExitPool(&spec)
+	for _, ex := range []*phase0.SignedVoluntaryExit{
+		exit(5, 5, 0),   // slashed in the same block
+		exit(8, 8, 0),   // valid
+		exit(9, 10, 0),  // bad signature
+		exit(10, 10, 3), // future epoch
+	} {
+		if err := vep.AddVoluntaryExit(ctx, ex); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packed := make(PackedValidators)
+	slashings, err := psp.PackValid(epc, state, func(*phase0.ProposerSlashing) int { return 0 }, 16, packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slashings) != 1 || slashings[0].SignedHeader1.Message.ProposerIndex != 5 {
+		t.Fatalf("expected proposer slashing of validator 5, got %d slashings", len(slashings))
+	}
+	if len(psp.All()) != 1 {
+		t.Fatal("expected invalid proposer slashing to be pruned, and packed slashing to be kept")
+	}
+	exits, err := vep.PackValid(epc, state, func(*phase0.SignedVoluntaryExit) int { return 0 }, 16, packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exits) != 1 || exits[0].Message.ValidatorIndex != 8 {
+		t.Fatalf("expected exit of validator 8, got %d exits", len(exits))
+	}
+	if len(vep.All()) != 3 {
+		t.Fatal("expected only the invalid exit to be pruned")
+	}
+	if !packed.Has(5) || !packed.Has(8) || len(packed) != 2 {
+		t.Fatal("expected slashed and exited validators to be tracked")
+	}
+}
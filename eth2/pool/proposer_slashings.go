@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -13,15 +14,32 @@ type ProposerSlashingPool struct {
 	sync.RWMutex
 	spec      *common.Spec
 	slashings map[common.ValidatorIndex]*phase0.ProposerSlashing
+	limit     uint64
+	counters  PoolCounters
 }
 
 func NewProposerSlashingPool(spec *common.Spec) *ProposerSlashingPool {
 	return &ProposerSlashingPool{
 		spec:      spec,
 		slashings: make(map[common.ValidatorIndex]*phase0.ProposerSlashing),
+		limit:     DefaultMaxProposerSlashings,
 	}
 }
 
+// SetLimit changes the maximum number of slashings in the pool, zero means no limit.
+// When full, new slashings are rejected.
+func (psp *ProposerSlashingPool) SetLimit(limit uint64) {
+	psp.Lock()
+	defer psp.Unlock()
+	psp.limit = limit
+}
+
+func (psp *ProposerSlashingPool) Counters() PoolCounters {
+	psp.RLock()
+	defer psp.RUnlock()
+	return psp.counters
+}
+
 func (psp *ProposerSlashingPool) AddProposerSlashing(ctx context.Context, sl *phase0.ProposerSlashing) error {
 	psp.Lock()
 	defer psp.Unlock()
@@ -30,6 +48,10 @@ func (psp *ProposerSlashingPool) AddProposerSlashing(ctx context.Context, sl *ph
 	if _, ok := psp.slashings[key]; ok {
 		return fmt.Errorf("proposer %d is already getting slashed", key)
 	}
+	if psp.limit != 0 && uint64(len(psp.slashings)) >= psp.limit {
+		psp.counters.Rejected++
+		return PoolFullErr
+	}
 	psp.slashings[key] = sl
 	return nil
 }
@@ -47,6 +69,62 @@ func (psp *ProposerSlashingPool) All() []*phase0.ProposerSlashing {
 // Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
 // Slashings with negative rewards will not be packed.
 func (psp *ProposerSlashingPool) Pack(estReward func(sl *phase0.ProposerSlashing) int, n uint) []*phase0.ProposerSlashing {
-	// TODO
-	return nil
+	psp.Lock()
+	defer psp.Unlock()
+	keys := psp.ranked(estReward)
+	if uint(len(keys)) > n {
+		keys = keys[:n]
+	}
+	out := make([]*phase0.ProposerSlashing, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, psp.slashings[k])
+		delete(psp.slashings, k)
+	}
+	return out
+}
+
+// ranked returns the keys of the slashings with a non-negative estimated reward, best first.
+func (psp *ProposerSlashingPool) ranked(estReward func(sl *phase0.ProposerSlashing) int) []common.ValidatorIndex {
+	rewards := make(map[common.ValidatorIndex]int, len(psp.slashings))
+	keys := make([]common.ValidatorIndex, 0, len(psp.slashings))
+	for k, sl := range psp.slashings {
+		if r := estReward(sl); r >= 0 {
+			rewards[k] = r
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := rewards[keys[i]], rewards[keys[j]]; a != b {
+			return a > b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// PackValid packs up to n slashings that are valid against the state, ranked like Pack.
+// Slashings of validators in packed are skipped, the packed proposers are added to packed.
+// Invalid slashings are pruned from the pool. Packed slashings are kept,
+// these are pruned once the state includes them, as the proposer is not slashable anymore.
+func (psp *ProposerSlashingPool) PackValid(epc *common.EpochsContext, state common.BeaconState,
+	estReward func(sl *phase0.ProposerSlashing) int, n uint, packed PackedValidators) ([]*phase0.ProposerSlashing, error) {
+	psp.Lock()
+	defer psp.Unlock()
+	var out []*phase0.ProposerSlashing
+	for _, k := range psp.ranked(estReward) {
+		if uint(len(out)) >= n {
+			break
+		}
+		if packed.Has(k) {
+			continue
+		}
+		sl := psp.slashings[k]
+		if err := phase0.ValidateProposerSlashing(psp.spec, epc, state, sl); err != nil {
+			delete(psp.slashings, k)
+			continue
+		}
+		packed.Add(k)
+		out = append(out, sl)
+	}
+	return out, nil
 }
@@ -0,0 +1,370 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// SnapshotVersion is the version of the pools snapshot format, bumped on any change to the encoding.
+const SnapshotVersion uint8 = 1
+
+var snapshotMagic = [4]byte{'z', 'r', 'o', 'p'}
+
+// MaxSnapshotItems limits the number of items of a pool in a snapshot, to not allocate unbounded memory on bad input.
+const MaxSnapshotItems = 1 << 22
+
+// PoolAttestation is an attestation in a snapshot, with the committee to restore it with.
+type PoolAttestation struct {
+	Attestation phase0.Attestation
+	Committee   common.CommitteeIndices
+}
+
+func (a *PoolAttestation) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.Container(spec.Wrap(&a.Attestation), spec.Wrap(&a.Committee))
+}
+
+func (a *PoolAttestation) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Container(spec.Wrap(&a.Attestation), spec.Wrap(&a.Committee))
+}
+
+func (a *PoolAttestation) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(spec.Wrap(&a.Attestation), spec.Wrap(&a.Committee))
+}
+
+func (a *PoolAttestation) FixedLength(*common.Spec) uint64 {
+	return 0
+}
+
+func (a *PoolAttestation) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(spec.Wrap(&a.Attestation), spec.Wrap(&a.Committee))
+}
+
+// Export returns all attestations in the pool: the aggregates first, then the individual attestations.
+func (ap *AttestationPool) Export() []PoolAttestation {
+	ap.RLock()
+	defer ap.RUnlock()
+	var out []PoolAttestation
+	for dataRoot, agg := range ap.aggregate {
+		d, ok := ap.datas[dataRoot]
+		if !ok {
+			continue
+		}
+		for _, aggs := range [][]Aggregate{agg.Aggregates, agg.Extra} {
+			for _, a := range aggs {
+				out = append(out, PoolAttestation{
+					Attestation: phase0.Attestation{AggregationBits: a.Participants.Copy(), Data: d.Data, Signature: a.Sig},
+					Committee:   d.Committee,
+				})
+			}
+		}
+	}
+	for key, ref := range ap.individual {
+		d, ok := ap.datas[ref.DataRoot]
+		if !ok {
+			continue
+		}
+		for i, vi := range d.Committee {
+			if vi == key.Index {
+				bits := newAttestationBits(uint64(len(d.Committee)))
+				bits.SetBit(uint64(i), true)
+				out = append(out, PoolAttestation{
+					Attestation: phase0.Attestation{AggregationBits: bits, Data: d.Data, Signature: ref.Sig},
+					Committee:   d.Committee,
+				})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Import adds the attestations to the pool, attestations that the pool does not accept are skipped.
+// The pool may not accept attestations that were in the pool before, when imported in a different order.
+func (ap *AttestationPool) Import(ctx context.Context, atts []PoolAttestation) {
+	// larger aggregates first, these are most likely to have been accepted first.
+	sort.SliceStable(atts, func(i, j int) bool {
+		return atts[i].Attestation.AggregationBits.OnesCount() > atts[j].Attestation.AggregationBits.OnesCount()
+	})
+	for i := range atts {
+		_ = ap.AddAttestation(ctx, &atts[i].Attestation, atts[i].Committee)
+	}
+}
+
+// Export returns all slashings in the pool, ordered by root.
+func (asp *AttesterSlashingPool) Export() []phase0.AttesterSlashing {
+	asp.RLock()
+	defer asp.RUnlock()
+	keys := make([]common.Root, 0, len(asp.slashings))
+	for k := range asp.slashings {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	out := make([]phase0.AttesterSlashing, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *asp.slashings[k])
+	}
+	return out
+}
+
+// Import adds the slashings to the pool, slashings that are already in the pool are skipped.
+func (asp *AttesterSlashingPool) Import(ctx context.Context, slashings []phase0.AttesterSlashing) {
+	for i := range slashings {
+		_ = asp.AddAttesterSlashing(ctx, &slashings[i])
+	}
+}
+
+// Export returns all slashings in the pool, ordered by proposer index.
+func (psp *ProposerSlashingPool) Export() []phase0.ProposerSlashing {
+	psp.RLock()
+	defer psp.RUnlock()
+	keys := make([]common.ValidatorIndex, 0, len(psp.slashings))
+	for k := range psp.slashings {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	out := make([]phase0.ProposerSlashing, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *psp.slashings[k])
+	}
+	return out
+}
+
+// Import adds the slashings to the pool, slashings of proposers that are already in the pool are skipped.
+func (psp *ProposerSlashingPool) Import(ctx context.Context, slashings []phase0.ProposerSlashing) {
+	for i := range slashings {
+		_ = psp.AddProposerSlashing(ctx, &slashings[i])
+	}
+}
+
+// Export returns all exits in the pool, ordered by validator index.
+func (vep *VoluntaryExitPool) Export() []phase0.SignedVoluntaryExit {
+	vep.RLock()
+	defer vep.RUnlock()
+	keys := make([]common.ValidatorIndex, 0, len(vep.exits))
+	for k := range vep.exits {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	out := make([]phase0.SignedVoluntaryExit, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *vep.exits[k])
+	}
+	return out
+}
+
+// Import adds the exits to the pool, exits of validators that are already in the pool are skipped.
+func (vep *VoluntaryExitPool) Import(ctx context.Context, exits []phase0.SignedVoluntaryExit) {
+	for i := range exits {
+		_ = vep.AddVoluntaryExit(ctx, &exits[i])
+	}
+}
+
+// Export returns all changes in the pool, ordered by validator index.
+func (bp *BLSToExecutionChangePool) Export() []common.SignedBLSToExecutionChange {
+	bp.RLock()
+	defer bp.RUnlock()
+	keys := make([]common.ValidatorIndex, 0, len(bp.changes))
+	for k := range bp.changes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	out := make([]common.SignedBLSToExecutionChange, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *bp.changes[k])
+	}
+	return out
+}
+
+// Import adds the changes to the pool, changes of validators that are already in the pool are skipped.
+func (bp *BLSToExecutionChangePool) Import(ctx context.Context, changes []common.SignedBLSToExecutionChange) {
+	for i := range changes {
+		_ = bp.AddBLSToExecutionChange(ctx, &changes[i])
+	}
+}
+
+// snapshotList is an SSZ list of the items of a pool, for encoding.
+type snapshotList struct {
+	length    uint64
+	item      func(i uint64) codec.Serializable
+	fixedSize uint64
+}
+
+func (l *snapshotList) Serialize(w *codec.EncodingWriter) error {
+	return w.List(l.item, l.fixedSize, l.length)
+}
+
+func (l *snapshotList) ByteLength() (out uint64) {
+	for i := uint64(0); i < l.length; i++ {
+		out += l.item(i).ByteLength()
+		if l.fixedSize == 0 {
+			out += codec.OFFSET_SIZE
+		}
+	}
+	return out
+}
+
+func (l *snapshotList) FixedLength() uint64 {
+	return 0
+}
+
+// snapshotListDecoder is an SSZ list of the items of a pool, for decoding.
+type snapshotListDecoder struct {
+	add       func() codec.Deserializable
+	fixedSize uint64
+}
+
+func (l *snapshotListDecoder) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(l.add, l.fixedSize, MaxSnapshotItems)
+}
+
+func (l *snapshotListDecoder) FixedLength() uint64 {
+	return 0
+}
+
+// Pools groups the operation pools of a node, to snapshot and restore them together.
+// Any of the pools may be nil, these are skipped.
+type Pools struct {
+	Attestations          *AttestationPool
+	AttesterSlashings     *AttesterSlashingPool
+	ProposerSlashings     *ProposerSlashingPool
+	VoluntaryExits        *VoluntaryExitPool
+	BLSToExecutionChanges *BLSToExecutionChangePool
+}
+
+// Serialize writes a snapshot of the pools: a magic and version byte,
+// followed by an SSZ container with a list of items for every pool.
+// The sync committee pool is not included, its contents are only relevant for a few slots.
+func (p *Pools) Serialize(spec *common.Spec, w io.Writer) error {
+	var (
+		atts      []PoolAttestation
+		attSlash  []phase0.AttesterSlashing
+		propSlash []phase0.ProposerSlashing
+		exits     []phase0.SignedVoluntaryExit
+		changes   []common.SignedBLSToExecutionChange
+	)
+	if p.Attestations != nil {
+		atts = p.Attestations.Export()
+	}
+	if p.AttesterSlashings != nil {
+		attSlash = p.AttesterSlashings.Export()
+	}
+	if p.ProposerSlashings != nil {
+		propSlash = p.ProposerSlashings.Export()
+	}
+	if p.VoluntaryExits != nil {
+		exits = p.VoluntaryExits.Export()
+	}
+	if p.BLSToExecutionChanges != nil {
+		changes = p.BLSToExecutionChanges.Export()
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(snapshotMagic[:]); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(SnapshotVersion); err != nil {
+		return err
+	}
+	err := codec.NewEncodingWriter(bw).Container(
+		&snapshotList{length: uint64(len(atts)), item: func(i uint64) codec.Serializable {
+			return spec.Wrap(&atts[i])
+		}},
+		&snapshotList{length: uint64(len(attSlash)), item: func(i uint64) codec.Serializable {
+			return spec.Wrap(&attSlash[i])
+		}},
+		&snapshotList{length: uint64(len(propSlash)), item: func(i uint64) codec.Serializable {
+			return &propSlash[i]
+		}, fixedSize: phase0.ProposerSlashingType.TypeByteLength()},
+		&snapshotList{length: uint64(len(exits)), item: func(i uint64) codec.Serializable {
+			return &exits[i]
+		}, fixedSize: phase0.SignedVoluntaryExitType.TypeByteLength()},
+		&snapshotList{length: uint64(len(changes)), item: func(i uint64) codec.Serializable {
+			return &changes[i]
+		}, fixedSize: common.SignedBLSToExecutionChangeType.TypeByteLength()},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to encode pools snapshot: %v", err)
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Pools.Serialize, and imports the contents into the pools.
+// The contents of pools that are nil are skipped.
+func (p *Pools) Restore(ctx context.Context, spec *common.Spec, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(snapshotMagic)+1 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic[:]) {
+		return errors.New("not a pools snapshot")
+	}
+	if version := data[len(snapshotMagic)]; version != SnapshotVersion {
+		return fmt.Errorf("unsupported pools snapshot version: %d", version)
+	}
+	data = data[len(snapshotMagic)+1:]
+	var (
+		atts      []PoolAttestation
+		attSlash  []phase0.AttesterSlashing
+		propSlash []phase0.ProposerSlashing
+		exits     []phase0.SignedVoluntaryExit
+		changes   []common.SignedBLSToExecutionChange
+	)
+	err = codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))).Container(
+		&snapshotListDecoder{add: func() codec.Deserializable {
+			atts = append(atts, PoolAttestation{})
+			return spec.Wrap(&atts[len(atts)-1])
+		}},
+		&snapshotListDecoder{add: func() codec.Deserializable {
+			attSlash = append(attSlash, phase0.AttesterSlashing{})
+			return spec.Wrap(&attSlash[len(attSlash)-1])
+		}},
+		&snapshotListDecoder{add: func() codec.Deserializable {
+			propSlash = append(propSlash, phase0.ProposerSlashing{})
+			return &propSlash[len(propSlash)-1]
+		}, fixedSize: phase0.ProposerSlashingType.TypeByteLength()},
+		&snapshotListDecoder{add: func() codec.Deserializable {
+			exits = append(exits, phase0.SignedVoluntaryExit{})
+			return &exits[len(exits)-1]
+		}, fixedSize: phase0.SignedVoluntaryExitType.TypeByteLength()},
+		&snapshotListDecoder{add: func() codec.Deserializable {
+			changes = append(changes, common.SignedBLSToExecutionChange{})
+			return &changes[len(changes)-1]
+		}, fixedSize: common.SignedBLSToExecutionChangeType.TypeByteLength()},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to decode pools snapshot: %v", err)
+	}
+	if p.Attestations != nil {
+		p.Attestations.Import(ctx, atts)
+	}
+	if p.AttesterSlashings != nil {
+		p.AttesterSlashings.Import(ctx, attSlash)
+	}
+	if p.ProposerSlashings != nil {
+		p.ProposerSlashings.Import(ctx, propSlash)
+	}
+	if p.VoluntaryExits != nil {
+		p.VoluntaryExits.Import(ctx, exits)
+	}
+	if p.BLSToExecutionChanges != nil {
+		p.BLSToExecutionChanges.Import(ctx, changes)
+	}
+	return nil
+}
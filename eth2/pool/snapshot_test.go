@@ -0,0 +1,101 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestPoolsSnapshot(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	pools := &Pools{
+		Attestations:          NewAttestationPool(spec),
+		AttesterSlashings:     NewAttesterSlashingPool(spec),
+		ProposerSlashings:     NewProposerSlashingPool(spec),
+		VoluntaryExits:        NewVoluntaryExitPool(spec),
+		BLSToExecutionChanges: NewBLSToExecutionChangePool(spec),
+	}
+	data := phase0.AttestationData{Slot: 16, BeaconBlockRoot: common.Root{3},
+		Source: common.Checkpoint{Epoch: 1, Root: common.Root{1}}, Target: common.Checkpoint{Epoch: 2, Root: common.Root{2}}}
+	committee := common.CommitteeIndices{0, 1, 2, 3, 4, 5, 6, 7}
+	for _, att := range []*phase0.Attestation{
+		testAttestation(data, 8, 0, 1, 2),
+		testAttestation(data, 8, 2, 3),
+		testAttestation(data, 8, 6),
+	} {
+		if err := pools.Attestations.AddAttestation(ctx, att, committee); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := data
+	other.BeaconBlockRoot = common.Root{4}
+	if err := pools.AttesterSlashings.AddAttesterSlashing(ctx, &phase0.AttesterSlashing{
+		Attestation1: phase0.IndexedAttestation{AttestingIndices: common.CommitteeIndices{1, 2}, Data: data},
+		Attestation2: phase0.IndexedAttestation{AttestingIndices: common.CommitteeIndices{2}, Data: other},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var propSlashing phase0.ProposerSlashing
+	propSlashing.SignedHeader1.Message = common.BeaconBlockHeader{Slot: 3, ProposerIndex: 5, BodyRoot: common.Root{1}}
+	propSlashing.SignedHeader2.Message = common.BeaconBlockHeader{Slot: 3, ProposerIndex: 5, BodyRoot: common.Root{2}}
+	if err := pools.ProposerSlashings.AddProposerSlashing(ctx, &propSlashing); err != nil {
+		t.Fatal(err)
+	}
+	for i := common.ValidatorIndex(10); i < 13; i++ {
+		if err := pools.VoluntaryExits.AddVoluntaryExit(ctx, &phase0.SignedVoluntaryExit{
+			Message: phase0.VoluntaryExit{Epoch: 1, ValidatorIndex: i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pools.BLSToExecutionChanges.AddBLSToExecutionChange(ctx, &common.SignedBLSToExecutionChange{
+		BLSToExecutionChange: common.BLSToExecutionChange{ValidatorIndex: 20}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pools.Serialize(spec, &buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := &Pools{
+		Attestations:          NewAttestationPool(spec),
+		AttesterSlashings:     NewAttesterSlashingPool(spec),
+		ProposerSlashings:     NewProposerSlashingPool(spec),
+		VoluntaryExits:        NewVoluntaryExitPool(spec),
+		BLSToExecutionChanges: NewBLSToExecutionChangePool(spec),
+	}
+	if err := restored.Restore(ctx, spec, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Attestations.Export(); len(got) != 3 {
+		t.Fatalf("expected 3 attestations, got %d", len(got))
+	}
+	if got := restored.AttesterSlashings.Export(); len(got) != 1 || got[0].Attestation2.Data != other {
+		t.Fatal("attester slashing was not restored")
+	}
+	if got := restored.ProposerSlashings.Export(); len(got) != 1 || got[0] != propSlashing {
+		t.Fatal("proposer slashing was not restored")
+	}
+	if got := restored.VoluntaryExits.Export(); len(got) != 3 || got[2].Message.ValidatorIndex != 12 {
+		t.Fatal("exits were not restored")
+	}
+	if got := restored.BLSToExecutionChanges.Export(); len(got) != 1 || got[0].BLSToExecutionChange.ValidatorIndex != 20 {
+		t.Fatal("bls to execution change was not restored")
+	}
+	if got := restored.Attestations.Search(); len(got) != 2 {
+		t.Fatalf("expected 2 aggregates, got %d", len(got))
+	}
+
+	// bad input
+	if err := restored.Restore(ctx, spec, bytes.NewReader([]byte("zrfc\x01"))); err == nil {
+		t.Fatal("expected error for other snapshot type")
+	}
+	data2 := append([]byte(nil), buf.Bytes()...)
+	if err := restored.Restore(ctx, spec, bytes.NewReader(data2[:len(data2)-10])); err == nil {
+		t.Fatal("expected error for truncated snapshot")
+	}
+}
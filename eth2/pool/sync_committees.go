@@ -3,10 +3,14 @@ package pool
 import (
 	"context"
 	"fmt"
+	"math/bits"
+	"sort"
 	"sync"
 
+	blsu "github.com/protolambda/bls12-381-util"
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/view"
 )
 
 // beacon root -> subnet -> contributions
@@ -17,8 +21,8 @@ type SyncCommitteeMessages map[common.ValidatorIndex]*altair.SyncCommitteeMessag
 func (msgs SyncCommitteeMessages) Select(root common.Root, members []common.ValidatorIndex) []*altair.SyncCommitteeMessage {
 	out := make([]*altair.SyncCommitteeMessage, 0, len(members))
 	for _, vi := range members {
-		msg := msgs[vi]
-		if msg.BeaconBlockRoot == root {
+		msg, ok := msgs[vi]
+		if ok && msg.BeaconBlockRoot == root {
 			out = append(out, msg)
 		}
 	}
@@ -34,11 +38,13 @@ type SubnetContrib struct {
 }
 
 // SyncCommitteePool is a very short lived buffer:
-// - The sync committee messages of the previous, current and next slot are buffered
-// - The sync committee contributions (subnet aggregates) of the previous, current and next slot are buffered
-// - As soon as a slot is done, Reset(slot) should be called to transition to a new slot, rotating out buffers.
-// - Nothing is aggregated ahead of time; packing work is avoided if we are not selected as aggregator
-// - At any time the validator can run PackAggregate and PackContribution for the approximate current slot (previous/current/next slot accepted) and beacon block root.
+//   - The sync committee messages of the previous, current and next slot are buffered
+//   - The sync committee contributions (subnet aggregates) of the previous, current and next slot are buffered
+//   - As soon as a slot is done, Reset(slot) should be called to transition to a new slot, rotating out buffers.
+//   - Nothing is aggregated ahead of time; packing work is avoided if we are not selected as aggregator
+//   - At any time the validator can run PackAggregate and PackContribution for the approximate current slot (previous/current/next slot accepted) and beacon block root.
+//   - Packing merges the non-overlapping contributions with the most participants first,
+//     and fills the remaining gaps with individual messages.
 type SyncCommitteePool struct {
 	sync.Mutex
 
@@ -56,12 +62,31 @@ type SyncCommitteePool struct {
 }
 
 func NewSyncCommitteePool(spec *common.Spec) *SyncCommitteePool {
+	// the slot before genesis, so that Reset(0) rotates forward.
 	return &SyncCommitteePool{
-		spec:        spec,
-		currentSlot: ^common.Slot(0),
+		spec:            spec,
+		currentSlot:     ^common.Slot(0),
+		prevContribs:    make(SyncCommitteeContributions),
+		currentContribs: make(SyncCommitteeContributions),
+		nextContribs:    make(SyncCommitteeContributions),
+		prevMsgs:        make(SyncCommitteeMessages, spec.SYNC_COMMITTEE_SIZE),
+		currentMsgs:     make(SyncCommitteeMessages, spec.SYNC_COMMITTEE_SIZE),
+		nextMsgs:        make(SyncCommitteeMessages, spec.SYNC_COMMITTEE_SIZE),
 	}
 }
 
+// buffers returns the contributions and messages buffered for the given slot.
+func (sp *SyncCommitteePool) buffers(slot common.Slot) (SyncCommitteeContributions, SyncCommitteeMessages, error) {
+	if sp.currentSlot == slot+1 {
+		return sp.prevContribs, sp.prevMsgs, nil
+	} else if sp.currentSlot == slot {
+		return sp.currentContribs, sp.currentMsgs, nil
+	} else if sp.currentSlot+1 == slot {
+		return sp.nextContribs, sp.nextMsgs, nil
+	}
+	return nil, nil, fmt.Errorf("current sync committee pool is at slot %d, cannot pack for slot %d", sp.currentSlot, slot)
+}
+
 func (sp *SyncCommitteePool) AddSyncCommitteeContribution(ctx context.Context, contrib *altair.SyncCommitteeContribution) error {
 	sp.Lock()
 	defer sp.Unlock()
@@ -102,21 +127,163 @@ func (sp *SyncCommitteePool) AddSyncCommitteeMessage(ctx context.Context, msg *a
 	return nil
 }
 
+// subnetContribution merges the buffered contributions and messages for the subnet into the densest contribution.
+// The subcommittee members are the validators of the subnet, in sync committee order.
+// The returned signatures still have to be aggregated, and are empty if there is nothing to pack.
+func (sp *SyncCommitteePool) subnetContribution(contribs SyncCommitteeContributions, msgs SyncCommitteeMessages,
+	beaconBlockRoot common.Root, subnet uint64, subComm []common.ValidatorIndex) (altair.SyncCommitteeSubnetBits, []*blsu.Signature, error) {
+	size := uint64(sp.spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	if uint64(len(subComm)) != size {
+		return nil, nil, fmt.Errorf("expected %d subcommittee members, got %d", size, len(subComm))
+	}
+	byteLen := int((size + 7) / 8)
+	var parts []*SubnetContrib
+	for _, c := range contribs[beaconBlockRoot][subnet] {
+		if len(c.AggregationBits) == byteLen {
+			parts = append(parts, c)
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return bitsCount(parts[i].AggregationBits) > bitsCount(parts[j].AggregationBits)
+	})
+
+	bits := make(altair.SyncCommitteeSubnetBits, byteLen)
+	var sigs []*blsu.Signature
+	addSig := func(sig *common.BLSSignature) error {
+		s, err := sig.Signature()
+		if err != nil {
+			return fmt.Errorf("invalid signature in pool: %v", err)
+		}
+		sigs = append(sigs, s)
+		return nil
+	}
+	for _, c := range parts {
+		overlap := false
+		for i := range bits {
+			if bits[i]&c.AggregationBits[i] != 0 {
+				overlap = true
+				break
+			}
+		}
+		if overlap {
+			continue
+		}
+		if err := addSig(&c.Signature); err != nil {
+			return nil, nil, err
+		}
+		for i := range bits {
+			bits[i] |= c.AggregationBits[i]
+		}
+	}
+	for i, vi := range subComm {
+		if bits.GetBit(uint64(i)) {
+			continue
+		}
+		// a validator may be in the subcommittee multiple times, its message then counts for each position.
+		if msg, ok := msgs[vi]; ok && msg.BeaconBlockRoot == beaconBlockRoot {
+			if err := addSig(&msg.Signature); err != nil {
+				return nil, nil, err
+			}
+			bits.SetBit(uint64(i), true)
+		}
+	}
+	return bits, sigs, nil
+}
+
+// bitsCount counts the participants in a bitvector, unlike OnesCount of bitlists there is no delimiter bit.
+func bitsCount(b []byte) (n int) {
+	for _, v := range b {
+		n += bits.OnesCount8(v)
+	}
+	return n
+}
+
+// PackContribution aggregates the best contribution for the subnet from the buffered contributions and messages.
+// The subcommittee members are the validators of the subnet, in sync committee order.
 func (sp *SyncCommitteePool) PackContribution(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, subnet uint64, subComm []common.ValidatorIndex) (*altair.SyncCommitteeContribution, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select messages & aggregate a contribution
-	return nil, nil
+	if subnet >= common.SYNC_COMMITTEE_SUBNET_COUNT {
+		return nil, fmt.Errorf("invalid sync committee subnet %d", subnet)
+	}
+	contribs, msgs, err := sp.buffers(slot)
+	if err != nil {
+		return nil, err
+	}
+	bits, sigs, err := sp.subnetContribution(contribs, msgs, beaconBlockRoot, subnet, subComm)
+	if err != nil {
+		return nil, err
+	}
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("no sync committee messages to aggregate for slot %d, root %s, subnet %d", slot, beaconBlockRoot, subnet)
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate signatures: %v", err)
+	}
+	return &altair.SyncCommitteeContribution{
+		Slot:              slot,
+		BeaconBlockRoot:   beaconBlockRoot,
+		SubcommitteeIndex: view.Uint64View(subnet),
+		AggregationBits:   bits,
+		Signature:         sig.Serialize(),
+	}, nil
 }
 
+// PackAggregate aggregates the best sync aggregate for block production,
+// by combining the best contribution of each subnet. The sync committee is the full committee, in order.
+// If there is nothing to aggregate, an empty aggregate with the point-at-infinity signature is returned.
 func (sp *SyncCommitteePool) PackAggregate(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, syncCommittee []common.ValidatorIndex) (*altair.SyncAggregate, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select complimentary subnet contributions, fill gaps with any individual messages, and return it
-	return nil, nil
+	if uint64(len(syncCommittee)) != uint64(sp.spec.SYNC_COMMITTEE_SIZE) {
+		return nil, fmt.Errorf("expected %d sync committee members, got %d", sp.spec.SYNC_COMMITTEE_SIZE, len(syncCommittee))
+	}
+	contribs, msgs, err := sp.buffers(slot)
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(sp.spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	out := &altair.SyncAggregate{
+		SyncCommitteeBits: make(altair.SyncCommitteeBits, (uint64(sp.spec.SYNC_COMMITTEE_SIZE)+7)/8),
+	}
+	var sigs []*blsu.Signature
+	for subnet := uint64(0); subnet < common.SYNC_COMMITTEE_SUBNET_COUNT; subnet++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		subComm := syncCommittee[subnet*size : (subnet+1)*size]
+		bits, subSigs, err := sp.subnetContribution(contribs, msgs, beaconBlockRoot, subnet, subComm)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < size; i++ {
+			if bits.GetBit(i) {
+				out.SyncCommitteeBits.SetBit(subnet*size+i, true)
+			}
+		}
+		sigs = append(sigs, subSigs...)
+	}
+	if len(sigs) == 0 {
+		// the serialized point at infinity, valid for an aggregate without participants.
+		out.SyncCommitteeSignature = common.BLSSignature{0: 0xc0}
+		return out, nil
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate signatures: %v", err)
+	}
+	out.SyncCommitteeSignature = sig.Serialize()
+	return out, nil
 }
 
 func (sp *SyncCommitteePool) Reset(slot common.Slot) {
+	sp.Lock()
+	defer sp.Unlock()
+	sp.reset(slot)
+}
+
+func (sp *SyncCommitteePool) reset(slot common.Slot) {
 	if sp.currentSlot == slot+1 {
 		sp.nextMsgs = sp.currentMsgs
 		sp.currentMsgs = sp.prevMsgs
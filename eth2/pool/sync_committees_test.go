@@ -0,0 +1,161 @@
+package pool
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestSyncCommitteePoolPack(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	root := common.Root{0xaa}
+	slot := common.Slot(10)
+
+	keys := make([]*blsu.SecretKey, 20)
+	pubs := make([]*blsu.Pubkey, 20)
+	for i := range keys {
+		var key [32]byte
+		binary.BigEndian.PutUint64(key[24:], uint64(i)+1)
+		var sk blsu.SecretKey
+		if err := sk.Deserialize(&key); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], pubs[i] = &sk, pub
+	}
+	// validators 0 to 11 are in the committee twice
+	committee := make([]common.ValidatorIndex, spec.SYNC_COMMITTEE_SIZE)
+	for i := range committee {
+		committee[i] = common.ValidatorIndex(i % 20)
+	}
+	size := uint64(spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(slot)
+	for i := common.ValidatorIndex(0); i < 7; i++ {
+		r := root
+		if i == 6 {
+			r = common.Root{0xbb}
+		}
+		msg := &altair.SyncCommitteeMessage{Slot: slot, BeaconBlockRoot: r, ValidatorIndex: i,
+			Signature: blsu.Sign(keys[i], r[:]).Serialize()}
+		if err := sp.AddSyncCommitteeMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contrib := func(positions ...uint64) *altair.SyncCommitteeContribution {
+		bits := make(altair.SyncCommitteeSubnetBits, (size+7)/8)
+		sigs := make([]*blsu.Signature, 0, len(positions))
+		for _, i := range positions {
+			bits.SetBit(i, true)
+			sigs = append(sigs, blsu.Sign(keys[committee[i]], root[:]))
+		}
+		sig, err := blsu.Aggregate(sigs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &altair.SyncCommitteeContribution{Slot: slot, BeaconBlockRoot: root, AggregationBits: bits, Signature: sig.Serialize()}
+	}
+	for _, c := range []*altair.SyncCommitteeContribution{contrib(0, 1, 2), contrib(2, 3), contrib(4, 5, 6, 7)} {
+		if err := sp.AddSyncCommitteeContribution(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.AddSyncCommitteeMessage(ctx, &altair.SyncCommitteeMessage{Slot: slot + 2}); err == nil {
+		t.Fatal("expected message outside of the slot range to be rejected")
+	}
+
+	verify := func(bits func(i uint64) bool, count uint64, sig common.BLSSignature) {
+		t.Helper()
+		var participants []*blsu.Pubkey
+		for i := uint64(0); i < count; i++ {
+			if bits(i) {
+				participants = append(participants, pubs[committee[i]])
+			}
+		}
+		s, err := sig.Signature()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !blsu.Eth2FastAggregateVerify(participants, root[:], s) {
+			t.Fatal("invalid aggregate signature")
+		}
+	}
+
+	c, err := sp.PackContribution(ctx, slot, root, 0, committee[:size])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitsCount(c.AggregationBits) != 8 || c.Slot != slot || c.BeaconBlockRoot != root {
+		t.Fatalf("expected full contribution, got %s", c.AggregationBits)
+	}
+	verify(c.AggregationBits.GetBit, size, c.Signature)
+	if _, err := sp.PackContribution(ctx, slot, root, 1, committee[size:2*size]); err == nil {
+		t.Fatal("expected error for subnet without messages")
+	}
+
+	agg, err := sp.PackAggregate(ctx, slot, root, committee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// subnet 0 is full, subnet 2 has validators 0 to 3, subnet 3 has validators 4 and 5
+	if n := bitsCount(agg.SyncCommitteeBits); n != 8+4+2 {
+		t.Fatalf("expected 14 participants, got %d", n)
+	}
+	for _, i := range []uint64{20, 21, 22, 23, 24, 25} {
+		if !agg.SyncCommitteeBits.GetBit(i) {
+			t.Fatalf("expected participant at %d", i)
+		}
+	}
+	verify(agg.SyncCommitteeBits.GetBit, uint64(spec.SYNC_COMMITTEE_SIZE), agg.SyncCommitteeSignature)
+
+	empty, err := sp.PackAggregate(ctx, slot, common.Root{0xcc}, committee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bitsCount(empty.SyncCommitteeBits) != 0 {
+		t.Fatal("expected empty aggregate")
+	}
+	verify(empty.SyncCommitteeBits.GetBit, uint64(spec.SYNC_COMMITTEE_SIZE), empty.SyncCommitteeSignature)
+
+	// after moving on, the slot is the previous slot, then it is rotated out
+	sp.Reset(slot + 1)
+	if _, err := sp.PackContribution(ctx, slot, root, 0, committee[:size]); err != nil {
+		t.Fatal(err)
+	}
+	sp.Reset(slot + 2)
+	if _, err := sp.PackAggregate(ctx, slot, root, committee); err == nil {
+		t.Fatal("expected error for rotated out slot")
+	}
+}
+
+func TestSyncCommitteePoolGenesis(t *testing.T) {
+	spec := configs.Minimal
+	ctx := context.Background()
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(0)
+	msg := &altair.SyncCommitteeMessage{Slot: 0, BeaconBlockRoot: common.Root{0xaa}, ValidatorIndex: 3}
+	if err := sp.AddSyncCommitteeMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	bits := make(altair.SyncCommitteeSubnetBits, (uint64(spec.SYNC_COMMITTEE_SIZE)/common.SYNC_COMMITTEE_SUBNET_COUNT+7)/8)
+	bits.SetBit(0, true)
+	contrib := &altair.SyncCommitteeContribution{Slot: 1, BeaconBlockRoot: common.Root{0xaa}, AggregationBits: bits}
+	if err := sp.AddSyncCommitteeContribution(ctx, contrib); err != nil {
+		t.Fatal(err)
+	}
+	sp.Reset(1)
+	if err := sp.AddSyncCommitteeMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,25 +3,44 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 )
 
 type VoluntaryExitPool struct {
 	sync.RWMutex
-	spec  *common.Spec
-	exits map[common.ValidatorIndex]*phase0.SignedVoluntaryExit
+	spec     *common.Spec
+	exits    map[common.ValidatorIndex]*phase0.SignedVoluntaryExit
+	limit    uint64
+	counters PoolCounters
 }
 
 func NewVoluntaryExitPool(spec *common.Spec) *VoluntaryExitPool {
 	return &VoluntaryExitPool{
 		spec:  spec,
 		exits: make(map[common.ValidatorIndex]*phase0.SignedVoluntaryExit),
+		limit: DefaultMaxVoluntaryExits,
 	}
 }
 
+// SetLimit changes the maximum number of exits in the pool, zero means no limit.
+// When full, new exits are rejected.
+func (vep *VoluntaryExitPool) SetLimit(limit uint64) {
+	vep.Lock()
+	defer vep.Unlock()
+	vep.limit = limit
+}
+
+func (vep *VoluntaryExitPool) Counters() PoolCounters {
+	vep.RLock()
+	defer vep.RUnlock()
+	return vep.counters
+}
+
 func (vep *VoluntaryExitPool) AddVoluntaryExit(ctx context.Context, exit *phase0.SignedVoluntaryExit) error {
 	vep.Lock()
 	defer vep.Unlock()
@@ -29,6 +48,10 @@ func (vep *VoluntaryExitPool) AddVoluntaryExit(ctx context.Context, exit *phase0
 	if _, ok := vep.exits[key]; ok {
 		return fmt.Errorf("already have exit for validator %d", key)
 	}
+	if vep.limit != 0 && uint64(len(vep.exits)) >= vep.limit {
+		vep.counters.Rejected++
+		return PoolFullErr
+	}
 	vep.exits[key] = exit
 	return nil
 }
@@ -46,6 +69,103 @@ func (vep *VoluntaryExitPool) All() []*phase0.SignedVoluntaryExit {
 // Pack n exits, removes the exits from the pool. A ranking function is used to pick the best exits.
 // Exits with negative rank function outputs will not be packed.
 func (vep *VoluntaryExitPool) Pack(rank func(sl *phase0.SignedVoluntaryExit) int, n uint) []*phase0.SignedVoluntaryExit {
-	// TODO
-	return nil
+	vep.Lock()
+	defer vep.Unlock()
+	keys := vep.ranked(rank)
+	if uint(len(keys)) > n {
+		keys = keys[:n]
+	}
+	out := make([]*phase0.SignedVoluntaryExit, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, vep.exits[k])
+		delete(vep.exits, k)
+	}
+	return out
+}
+
+// ranked returns the keys of the exits with a non-negative rank, best first.
+func (vep *VoluntaryExitPool) ranked(rank func(sl *phase0.SignedVoluntaryExit) int) []common.ValidatorIndex {
+	ranks := make(map[common.ValidatorIndex]int, len(vep.exits))
+	keys := make([]common.ValidatorIndex, 0, len(vep.exits))
+	for k, ex := range vep.exits {
+		if r := rank(ex); r >= 0 {
+			ranks[k] = r
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := ranks[keys[i]], ranks[keys[j]]; a != b {
+			return a > b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// PackValid packs up to n exits that are valid against the state, ranked like Pack.
+// Exits of validators in packed, e.g. validators slashed in the same block, are skipped,
+// the packed exits are added to packed.
+// Invalid exits are pruned from the pool, unless they may become valid later: exits with a future epoch,
+// and exits of validators that have not been active for SHARD_COMMITTEE_PERIOD yet are kept.
+// Packed exits are kept, these are pruned once the state includes them, as the validator already exited.
+func (vep *VoluntaryExitPool) PackValid(epc *common.EpochsContext, state common.BeaconState,
+	rank func(sl *phase0.SignedVoluntaryExit) int, n uint, packed PackedValidators) ([]*phase0.SignedVoluntaryExit, error) {
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	currentEpoch := epc.CurrentEpoch.Epoch
+	vep.Lock()
+	defer vep.Unlock()
+	var out []*phase0.SignedVoluntaryExit
+	for _, k := range vep.ranked(rank) {
+		if uint(len(out)) >= n {
+			break
+		}
+		if packed.Has(k) {
+			continue
+		}
+		exit := vep.exits[k]
+		if pending, err := exitPending(vep.spec, vals, currentEpoch, exit); err != nil {
+			return nil, err
+		} else if pending {
+			continue
+		}
+		var err error
+		if currentEpoch >= vep.spec.DENEB_FORK_EPOCH {
+			err = deneb.ValidateVoluntaryExit(vep.spec, epc, state, exit)
+		} else {
+			err = phase0.ValidateVoluntaryExit(vep.spec, epc, state, exit)
+		}
+		if err != nil {
+			delete(vep.exits, k)
+			continue
+		}
+		packed.Add(k)
+		out = append(out, exit)
+	}
+	return out, nil
+}
+
+// exitPending checks if the exit cannot be included yet, but may be included in a later epoch.
+func exitPending(spec *common.Spec, vals common.ValidatorRegistry, currentEpoch common.Epoch, exit *phase0.SignedVoluntaryExit) (bool, error) {
+	if currentEpoch < exit.Message.Epoch {
+		return true, nil
+	}
+	if valid, err := vals.IsValidIndex(exit.Message.ValidatorIndex); err != nil || !valid {
+		return false, err
+	}
+	validator, err := vals.Validator(exit.Message.ValidatorIndex)
+	if err != nil {
+		return false, err
+	}
+	activationEpoch, err := validator.ActivationEpoch()
+	if err != nil {
+		return false, err
+	}
+	// validators that are not active yet may still become active, and exit later.
+	if activationEpoch > currentEpoch {
+		return true, nil
+	}
+	return currentEpoch < activationEpoch+spec.SHARD_COMMITTEE_PERIOD, nil
 }
@@ -0,0 +1,444 @@
+package slasher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/db"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Storage keys are prefixed with a single byte, to separate the different kinds of data.
+// Keys start with the epoch, chunk or slot after the prefix, so old data can be pruned with range deletes.
+const (
+	// meta: lowest retained epoch, earliest stored epoch, next attestation id
+	prefixMeta byte = 'm'
+	// chunk, validator -> min spans of the epochs in the chunk
+	prefixMinSpan byte = 'n'
+	// chunk, validator -> max spans of the epochs in the chunk
+	prefixMaxSpan byte = 'x'
+	// target epoch, validator -> id of the indexed attestation
+	prefixRecord byte = 'a'
+	// target epoch, attestation id -> attestation data root, SSZ encoded indexed attestation
+	prefixAttestation byte = 'i'
+	// target epoch, indexed attestation root -> attestation id
+	prefixAttestationID byte = 'r'
+	// slot -> SSZ encoded signed headers of the slot
+	prefixHeaders byte = 'p'
+)
+
+// MaxHistoryLength is the longest history that can be retained, limited by the size of the stored spans.
+const MaxHistoryLength = maxDistance
+
+func recordKey(target common.Epoch, index common.ValidatorIndex) []byte {
+	key := make([]byte, 1+8+8)
+	key[0] = prefixRecord
+	binary.BigEndian.PutUint64(key[1:], uint64(target))
+	binary.BigEndian.PutUint64(key[9:], uint64(index))
+	return key
+}
+
+func attestationKey(target common.Epoch, id uint64) []byte {
+	key := make([]byte, 1+8+8)
+	key[0] = prefixAttestation
+	binary.BigEndian.PutUint64(key[1:], uint64(target))
+	binary.BigEndian.PutUint64(key[9:], id)
+	return key
+}
+
+func attestationIDKey(target common.Epoch, root common.Root) []byte {
+	key := make([]byte, 1+8+32)
+	key[0] = prefixAttestationID
+	binary.BigEndian.PutUint64(key[1:], uint64(target))
+	copy(key[9:], root[:])
+	return key
+}
+
+func numKey(prefix byte, v uint64) []byte {
+	key := make([]byte, 1+8)
+	key[0] = prefix
+	binary.BigEndian.PutUint64(key[1:], v)
+	return key
+}
+
+// Slasher indexes attestations and block headers over a long history, and detects slashable offenses:
+// double votes, surround votes, and double proposals.
+// Surround votes are detected with min-max spans per validator, as described in spanChunks.
+//
+// The slasher does not verify signatures: only verified attestations and headers should be indexed,
+// the slashings it returns can then be added to the slashing pools directly.
+type Slasher struct {
+	mu    sync.Mutex
+	spec  *common.Spec
+	store db.KeyValueStore
+	// number of epochs to retain
+	history common.Epoch
+	// everything before this epoch is pruned
+	lowest common.Epoch
+	// the earliest epoch anything is stored for, FAR_FUTURE_EPOCH if nothing is stored
+	earliest common.Epoch
+	// the id of the next stored attestation, the per-validator records refer to attestations by id
+	nextID uint64
+}
+
+// NewSlasher opens the slasher stored in the given store, or starts a new one if the store is empty.
+// The history is the number of epochs before the current epoch to retain.
+func NewSlasher(spec *common.Spec, store db.KeyValueStore, history common.Epoch) (*Slasher, error) {
+	if history == 0 || history > MaxHistoryLength {
+		return nil, fmt.Errorf("invalid slasher history length: %d", history)
+	}
+	s := &Slasher{
+		spec:     spec,
+		store:    store,
+		history:  history,
+		earliest: common.FAR_FUTURE_EPOCH,
+	}
+	meta, ok, err := store.Get([]byte{prefixMeta})
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if len(meta) != 8*3 {
+			return nil, fmt.Errorf("invalid slasher meta data: %x", meta)
+		}
+		s.lowest = common.Epoch(binary.LittleEndian.Uint64(meta[0:8]))
+		s.earliest = common.Epoch(binary.LittleEndian.Uint64(meta[8:16]))
+		s.nextID = binary.LittleEndian.Uint64(meta[16:24])
+	}
+	return s, nil
+}
+
+func (s *Slasher) putMeta() error {
+	var meta [8 * 3]byte
+	binary.LittleEndian.PutUint64(meta[0:8], uint64(s.lowest))
+	binary.LittleEndian.PutUint64(meta[8:16], uint64(s.earliest))
+	binary.LittleEndian.PutUint64(meta[16:24], s.nextID)
+	return s.store.Put([]byte{prefixMeta}, meta[:])
+}
+
+// record returns the id of the attestation the validator voted for with the target epoch.
+func (s *Slasher) record(index common.ValidatorIndex, target common.Epoch) (id uint64, ok bool, err error) {
+	data, ok, err := s.store.Get(recordKey(target, index))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, fmt.Errorf("invalid attestation record of validator %d at epoch %d", index, target)
+	}
+	return binary.LittleEndian.Uint64(data), true, nil
+}
+
+// attestationID returns the id of the stored indexed attestation with the given root.
+func (s *Slasher) attestationID(target common.Epoch, root common.Root) (id uint64, ok bool, err error) {
+	data, ok, err := s.store.Get(attestationIDKey(target, root))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, fmt.Errorf("invalid attestation id of %s", root)
+	}
+	return binary.LittleEndian.Uint64(data), true, nil
+}
+
+type storedAttestation struct {
+	dataRoot common.Root
+	att      phase0.IndexedAttestation
+}
+
+func (s *Slasher) attestation(target common.Epoch, id uint64) (*storedAttestation, error) {
+	data, ok, err := s.store.Get(attestationKey(target, id))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	if len(data) < 32 {
+		return nil, fmt.Errorf("invalid stored attestation %d", id)
+	}
+	var out storedAttestation
+	copy(out.dataRoot[:], data[:32])
+	data = data[32:]
+	if err := out.att.Deserialize(s.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))); err != nil {
+		return nil, fmt.Errorf("failed to decode stored attestation %d: %v", id, err)
+	}
+	return &out, nil
+}
+
+// putAttestation stores the attestation with the given id, and the id by attestation root.
+func (s *Slasher) putAttestation(id uint64, root common.Root, dataRoot common.Root, att *phase0.IndexedAttestation) error {
+	var buf bytes.Buffer
+	buf.Write(dataRoot[:])
+	if err := att.Serialize(s.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	target := att.Data.Target.Epoch
+	if err := s.store.Put(attestationKey(target, id), buf.Bytes()); err != nil {
+		return err
+	}
+	var encodedID [8]byte
+	binary.LittleEndian.PutUint64(encodedID[:], id)
+	return s.store.Put(attestationIDKey(target, root), encodedID[:])
+}
+
+// OnAttestation indexes the attestation, and returns the slashings of the attesters that made a double vote
+// or surround vote with any attestation indexed before.
+// Attestations with a target epoch before the retained history are ignored.
+func (s *Slasher) OnAttestation(att *phase0.IndexedAttestation) ([]*phase0.AttesterSlashing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source, target := att.Data.Source.Epoch, att.Data.Target.Epoch
+	if source > target {
+		return nil, fmt.Errorf("attestation source %d is after target %d", source, target)
+	}
+	if target < s.lowest {
+		return nil, nil
+	}
+	// spans are only kept within the history, as the distances are limited.
+	start := s.lowest
+	if target > s.history && target-s.history > start {
+		start = target - s.history
+	}
+	dataRoot := att.Data.HashTreeRoot(tree.GetHashFn())
+	attRoot := att.HashTreeRoot(s.spec, tree.GetHashFn())
+	id, known, err := s.attestationID(target, attRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		// the id is only used up if the attestation is stored
+		id = s.nextID
+	}
+	var encodedRecord [8]byte
+	binary.LittleEndian.PutUint64(encodedRecord[:], id)
+
+	// the existing attestations are loaded once, many attesters may refer to the same attestation.
+	loaded := make(map[uint64]*storedAttestation)
+	load := func(existingTarget common.Epoch, existingID uint64) (*storedAttestation, error) {
+		if a, ok := loaded[existingID]; ok {
+			return a, nil
+		}
+		a, err := s.attestation(existingTarget, existingID)
+		if err != nil {
+			return nil, err
+		}
+		loaded[existingID] = a
+		return a, nil
+	}
+
+	var out []*phase0.AttesterSlashing
+	// every conflicting attestation is reported once, even if multiple attesters are slashable.
+	reported := make(map[uint64]struct{})
+	report := func(existingTarget common.Epoch, index common.ValidatorIndex, existingFirst bool) error {
+		existingID, ok, err := s.record(index, existingTarget)
+		if err != nil || !ok {
+			return err
+		}
+		if _, ok := reported[existingID]; ok {
+			return nil
+		}
+		existing, err := load(existingTarget, existingID)
+		if err != nil || existing == nil || existing.dataRoot == dataRoot {
+			return err
+		}
+		sl := &phase0.AttesterSlashing{Attestation1: existing.att, Attestation2: *att}
+		if !existingFirst {
+			sl.Attestation1, sl.Attestation2 = sl.Attestation2, sl.Attestation1
+		}
+		// the record of the target may be of a different attestation than the one that set the span
+		if !phase0.IsSlashableAttestationData(&sl.Attestation1.Data, &sl.Attestation2.Data) {
+			return nil
+		}
+		reported[existingID] = struct{}{}
+		out = append(out, sl)
+		return nil
+	}
+
+	spans := newSpanChunks(s)
+	stored := false
+	metaChanged := false
+	for _, index := range att.AttestingIndices {
+		existingID, ok, err := s.record(index, target)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if existingID == id {
+				continue
+			}
+			if existing, err := load(target, existingID); err != nil {
+				return nil, err
+			} else if existing != nil && existing.dataRoot == dataRoot {
+				// already indexed the vote, the spans are up to date
+				continue
+			}
+			if err := report(target, index, true); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := s.store.Put(recordKey(target, index), encodedRecord[:]); err != nil {
+				return nil, err
+			}
+			stored = true
+		}
+		if source >= start {
+			// the new attestation surrounds an earlier attestation
+			if d, err := spans.get(prefixMinSpan, index, source); err != nil {
+				return nil, err
+			} else if d != noMinSpan && common.Epoch(d) < target-source {
+				if err := report(source+common.Epoch(d), index, false); err != nil {
+					return nil, err
+				}
+			}
+			// the new attestation is surrounded by an earlier attestation
+			if d, err := spans.get(prefixMaxSpan, index, source); err != nil {
+				return nil, err
+			} else if common.Epoch(d) > target-source {
+				if err := report(source+common.Epoch(d), index, true); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := spans.update(index, source, target, start); err != nil {
+			return nil, err
+		}
+	}
+	if err := spans.flush(); err != nil {
+		return nil, err
+	}
+	if stored {
+		if !known {
+			if err := s.putAttestation(id, attRoot, dataRoot, att); err != nil {
+				return nil, err
+			}
+			s.nextID++
+			metaChanged = true
+		}
+		if start < s.earliest {
+			s.earliest = start
+			metaChanged = true
+		}
+	}
+	if metaChanged {
+		if err := s.putMeta(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *Slasher) headers(slot common.Slot) ([]common.SignedBeaconBlockHeader, error) {
+	data, ok, err := s.store.Get(numKey(prefixHeaders, uint64(slot)))
+	if err != nil || !ok {
+		return nil, err
+	}
+	size := common.SignedBeaconBlockHeaderType.TypeByteLength()
+	if uint64(len(data))%size != 0 {
+		return nil, fmt.Errorf("invalid stored headers at slot %d", slot)
+	}
+	out := make([]common.SignedBeaconBlockHeader, uint64(len(data))/size)
+	dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+	for i := range out {
+		if err := out[i].Deserialize(dr); err != nil {
+			return nil, fmt.Errorf("failed to decode stored header at slot %d: %v", slot, err)
+		}
+	}
+	return out, nil
+}
+
+// OnBlockHeader indexes the header, and returns a slashing if the proposer signed a different header for the same slot.
+// Headers before the retained history are ignored.
+func (s *Slasher) OnBlockHeader(header *common.SignedBeaconBlockHeader) (*phase0.ProposerSlashing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := header.Message.Slot
+	epoch := s.spec.SlotToEpoch(slot)
+	if epoch < s.lowest {
+		return nil, nil
+	}
+	existing, err := s.headers(slot)
+	if err != nil {
+		return nil, err
+	}
+	for i := range existing {
+		if existing[i].Message.ProposerIndex != header.Message.ProposerIndex {
+			continue
+		}
+		if existing[i].Message == header.Message {
+			return nil, nil
+		}
+		return &phase0.ProposerSlashing{SignedHeader1: existing[i], SignedHeader2: *header}, nil
+	}
+	var buf bytes.Buffer
+	w := codec.NewEncodingWriter(&buf)
+	for _, h := range append(existing, *header) {
+		if err := h.Serialize(w); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.Put(numKey(prefixHeaders, uint64(slot)), buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if epoch < s.earliest {
+		s.earliest = epoch
+		if err := s.putMeta(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Prune removes everything before the retained history, relative to the given current epoch.
+// Every kind of data is removed with a single range delete, independent of the number of validators.
+func (s *Slasher) Prune(currentEpoch common.Epoch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if currentEpoch <= s.history {
+		return nil
+	}
+	newLowest := currentEpoch - s.history
+	if newLowest <= s.lowest {
+		return nil
+	}
+	// nothing is stored before the earliest epoch, skip it
+	from := s.lowest
+	if s.earliest > from {
+		from = s.earliest
+	}
+	if from < newLowest {
+		for _, prefix := range []byte{prefixRecord, prefixAttestation, prefixAttestationID} {
+			if err := s.store.DeleteRange(numKey(prefix, uint64(from)), numKey(prefix, uint64(newLowest))); err != nil {
+				return err
+			}
+		}
+		fromSlot, err := s.spec.EpochStartSlot(from)
+		if err != nil {
+			return err
+		}
+		toSlot, err := s.spec.EpochStartSlot(newLowest)
+		if err != nil {
+			return err
+		}
+		if err := s.store.DeleteRange(numKey(prefixHeaders, uint64(fromSlot)), numKey(prefixHeaders, uint64(toSlot))); err != nil {
+			return err
+		}
+		// only the chunks that are completely before the new lowest epoch can be removed
+		if fromChunk, toChunk := uint64(from)/ChunkSize, uint64(newLowest)/ChunkSize; fromChunk < toChunk {
+			for _, prefix := range []byte{prefixMinSpan, prefixMaxSpan} {
+				if err := s.store.DeleteRange(numKey(prefix, fromChunk), numKey(prefix, toChunk)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	s.lowest = newLowest
+	if s.earliest < newLowest {
+		s.earliest = newLowest
+	}
+	return s.putMeta()
+}
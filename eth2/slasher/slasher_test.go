@@ -0,0 +1,143 @@
+package slasher
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/db"
+)
+
+func testAtt(source common.Epoch, target common.Epoch, head byte, indices ...common.ValidatorIndex) *phase0.IndexedAttestation {
+	return &phase0.IndexedAttestation{
+		AttestingIndices: indices,
+		Data: phase0.AttestationData{
+			Slot:            common.Slot(target) * 8,
+			BeaconBlockRoot: common.Root{head},
+			Source:          common.Checkpoint{Epoch: source, Root: common.Root{byte(source)}},
+			Target:          common.Checkpoint{Epoch: target, Root: common.Root{byte(target)}},
+		},
+	}
+}
+
+func expectSlashing(t *testing.T, s *Slasher, att *phase0.IndexedAttestation, first *phase0.IndexedAttestation, slashable ...common.ValidatorIndex) {
+	t.Helper()
+	out, err := s.OnAttestation(att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slashable) == 0 {
+		if len(out) != 0 {
+			t.Fatalf("unexpected slashings: %d", len(out))
+		}
+		return
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected 1 slashing, got %d", len(out))
+	}
+	if out[0].Attestation1.Data != first.Data {
+		t.Fatal("unexpected order of slashing attestations")
+	}
+	got := out[0].EquivocatingIndices()
+	if len(got) != len(slashable) {
+		t.Fatalf("expected slashable %v, got %v", slashable, got)
+	}
+	for i := range got {
+		if got[i] != slashable[i] {
+			t.Fatalf("expected slashable %v, got %v", slashable, got)
+		}
+	}
+}
+
+func TestSlasherAttestations(t *testing.T) {
+	spec := configs.Minimal
+	store := db.NewMemStore()
+	s, err := NewSlasher(spec, store, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testAtt(1, 2, 1, 1, 2)
+	expectSlashing(t, s, a, nil)
+	// same vote again
+	expectSlashing(t, s, testAtt(1, 2, 1, 2), nil)
+	// double vote
+	b := testAtt(1, 2, 2, 2, 3)
+	expectSlashing(t, s, b, a, 2)
+	// surrounding vote
+	c := testAtt(0, 3, 3, 1, 4)
+	expectSlashing(t, s, c, c, 1)
+	// surrounded vote
+	d := testAtt(10, 40, 4, 5)
+	expectSlashing(t, s, d, nil)
+	expectSlashing(t, s, testAtt(11, 12, 4, 6), nil)
+	e := testAtt(20, 30, 5, 5, 6)
+	expectSlashing(t, s, e, d, 5)
+
+	// the history is kept in the store
+	s, err = NewSlasher(spec, store, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSlashing(t, s, testAtt(25, 35, 6, 5), d, 5)
+	expectSlashing(t, s, testAtt(11, 12, 7, 6), testAtt(11, 12, 4, 6), 6)
+
+	// after pruning, votes before the history are ignored
+	if err := s.Prune(64 + 15); err != nil {
+		t.Fatal(err)
+	}
+	// the first attestation, with id 0, and its records are removed
+	if a, err := s.attestation(2, 0); err != nil || a != nil {
+		t.Fatal("expected the attestation to be pruned")
+	}
+	if _, ok, err := s.record(1, 2); err != nil || ok {
+		t.Fatal("expected the record to be pruned")
+	}
+	expectSlashing(t, s, testAtt(1, 2, 8, 1), nil)
+	expectSlashing(t, s, testAtt(11, 12, 8, 6), nil)
+	// the votes with a retained target are still known
+	expectSlashing(t, s, testAtt(21, 33, 8, 5), d, 5)
+	f := testAtt(19, 41, 9, 5)
+	expectSlashing(t, s, f, f, 5)
+}
+
+func TestSlasherBlockHeaders(t *testing.T) {
+	spec := configs.Minimal
+	store, err := db.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSlasher(spec, store, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := func(slot common.Slot, proposer common.ValidatorIndex, body byte) *common.SignedBeaconBlockHeader {
+		return &common.SignedBeaconBlockHeader{
+			Message: common.BeaconBlockHeader{Slot: slot, ProposerIndex: proposer, BodyRoot: common.Root{body}},
+		}
+	}
+	for _, h := range []*common.SignedBeaconBlockHeader{
+		header(10, 3, 1),
+		header(10, 3, 1),
+		header(10, 4, 2),
+	} {
+		if sl, err := s.OnBlockHeader(h); err != nil {
+			t.Fatal(err)
+		} else if sl != nil {
+			t.Fatal("unexpected proposer slashing")
+		}
+	}
+	sl, err := s.OnBlockHeader(header(10, 4, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl == nil || sl.SignedHeader1.Message.BodyRoot != (common.Root{2}) || sl.SignedHeader2.Message.BodyRoot != (common.Root{3}) {
+		t.Fatal("expected proposer slashing")
+	}
+	if err := s.Prune(64 + 2); err != nil {
+		t.Fatal(err)
+	}
+	if sl, err := s.OnBlockHeader(header(10, 4, 3)); err != nil || sl != nil {
+		t.Fatal("expected old header to be ignored")
+	}
+}
@@ -0,0 +1,135 @@
+package slasher
+
+import (
+	"encoding/binary"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// ChunkSize is the number of epochs of spans of a validator that are stored together.
+const ChunkSize = 16
+
+// Spans are stored as 16 bit distances from the epoch to the target epoch.
+const (
+	// noMinSpan is the min span of an epoch without any attestations with a later source.
+	noMinSpan uint16 = 0xffff
+	// maxDistance is the largest distance that can be stored.
+	maxDistance = common.Epoch(noMinSpan - 1)
+)
+
+func spanKey(prefix byte, index common.ValidatorIndex, chunk uint64) []byte {
+	key := make([]byte, 1+8+8)
+	key[0] = prefix
+	binary.BigEndian.PutUint64(key[1:], chunk)
+	binary.BigEndian.PutUint64(key[9:], uint64(index))
+	return key
+}
+
+// spanChunks caches the span chunks that are read and modified while indexing an attestation.
+//
+// For a validator and epoch e, the spans are:
+//   - min span: the minimum target - e of the attestations with source > e.
+//     A new attestation (s, t) surrounds an earlier attestation if min_span[s] < t - s.
+//   - max span: the maximum target - e of the attestations with source < e.
+//     A new attestation (s, t) is surrounded by an earlier attestation if max_span[s] > t - s.
+type spanChunks struct {
+	s      *Slasher
+	chunks map[string][]byte
+	dirty  map[string]struct{}
+}
+
+func newSpanChunks(s *Slasher) *spanChunks {
+	return &spanChunks{s: s, chunks: make(map[string][]byte), dirty: make(map[string]struct{})}
+}
+
+func (c *spanChunks) chunk(prefix byte, index common.ValidatorIndex, epoch common.Epoch) ([]byte, error) {
+	key := string(spanKey(prefix, index, uint64(epoch)/ChunkSize))
+	if data, ok := c.chunks[key]; ok {
+		return data, nil
+	}
+	data, ok, err := c.s.store.Get([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(data) != 2*ChunkSize {
+		data = make([]byte, 2*ChunkSize)
+		if prefix == prefixMinSpan {
+			for i := range data {
+				data[i] = 0xff
+			}
+		}
+	}
+	c.chunks[key] = data
+	return data, nil
+}
+
+func (c *spanChunks) get(prefix byte, index common.ValidatorIndex, epoch common.Epoch) (uint16, error) {
+	data, err := c.chunk(prefix, index, epoch)
+	if err != nil {
+		return 0, err
+	}
+	i := 2 * (uint64(epoch) % ChunkSize)
+	return binary.LittleEndian.Uint16(data[i : i+2]), nil
+}
+
+func (c *spanChunks) set(prefix byte, index common.ValidatorIndex, epoch common.Epoch, v uint16) error {
+	data, err := c.chunk(prefix, index, epoch)
+	if err != nil {
+		return err
+	}
+	i := 2 * (uint64(epoch) % ChunkSize)
+	binary.LittleEndian.PutUint16(data[i:i+2], v)
+	c.dirty[string(spanKey(prefix, index, uint64(epoch)/ChunkSize))] = struct{}{}
+	return nil
+}
+
+// update the spans of the validator with a new attestation, epochs before start are not updated.
+func (c *spanChunks) update(index common.ValidatorIndex, source common.Epoch, target common.Epoch, start common.Epoch) error {
+	// min spans: the epochs before the source, the distance only grows, stop when the existing span is smaller.
+	for e := source; e > start; {
+		e--
+		d := target - e
+		if d > maxDistance {
+			break
+		}
+		if cur, err := c.get(prefixMinSpan, index, e); err != nil {
+			return err
+		} else if common.Epoch(cur) <= d {
+			break
+		}
+		if err := c.set(prefixMinSpan, index, e, uint16(d)); err != nil {
+			return err
+		}
+	}
+	// max spans: the epochs between source and target, stop when the existing span is larger.
+	e := source + 1
+	if e < start {
+		e = start
+	}
+	for ; e < target; e++ {
+		d := target - e
+		if d > maxDistance {
+			continue
+		}
+		if cur, err := c.get(prefixMaxSpan, index, e); err != nil {
+			return err
+		} else if common.Epoch(cur) >= d {
+			break
+		}
+		if err := c.set(prefixMaxSpan, index, e, uint16(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes the modified chunks to the store.
+func (c *spanChunks) flush() error {
+	for key := range c.dirty {
+		if err := c.s.store.Put([]byte(key), c.chunks[key]); err != nil {
+			return err
+		}
+	}
+	c.dirty = make(map[string]struct{})
+	return nil
+}
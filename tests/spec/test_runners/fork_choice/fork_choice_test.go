@@ -0,0 +1,609 @@
+package fork_choice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/chain"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/execution"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// INTERVALS_PER_SLOT of the fork choice spec: blocks are timely if received in the first interval of their slot.
+const intervalsPerSlot = 3
+
+type HeadCheck struct {
+	Slot common.Slot `yaml:"slot"`
+	Root common.Root `yaml:"root"`
+}
+
+// Checks are compared against the store after the steps so far.
+// Checks that are not listed here (e.g. of proposer re-orgs) are not supported, and ignored.
+type Checks struct {
+	Head                *HeadCheck         `yaml:"head"`
+	Time                *common.Timestamp  `yaml:"time"`
+	GenesisTime         *common.Timestamp  `yaml:"genesis_time"`
+	JustifiedCheckpoint *common.Checkpoint `yaml:"justified_checkpoint"`
+	FinalizedCheckpoint *common.Checkpoint `yaml:"finalized_checkpoint"`
+	ProposerBoostRoot   *common.Root       `yaml:"proposer_boost_root"`
+}
+
+// Step is one entry of the steps.yaml file, only one of the inputs is set.
+type Step struct {
+	Tick             *common.Timestamp      `yaml:"tick"`
+	Block            *string                `yaml:"block"`
+	Blobs            *string                `yaml:"blobs"`
+	Attestation      *string                `yaml:"attestation"`
+	AttesterSlashing *string                `yaml:"attester_slashing"`
+	PowBlock         *string                `yaml:"pow_block"`
+	PayloadStatus    map[string]interface{} `yaml:"payload_status"`
+	// Valid is false if the input is expected to be rejected. Defaults to true.
+	Valid  *bool   `yaml:"valid"`
+	Checks *Checks `yaml:"checks"`
+}
+
+func (s *Step) ExpectValid() bool {
+	return s.Valid == nil || *s.Valid
+}
+
+type storeEntry struct {
+	slot       common.Slot
+	parentRoot common.Root
+	state      common.BeaconState
+	epc        *common.EpochsContext
+}
+
+// Store mirrors the spec fork choice Store: it keeps the post-states of the blocks,
+// and the clock, unrealized checkpoints and checkpoint states, around a ProtoForkChoice.
+type Store struct {
+	spec        *common.Spec
+	genesisTime common.Timestamp
+	time        common.Timestamp
+	fc          forkchoice.Forkchoice
+	// post-block states, by block root
+	blocks           map[common.Root]*storeEntry
+	checkpointStates map[common.Checkpoint]*storeEntry
+	// The best unrealized checkpoints of all blocks, realized at the start of the next epoch.
+	unrealizedJustified common.Checkpoint
+	unrealizedFinalized common.Checkpoint
+}
+
+func NewStore(spec *common.Spec, anchorState common.BeaconState) (*Store, error) {
+	slot, err := anchorState.Slot()
+	if err != nil {
+		return nil, err
+	}
+	genesisTime, err := anchorState.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	header, err := anchorState.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The anchor block is the latest block header, with the state root filled in.
+	if header.StateRoot == (common.Root{}) {
+		header.StateRoot = anchorState.HashTreeRoot(tree.GetHashFn())
+	}
+	anchorRoot := header.HashTreeRoot(tree.GetHashFn())
+	epc, err := common.NewEpochsContext(spec, anchorState)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		spec:             spec,
+		genesisTime:      genesisTime,
+		time:             genesisTime + spec.SECONDS_PER_SLOT*common.Timestamp(slot),
+		blocks:           make(map[common.Root]*storeEntry),
+		checkpointStates: make(map[common.Checkpoint]*storeEntry),
+	}
+	s.blocks[anchorRoot] = &storeEntry{slot: slot, parentRoot: header.ParentRoot, state: anchorState, epc: epc}
+	anchorCheckpoint := common.Checkpoint{Epoch: spec.SlotToEpoch(slot), Root: anchorRoot}
+	s.unrealizedJustified = anchorCheckpoint
+	s.unrealizedFinalized = anchorCheckpoint
+	balances, totalActive, err := s.checkpointBalances(anchorCheckpoint)
+	if err != nil {
+		return nil, err
+	}
+	s.fc, err = proto.NewProtoForkChoice(spec, anchorCheckpoint, anchorCheckpoint,
+		anchorRoot, slot, header.ParentRoot, balances, totalActive, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) currentSlot() common.Slot {
+	return common.Slot((s.time - s.genesisTime) / s.spec.SECONDS_PER_SLOT)
+}
+
+// checkpointState returns the state of the checkpoint block, advanced to the start of the checkpoint epoch.
+func (s *Store) checkpointState(cp common.Checkpoint) (*storeEntry, error) {
+	if entry, ok := s.checkpointStates[cp]; ok {
+		return entry, nil
+	}
+	block, ok := s.blocks[cp.Root]
+	if !ok {
+		return nil, fmt.Errorf("unknown checkpoint block %s", cp.Root)
+	}
+	slot, err := s.spec.EpochStartSlot(cp.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	entry := block
+	if block.slot < slot {
+		state, err := block.state.CopyState()
+		if err != nil {
+			return nil, err
+		}
+		epc := block.epc.Clone()
+		upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+		if err := common.ProcessSlots(context.Background(), s.spec, epc, upgradeable, slot); err != nil {
+			return nil, err
+		}
+		entry = &storeEntry{slot: slot, parentRoot: cp.Root, state: upgradeable.BeaconState, epc: epc}
+	}
+	s.checkpointStates[cp] = entry
+	return entry, nil
+}
+
+// checkpointBalances returns the vote weights of the validators, like get_weight in the spec:
+// the effective balance of unslashed validators that are active in the checkpoint epoch.
+// The total active balance includes slashed validators, like get_total_active_balance in the spec.
+func (s *Store) checkpointBalances(cp common.Checkpoint) (out []common.Gwei, totalActive common.Gwei, err error) {
+	entry, err := s.checkpointState(cp)
+	if err != nil {
+		return nil, 0, err
+	}
+	vals, err := entry.state.Validators()
+	if err != nil {
+		return nil, 0, err
+	}
+	flat, err := common.FlattenValidators(vals)
+	if err != nil {
+		return nil, 0, err
+	}
+	out = make([]common.Gwei, len(flat))
+	for i := range flat {
+		if !flat[i].IsActive(cp.Epoch) {
+			continue
+		}
+		totalActive += flat[i].EffectiveBalance
+		if !flat[i].Slashed {
+			out[i] = flat[i].EffectiveBalance
+		}
+	}
+	return out, totalActive, nil
+}
+
+// ancestor returns the root of the block at or before the given slot, in the chain of the given block.
+func (s *Store) ancestor(root common.Root, slot common.Slot) (common.Root, error) {
+	for {
+		entry, ok := s.blocks[root]
+		if !ok {
+			return common.Root{}, fmt.Errorf("unknown block %s", root)
+		}
+		if entry.slot <= slot {
+			return root, nil
+		}
+		if _, ok := s.blocks[entry.parentRoot]; !ok {
+			// nothing before the anchor is known
+			return root, nil
+		}
+		root = entry.parentRoot
+	}
+}
+
+// ensureNode adds the empty slot node of the block at the given slot, if the block is before the slot.
+// The empty slots continue the justification and finalization of the block.
+func (s *Store) ensureNode(root common.Root, slot common.Slot) error {
+	entry, ok := s.blocks[root]
+	if !ok {
+		return fmt.Errorf("unknown block %s", root)
+	}
+	if entry.slot >= slot {
+		return nil
+	}
+	justified, err := entry.state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	finalized, err := entry.state.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	s.fc.ProcessSlot(root, slot, justified.Epoch, finalized.Epoch)
+	return nil
+}
+
+func (s *Store) updateCheckpoints(trigger common.Root, justified common.Checkpoint, finalized common.Checkpoint) error {
+	prevJustified, prevFinalized := s.fc.Justified(), s.fc.Finalized()
+	if justified.Epoch <= prevJustified.Epoch && finalized.Epoch <= prevFinalized.Epoch {
+		return nil
+	}
+	if justified.Epoch <= prevJustified.Epoch {
+		justified = prevJustified
+	}
+	if finalized.Epoch <= prevFinalized.Epoch {
+		finalized = prevFinalized
+	}
+	// The forkchoice starts the head search at the justified checkpoint, it needs a node at the epoch start.
+	justifiedSlot, err := s.spec.EpochStartSlot(justified.Epoch)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureNode(justified.Root, justifiedSlot); err != nil {
+		return err
+	}
+	return s.fc.UpdateJustified(context.Background(), trigger, justified, finalized, func() ([]common.Gwei, common.Gwei, error) {
+		return s.checkpointBalances(justified)
+	})
+}
+
+func (s *Store) OnTick(time common.Timestamp) error {
+	tickSlot := common.Slot((time - s.genesisTime) / s.spec.SECONDS_PER_SLOT)
+	// Process every slot in between, the unrealized checkpoints are realized at the start of every epoch.
+	for s.currentSlot() < tickSlot {
+		slot := s.currentSlot() + 1
+		s.time = s.genesisTime + s.spec.SECONDS_PER_SLOT*common.Timestamp(slot)
+		s.fc.OnSlot(slot)
+		if slot%s.spec.SLOTS_PER_EPOCH == 0 {
+			if err := s.updateCheckpoints(s.unrealizedJustified.Root, s.unrealizedJustified, s.unrealizedFinalized); err != nil {
+				return err
+			}
+		}
+	}
+	if time > s.time {
+		s.time = time
+	}
+	return nil
+}
+
+func (s *Store) OnBlock(block *common.BeaconBlockEnvelope) error {
+	parent, ok := s.blocks[block.ParentRoot]
+	if !ok {
+		return fmt.Errorf("unknown parent block %s", block.ParentRoot)
+	}
+	if block.Slot > s.currentSlot() {
+		return fmt.Errorf("block slot %d is in the future, current slot is %d", block.Slot, s.currentSlot())
+	}
+	finalized := s.fc.Finalized()
+	finalizedSlot, err := s.spec.EpochStartSlot(finalized.Epoch)
+	if err != nil {
+		return err
+	}
+	if block.Slot <= finalizedSlot {
+		return fmt.Errorf("block slot %d is not after finalized slot %d", block.Slot, finalizedSlot)
+	}
+	if ancestor, err := s.ancestor(block.ParentRoot, finalizedSlot); err != nil || ancestor != finalized.Root {
+		return errors.New("block does not descend from the finalized checkpoint")
+	}
+	if _, ok := s.blocks[block.BlockRoot]; ok {
+		return nil
+	}
+	state, err := parent.state.CopyState()
+	if err != nil {
+		return err
+	}
+	epc := parent.epc.Clone()
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	if err := common.StateTransition(context.Background(), s.spec, epc, upgradeable, block, true); err != nil {
+		return fmt.Errorf("failed to process block %s: %v", block.BlockRoot, err)
+	}
+	state = upgradeable.BeaconState
+	justified, err := state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	finalized, err = state.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	unrealizedJustified, unrealizedFinalized, err := chain.UnrealizedCheckpoints(context.Background(), s.spec, epc, state)
+	if err != nil {
+		return err
+	}
+	if !s.fc.ProcessBlock(block.ParentRoot, block.BlockRoot, block.Slot, justified.Epoch, finalized.Epoch,
+		unrealizedJustified.Epoch, unrealizedFinalized.Epoch, beacon.ExecutionBlockHash(block)) {
+		return fmt.Errorf("forkchoice did not accept block %s", block.BlockRoot)
+	}
+	s.blocks[block.BlockRoot] = &storeEntry{slot: block.Slot, parentRoot: block.ParentRoot, state: state, epc: epc}
+
+	// Only the first timely block of the slot is boosted.
+	timeIntoSlot := (s.time - s.genesisTime) % s.spec.SECONDS_PER_SLOT
+	isTimely := s.currentSlot() == block.Slot && timeIntoSlot < s.spec.SECONDS_PER_SLOT/intervalsPerSlot
+	if isTimely && s.fc.ProposerBoost() == (forkchoice.NodeRef{}) {
+		s.fc.SetProposerBoost(block.BlockRoot, block.Slot)
+	}
+
+	if err := s.updateCheckpoints(block.BlockRoot, justified, finalized); err != nil {
+		return err
+	}
+	if unrealizedJustified.Epoch > s.unrealizedJustified.Epoch {
+		s.unrealizedJustified = unrealizedJustified
+	}
+	if unrealizedFinalized.Epoch > s.unrealizedFinalized.Epoch {
+		s.unrealizedFinalized = unrealizedFinalized
+	}
+	// Blocks from a previous epoch are pulled up: their unrealized checkpoints would have been realized already.
+	if s.spec.SlotToEpoch(block.Slot) < s.spec.SlotToEpoch(s.currentSlot()) {
+		return s.updateCheckpoints(block.BlockRoot, unrealizedJustified, unrealizedFinalized)
+	}
+	return nil
+}
+
+func (s *Store) OnAttestation(att *phase0.Attestation, isFromBlock bool) error {
+	data := &att.Data
+	currentEpoch := s.spec.SlotToEpoch(s.currentSlot())
+	if !isFromBlock {
+		previousEpoch := currentEpoch.Previous()
+		if data.Target.Epoch != currentEpoch && data.Target.Epoch != previousEpoch {
+			return fmt.Errorf("attestation target epoch %d is not the current or previous epoch", data.Target.Epoch)
+		}
+	}
+	if data.Target.Epoch != s.spec.SlotToEpoch(data.Slot) {
+		return errors.New("attestation target epoch does not match attestation slot")
+	}
+	if _, ok := s.blocks[data.Target.Root]; !ok {
+		return fmt.Errorf("unknown attestation target %s", data.Target.Root)
+	}
+	block, ok := s.blocks[data.BeaconBlockRoot]
+	if !ok {
+		return fmt.Errorf("unknown attested block %s", data.BeaconBlockRoot)
+	}
+	if block.slot > data.Slot {
+		return errors.New("attested block is after the attestation slot")
+	}
+	targetSlot, err := s.spec.EpochStartSlot(data.Target.Epoch)
+	if err != nil {
+		return err
+	}
+	if ancestor, err := s.ancestor(data.BeaconBlockRoot, targetSlot); err != nil || ancestor != data.Target.Root {
+		return errors.New("attestation target does not match the attested block")
+	}
+	// Attestations, also those included in blocks, can only affect the fork choice of subsequent slots.
+	if data.Slot >= s.currentSlot() {
+		return fmt.Errorf("attestation of slot %d is too early, current slot is %d", data.Slot, s.currentSlot())
+	}
+	target, err := s.checkpointState(data.Target)
+	if err != nil {
+		return err
+	}
+	committee, err := target.epc.GetBeaconCommittee(data.Slot, data.Index)
+	if err != nil {
+		return err
+	}
+	indexed, err := att.ConvertToIndexed(s.spec, committee)
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, target.epc, target.state, indexed); err != nil {
+		return fmt.Errorf("invalid attestation: %v", err)
+	}
+	// Votes are slot-accurate: the vote is for the block, at the attestation slot.
+	if err := s.ensureNode(data.BeaconBlockRoot, data.Slot); err != nil {
+		return err
+	}
+	for _, index := range indexed.AttestingIndices {
+		s.fc.ProcessAttestation(index, data.BeaconBlockRoot, data.Slot)
+	}
+	return nil
+}
+
+func (s *Store) OnAttesterSlashing(sl *phase0.AttesterSlashing) error {
+	if !phase0.IsSlashableAttestationData(&sl.Attestation1.Data, &sl.Attestation2.Data) {
+		return errors.New("attester slashing attestations are not slashable")
+	}
+	justified, err := s.checkpointState(s.fc.Justified())
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, justified.epc, justified.state, &sl.Attestation1); err != nil {
+		return fmt.Errorf("invalid attestation 1: %v", err)
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, justified.epc, justified.state, &sl.Attestation2); err != nil {
+		return fmt.Errorf("invalid attestation 2: %v", err)
+	}
+	s.fc.ProcessEquivocation(sl.EquivocatingIndices())
+	return nil
+}
+
+func (s *Store) Check(t *testing.T, checks *Checks) {
+	t.Helper()
+	if checks.Head != nil {
+		head, err := s.fc.Head()
+		if err != nil {
+			t.Fatalf("failed to get head: %v", err)
+		}
+		// The head may be an empty slot node, the spec head is the block of the node.
+		slot, _ := s.fc.GetSlot(head.Root)
+		if head.Root != checks.Head.Root || slot != checks.Head.Slot {
+			t.Errorf("different head: got %s:%d, expected %s:%d", head.Root, slot, checks.Head.Root, checks.Head.Slot)
+		}
+	}
+	if checks.Time != nil && s.time != *checks.Time {
+		t.Errorf("different time: got %d, expected %d", s.time, *checks.Time)
+	}
+	if checks.GenesisTime != nil && s.genesisTime != *checks.GenesisTime {
+		t.Errorf("different genesis time: got %d, expected %d", s.genesisTime, *checks.GenesisTime)
+	}
+	if checks.JustifiedCheckpoint != nil && s.fc.Justified() != *checks.JustifiedCheckpoint {
+		t.Errorf("different justified checkpoint: got %s, expected %s", s.fc.Justified(), *checks.JustifiedCheckpoint)
+	}
+	if checks.FinalizedCheckpoint != nil && s.fc.Finalized() != *checks.FinalizedCheckpoint {
+		t.Errorf("different finalized checkpoint: got %s, expected %s", s.fc.Finalized(), *checks.FinalizedCheckpoint)
+	}
+	if checks.ProposerBoostRoot != nil && s.fc.ProposerBoost().Root != *checks.ProposerBoostRoot {
+		t.Errorf("different proposer boost root: got %s, expected %s", s.fc.ProposerBoost().Root, *checks.ProposerBoostRoot)
+	}
+}
+
+func loadBlock(t *testing.T, forkName test_util.ForkName, name string, valRoot common.Root, readPart test_util.TestPartReader) *common.BeaconBlockEnvelope {
+	spec := readPart.Spec()
+	var dst interface {
+		common.SpecObj
+		Envelope(spec *common.Spec, digest common.ForkDigest) *common.BeaconBlockEnvelope
+	}
+	var version common.Version
+	switch forkName {
+	case "phase0":
+		dst, version = new(phase0.SignedBeaconBlock), spec.GENESIS_FORK_VERSION
+	case "altair":
+		dst, version = new(altair.SignedBeaconBlock), spec.ALTAIR_FORK_VERSION
+	case "bellatrix":
+		dst, version = new(bellatrix.SignedBeaconBlock), spec.BELLATRIX_FORK_VERSION
+	case "capella":
+		dst, version = new(capella.SignedBeaconBlock), spec.CAPELLA_FORK_VERSION
+	case "deneb":
+		dst, version = new(deneb.SignedBeaconBlock), spec.DENEB_FORK_VERSION
+	default:
+		t.Fatalf("unrecognized fork name: %s", forkName)
+	}
+	if !test_util.LoadSpecObj(t, name, dst, readPart) {
+		t.Fatalf("missing block %s", name)
+	}
+	return dst.Envelope(spec, common.ComputeForkDigest(version, valRoot))
+}
+
+// blockOperations returns the attestations and attester slashings of the block body.
+func blockOperations(body common.SpecObj) (phase0.Attestations, phase0.AttesterSlashings) {
+	switch b := body.(type) {
+	case *phase0.BeaconBlockBody:
+		return b.Attestations, b.AttesterSlashings
+	case *altair.BeaconBlockBody:
+		return b.Attestations, b.AttesterSlashings
+	case *bellatrix.BeaconBlockBody:
+		return b.Attestations, b.AttesterSlashings
+	case *capella.BeaconBlockBody:
+		return b.Attestations, b.AttesterSlashings
+	case *deneb.BeaconBlockBody:
+		return b.Attestations, b.AttesterSlashings
+	default:
+		return nil, nil
+	}
+}
+
+func runCase(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+	anchorState := test_util.LoadState(t, forkName, "anchor_state", readPart)
+	if anchorState == nil {
+		t.Fatalf("failed to load anchor state")
+	}
+	valRoot, err := anchorState.GenesisValidatorsRoot()
+	test_util.Check(t, err)
+
+	p := readPart.Part("steps.yaml")
+	dec := yaml.NewDecoder(p)
+	var steps []Step
+	test_util.Check(t, dec.Decode(&steps))
+	test_util.Check(t, p.Close())
+
+	store, err := NewStore(readPart.Spec(), anchorState)
+	test_util.Check(t, err)
+
+	for i := range steps {
+		step := &steps[i]
+		var err error
+		switch {
+		case step.Tick != nil:
+			err = store.OnTick(*step.Tick)
+		case step.Block != nil:
+			if step.Blobs != nil && !step.ExpectValid() {
+				t.Skip("blob availability is not checked")
+			}
+			block := loadBlock(t, forkName, *step.Block, valRoot, readPart)
+			err = store.OnBlock(block)
+			if err == nil {
+				// The operations of the block count towards the forkchoice too.
+				atts, slashings := blockOperations(block.Body)
+				for j := range atts {
+					// Like on_block, attestations that fail validation are ignored.
+					_ = store.OnAttestation(&atts[j], true)
+				}
+				for j := range slashings {
+					_ = store.OnAttesterSlashing(&slashings[j])
+				}
+			}
+		case step.Attestation != nil:
+			att := new(phase0.Attestation)
+			if !test_util.LoadSpecObj(t, *step.Attestation, att, readPart) {
+				t.Fatalf("missing attestation %s", *step.Attestation)
+			}
+			err = store.OnAttestation(att, false)
+		case step.AttesterSlashing != nil:
+			sl := new(phase0.AttesterSlashing)
+			if !test_util.LoadSpecObj(t, *step.AttesterSlashing, sl, readPart) {
+				t.Fatalf("missing attester slashing %s", *step.AttesterSlashing)
+			}
+			err = store.OnAttesterSlashing(sl)
+		case step.PowBlock != nil, step.PayloadStatus != nil:
+			t.Skip("execution layer steps are not supported")
+		case step.Checks != nil:
+			store.Check(t, step.Checks)
+			continue
+		default:
+			t.Fatalf("step %d: unrecognized step", i)
+		}
+		if step.ExpectValid() && err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		} else if !step.ExpectValid() && err == nil {
+			t.Fatalf("step %d: expected error, but step was accepted", i)
+		}
+	}
+}
+
+func runHandler(t *testing.T, handler string) {
+	caseRunner := test_util.HandleBLS(runCase)
+	t.Run("minimal", func(t *testing.T) {
+		spec := *configs.Minimal
+		spec.ExecutionEngine = &execution.NoOpExecutionEngine{}
+		for _, fork := range test_util.AllForks {
+			t.Run(string(fork), func(t *testing.T) {
+				test_util.RunHandler(t, "fork_choice/"+handler, caseRunner, &spec, fork)
+			})
+		}
+	})
+	t.Run("mainnet", func(t *testing.T) {
+		spec := *configs.Mainnet
+		spec.ExecutionEngine = &execution.NoOpExecutionEngine{}
+		for _, fork := range test_util.AllForks {
+			t.Run(string(fork), func(t *testing.T) {
+				test_util.RunHandler(t, "fork_choice/"+handler, caseRunner, &spec, fork)
+			})
+		}
+	})
+}
+
+func TestGetHead(t *testing.T) {
+	runHandler(t, "get_head")
+}
+
+func TestOnBlock(t *testing.T) {
+	runHandler(t, "on_block")
+}
+
+func TestExAnte(t *testing.T) {
+	runHandler(t, "ex_ante")
+}
+
+func TestReorg(t *testing.T) {
+	runHandler(t, "reorg")
+}
+
+func TestWithholding(t *testing.T) {
+	runHandler(t, "withholding")
+}